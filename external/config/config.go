@@ -21,14 +21,16 @@ type (
 	}
 
 	Config struct {
-		Env          string `yaml:"env"`
-		HTTPServer   `yaml:"http_server"`
-		WSServer     `yaml:"ws_server"`
-		ENVState     `yaml:"env_state"`
-		MongoDB      `yaml:"mongodb"`
-		DB           `yaml:"db"`
-		VideoService `yaml:"video_service"`
-		JWT          string `yaml:"jwt_secret" env:"JWT_SECRET"`
+		Env           string `yaml:"env"`
+		HTTPServer    `yaml:"http_server"`
+		WSServer      `yaml:"ws_server"`
+		ENVState      `yaml:"env_state"`
+		MongoDB       `yaml:"mongodb"`
+		DB            `yaml:"db"`
+		VideoService  `yaml:"video_service"`
+		UploadService `yaml:"upload_service"`
+		Storage       `yaml:"storage"`
+		JWT           string `yaml:"jwt_secret" env:"JWT_SECRET"`
 	}
 
 	DB struct {
@@ -76,9 +78,122 @@ type (
 	}
 
 	VideoService struct {
-		VideoPath                 string            `yaml:"video_path" env:"VIDEO_PATH" env-default:"videos"`
-		TranscodeVideoWorkerCount int               `yaml:"transcode_worker_count" env:"TRANSCODE_WORKER_COUNT" env-default:"1"`
-		Resolutions               map[string]string `yaml:"resolutions" env:"RESOLUTIONS" env-default:"640x360:360,854x480:480,1280x720:720,1920x1080:1080"`
+		VideoPath                   string                     `yaml:"video_path" env:"VIDEO_PATH" env-default:"videos"`
+		TranscodeVideoWorkerCount   int                        `yaml:"transcode_worker_count" env:"TRANSCODE_WORKER_COUNT" env-default:"1"`
+		Resolutions                 map[string]string          `yaml:"resolutions" env:"RESOLUTIONS" env-default:"640x360:360,854x480:480,1280x720:720,1920x1080:1080"`
+		TranscodeLeaseDuration      time.Duration              `yaml:"transcode_lease_duration" env:"TRANSCODE_LEASE_DURATION" env-default:"2m"`
+		TranscodePollInterval       time.Duration              `yaml:"transcode_poll_interval" env:"TRANSCODE_POLL_INTERVAL" env-default:"5s"`
+		TranscodeMaxAttempts        int                        `yaml:"transcode_max_attempts" env:"TRANSCODE_MAX_ATTEMPTS" env-default:"3"`
+		TranscodeBackoffBase        time.Duration              `yaml:"transcode_backoff_base" env:"TRANSCODE_BACKOFF_BASE" env-default:"30s"`
+		TranscodeBackoffMax         time.Duration              `yaml:"transcode_backoff_max" env:"TRANSCODE_BACKOFF_MAX" env-default:"15m"`
+		TranscodeBackoffJitter      float64                    `yaml:"transcode_backoff_jitter" env:"TRANSCODE_BACKOFF_JITTER" env-default:"0.2"`
+		TranscodeWorkerRestartDelay time.Duration              `yaml:"transcode_worker_restart_delay" env:"TRANSCODE_WORKER_RESTART_DELAY" env-default:"5s"`
+		TranscodeJobTimeout         time.Duration              `yaml:"transcode_job_timeout" env:"TRANSCODE_JOB_TIMEOUT" env-default:"2h"`
+		TranscodeDrainTimeout       time.Duration              `yaml:"transcode_drain_timeout" env:"TRANSCODE_DRAIN_TIMEOUT" env-default:"10s"`
+		DeduplicateUploads          bool                       `yaml:"deduplicate_uploads" env:"DEDUPLICATE_UPLOADS" env-default:"true"`
+		HLSTime                     int                        `yaml:"hls_time" env:"HLS_TIME" env-default:"10"`
+		DASH                        bool                       `yaml:"dash" env:"DASH_ENABLED" env-default:"false"`
+		MediaCacheTTL               time.Duration              `yaml:"media_cache_ttl" env:"MEDIA_CACHE_TTL" env-default:"30s"`
+		EncodingProfiles            map[string]EncodingProfile `yaml:"encoding_profiles"`
+		RenditionProfiles           map[string]string          `yaml:"rendition_profiles" env:"RENDITION_PROFILES"`
+		DefaultEncodingProfile      string                     `yaml:"default_encoding_profile" env:"DEFAULT_ENCODING_PROFILE" env-default:"default"`
+		MediaPolicy                 MediaPolicy                `yaml:"media_policy"`
+		Images                      Images                     `yaml:"images"`
+		Encryption                  Encryption                 `yaml:"encryption"`
+		SignedURLs                  SignedURLs                 `yaml:"signed_urls"`
+	}
+
+	// SignedURLs configures the tokens appended to the URIs of the playlists and DASH manifests served to clients,
+	// so players can fetch media playlists, segments and keys without an Authorization header
+	SignedURLs struct {
+		// Secret signs the tokens, signing is disabled when it is empty
+		Secret string        `yaml:"secret" env:"SIGNED_URLS_SECRET"`
+		TTL    time.Duration `yaml:"ttl" env:"SIGNED_URLS_TTL" env-default:"1h"`
+		// BindIP restricts a token to the client IP it was issued to
+		BindIP bool `yaml:"bind_ip" env:"SIGNED_URLS_BIND_IP" env-default:"false"`
+	}
+
+	// Encryption configures the AES-128 encryption of HLS segments, every video getting its own keys
+	Encryption struct {
+		Enabled bool `yaml:"enabled" env:"ENCRYPTION_ENABLED" env-default:"false"`
+		// KeyRotation is the number of segments encrypted with the same key, zero keeps a single key per video
+		KeyRotation int `yaml:"key_rotation" env:"ENCRYPTION_KEY_ROTATION" env-default:"0"`
+	}
+
+	// Images configures the poster, thumbnails and storyboard extracted from every source
+	Images struct {
+		// PosterOffset is where the poster is taken from, zero picks the most representative frame
+		PosterOffset        time.Duration `yaml:"poster_offset" env:"IMAGES_POSTER_OFFSET" env-default:"0s"`
+		PosterWidth         int           `yaml:"poster_width" env:"IMAGES_POSTER_WIDTH" env-default:"1280"`
+		PosterMaxSize       int64         `yaml:"poster_max_size" env:"IMAGES_POSTER_MAX_SIZE" env-default:"10485760"`
+		ThumbnailInterval   time.Duration `yaml:"thumbnail_interval" env:"IMAGES_THUMBNAIL_INTERVAL" env-default:"10s"`
+		ThumbnailWidth      int           `yaml:"thumbnail_width" env:"IMAGES_THUMBNAIL_WIDTH" env-default:"320"`
+		StoryboardInterval  time.Duration `yaml:"storyboard_interval" env:"IMAGES_STORYBOARD_INTERVAL" env-default:"5s"`
+		StoryboardTileWidth int           `yaml:"storyboard_tile_width" env:"IMAGES_STORYBOARD_TILE_WIDTH" env-default:"160"`
+		StoryboardColumns   int           `yaml:"storyboard_columns" env:"IMAGES_STORYBOARD_COLUMNS" env-default:"10"`
+		StoryboardRows      int           `yaml:"storyboard_rows" env:"IMAGES_STORYBOARD_ROWS" env-default:"10"`
+		// KeepSource keeps the source once transcoded, so a poster can be picked from any timestamp of it
+		KeepSource bool `yaml:"keep_source" env:"IMAGES_KEEP_SOURCE" env-default:"true"`
+	}
+
+	// MediaPolicy lists what a source must satisfy to be accepted for transcoding
+	MediaPolicy struct {
+		AllowedContainers  []string      `yaml:"allowed_containers" env:"MEDIA_ALLOWED_CONTAINERS" env-default:"mov,mp4,m4a,3gp,3g2,mj2,matroska,webm,avi,mpegts,mpeg"`
+		AllowedVideoCodecs []string      `yaml:"allowed_video_codecs" env:"MEDIA_ALLOWED_VIDEO_CODECS" env-default:"h264,hevc,vp8,vp9,av1,mpeg4,mpeg2video,prores"`
+		AllowedAudioCodecs []string      `yaml:"allowed_audio_codecs" env:"MEDIA_ALLOWED_AUDIO_CODECS" env-default:"aac,mp3,opus,vorbis,ac3,eac3,flac,alac,pcm_s16le,pcm_s24le"`
+		MaxDuration        time.Duration `yaml:"max_duration" env:"MEDIA_MAX_DURATION" env-default:"4h"`
+		MaxLongSide        int           `yaml:"max_long_side" env:"MEDIA_MAX_LONG_SIDE" env-default:"4096"`
+		MaxShortSide       int           `yaml:"max_short_side" env:"MEDIA_MAX_SHORT_SIDE" env-default:"2160"`
+	}
+
+	// EncodingProfile describes how a rendition is encoded and packaged.
+	// Exactly one of CRF and Bitrate must be set; MaxRate caps the bitrate of CRF encodes too.
+	EncodingProfile struct {
+		VideoCodec       string `yaml:"video_codec"`
+		Profile          string `yaml:"profile"`
+		Level            string `yaml:"level"`
+		CRF              int    `yaml:"crf"`
+		Bitrate          string `yaml:"bitrate"`
+		MaxRate          string `yaml:"max_rate"`
+		BufSize          string `yaml:"buf_size"`
+		Preset           string `yaml:"preset"`
+		KeyframeInterval int    `yaml:"keyframe_interval"`
+		AudioCodec       string `yaml:"audio_codec"`
+		AudioBitrate     string `yaml:"audio_bitrate"`
+		// SegmentFormat is ts for MPEG-TS segments or fmp4 for CMAF ones, defaulting to ts. DASH forces fmp4.
+		SegmentFormat string `yaml:"segment_format"`
+	}
+
+	// Storage selects where uploads and transcoded media are kept. The local driver keeps them in the storage path,
+	// the s3 driver in a bucket shared by every node, the storage path then only being a working directory.
+	Storage struct {
+		Driver string `yaml:"driver" env:"STORAGE_DRIVER" env-default:"local"`
+		S3     S3     `yaml:"s3"`
+		// PresignTTL redirects segment requests to URLs presigned for this long, zero streams them through the API
+		PresignTTL time.Duration `yaml:"presign_ttl" env:"STORAGE_PRESIGN_TTL" env-default:"0s"`
+	}
+
+	// S3 configures the bucket of an S3-compatible service such as AWS S3 or MinIO
+	S3 struct {
+		Endpoint  string `yaml:"endpoint" env:"S3_ENDPOINT"`
+		Region    string `yaml:"region" env:"S3_REGION" env-default:"us-east-1"`
+		Bucket    string `yaml:"bucket" env:"S3_BUCKET"`
+		AccessKey string `yaml:"access_key" env:"S3_ACCESS_KEY"`
+		SecretKey string `env:"S3_SECRET_KEY"`
+		// PathStyle addresses the bucket in the path rather than in the host name, as MinIO expects
+		PathStyle bool `yaml:"path_style" env:"S3_PATH_STYLE" env-default:"true"`
+	}
+
+	UploadService struct {
+		UploadPath string `yaml:"upload_path" env:"UPLOAD_PATH" env-default:"uploads"`
+		MaxSize    int64  `yaml:"max_size" env:"UPLOAD_MAX_SIZE" env-default:"8589934592"`
+		// FinishTimeout bounds handing a fully received upload over to the video upload flow,
+		// which hashes, copies and probes the whole file in the background once the last PATCH is answered
+		FinishTimeout time.Duration `yaml:"finish_timeout" env:"UPLOAD_FINISH_TIMEOUT" env-default:"30m"`
+		// Node names the instance holding the partial files of the uploads it creates, its hostname when empty.
+		// Partial files live under the storage path, so the load balancer must route every request
+		// of an upload URL to the instance that created it. Instances sharing a storage path may share a node.
+		Node string `yaml:"node" env:"UPLOAD_NODE"`
 	}
 )
 
@@ -104,5 +219,13 @@ func NewConfig() (*Config, error) {
 		return nil, fmt.Errorf("cannot read config: %s", err)
 	}
 
+	if err := cfg.VideoService.validate(); err != nil {
+		return nil, fmt.Errorf("invalid video service config: %w", err)
+	}
+
+	if err := cfg.Storage.validate(); err != nil {
+		return nil, fmt.Errorf("invalid storage config: %w", err)
+	}
+
 	return &cfg, nil
 }
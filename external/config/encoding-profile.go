@@ -0,0 +1,190 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+)
+
+var (
+	videoCodecs  = []string{"libx264", "libx265"}
+	audioCodecs  = []string{"aac", "libfdk_aac"}
+	videoPresets = []string{
+		"ultrafast", "superfast", "veryfast", "faster", "fast",
+		"medium", "slow", "slower", "veryslow", "placebo",
+	}
+	// segmentFormats are the segment containers of a profile, the first one is the default
+	segmentFormats = []string{"ts", "fmp4"}
+
+	rateRe = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKM]?$`)
+)
+
+// defaultEncodingProfile is used when the config does not define any encoding profile
+var defaultEncodingProfile = EncodingProfile{
+	VideoCodec:       "libx264",
+	Profile:          "main",
+	CRF:              23,
+	Preset:           "veryfast",
+	KeyframeInterval: 2,
+	AudioCodec:       "aac",
+	AudioBitrate:     "128k",
+}
+
+// EncodingProfileFor returns the name and the encoding profile of a rendition label
+func (v VideoService) EncodingProfileFor(label string) (string, EncodingProfile) {
+	name, ok := v.RenditionProfiles[label]
+	if !ok {
+		name = v.DefaultEncodingProfile
+	}
+
+	return name, v.EncodingProfiles[name]
+}
+
+// SegmentFormatFor returns the segment format of a rendition label.
+// DASH shares its segments with HLS, so every rendition is packaged as fMP4 when it is enabled.
+func (v VideoService) SegmentFormatFor(label string) string {
+	if v.DASH {
+		return "fmp4"
+	}
+
+	_, profile := v.EncodingProfileFor(label)
+	if profile.SegmentFormat == "" {
+		return segmentFormats[0]
+	}
+
+	return profile.SegmentFormat
+}
+
+// validate applies the default encoding profile and checks that every rendition
+// is mapped to a well-formed profile whose keyframe interval is aligned to HLSTime
+func (v *VideoService) validate() error {
+	if v.HLSTime <= 0 {
+		return fmt.Errorf("hls_time must be positive, got %d", v.HLSTime)
+	}
+
+	if len(v.EncodingProfiles) == 0 {
+		v.EncodingProfiles = map[string]EncodingProfile{
+			v.DefaultEncodingProfile: defaultEncodingProfile,
+		}
+	}
+
+	names := make([]string, 0, len(v.EncodingProfiles))
+	for name := range v.EncodingProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := v.EncodingProfiles[name].validate(v.HLSTime); err != nil {
+			return fmt.Errorf("encoding profile %q: %w", name, err)
+		}
+	}
+
+	labels := make(map[string]bool, len(v.Resolutions))
+	for _, label := range v.Resolutions {
+		labels[label] = true
+
+		name, _ := v.EncodingProfileFor(label)
+		if _, ok := v.EncodingProfiles[name]; !ok {
+			return fmt.Errorf("rendition %q uses unknown encoding profile %q", label, name)
+		}
+	}
+
+	for label := range v.RenditionProfiles {
+		if !labels[label] {
+			return fmt.Errorf("rendition_profiles references unknown rendition %q", label)
+		}
+	}
+
+	if err := v.Images.validate(); err != nil {
+		return fmt.Errorf("images: %w", err)
+	}
+
+	if v.Encryption.KeyRotation < 0 {
+		return fmt.Errorf("encryption key_rotation must not be negative, got %d", v.Encryption.KeyRotation)
+	}
+
+	if v.SignedURLs.Secret != "" && v.SignedURLs.TTL <= 0 {
+		return fmt.Errorf("signed_urls ttl must be positive, got %s", v.SignedURLs.TTL)
+	}
+
+	// DASH players cannot decrypt whole-segment AES-128, which would leave the shared segments unplayable
+	if v.Encryption.Enabled && v.DASH {
+		return fmt.Errorf("encryption cannot be combined with dash")
+	}
+
+	return nil
+}
+
+func (i Images) validate() error {
+	switch {
+	case i.PosterOffset < 0:
+		return fmt.Errorf("poster_offset must not be negative, got %s", i.PosterOffset)
+	case i.ThumbnailInterval < time.Second:
+		return fmt.Errorf("thumbnail_interval must be at least 1s, got %s", i.ThumbnailInterval)
+	case i.StoryboardInterval < time.Second:
+		return fmt.Errorf("storyboard_interval must be at least 1s, got %s", i.StoryboardInterval)
+	case i.PosterMaxSize <= 0:
+		return fmt.Errorf("poster_max_size must be positive, got %d", i.PosterMaxSize)
+	case i.PosterWidth <= 0 || i.ThumbnailWidth <= 0 || i.StoryboardTileWidth <= 0:
+		return fmt.Errorf("poster_width, thumbnail_width and storyboard_tile_width must be positive")
+	case i.StoryboardColumns <= 0 || i.StoryboardRows <= 0:
+		return fmt.Errorf("storyboard_columns and storyboard_rows must be positive")
+	}
+
+	return nil
+}
+
+func (p EncodingProfile) validate(hlsTime int) error {
+	if !slices.Contains(videoCodecs, p.VideoCodec) {
+		return fmt.Errorf("unsupported video_codec %q", p.VideoCodec)
+	}
+
+	if p.Preset != "" && !slices.Contains(videoPresets, p.Preset) {
+		return fmt.Errorf("unsupported preset %q", p.Preset)
+	}
+
+	switch {
+	case p.CRF != 0 && p.Bitrate != "":
+		return fmt.Errorf("crf and bitrate are mutually exclusive")
+	case p.CRF == 0 && p.Bitrate == "":
+		return fmt.Errorf("one of crf or bitrate is required")
+	case p.CRF < 0 || p.CRF > 51:
+		return fmt.Errorf("crf must be between 1 and 51, got %d", p.CRF)
+	}
+
+	for field, rate := range map[string]string{
+		"bitrate":       p.Bitrate,
+		"max_rate":      p.MaxRate,
+		"buf_size":      p.BufSize,
+		"audio_bitrate": p.AudioBitrate,
+	} {
+		if rate != "" && !rateRe.MatchString(rate) {
+			return fmt.Errorf("invalid %s %q", field, rate)
+		}
+	}
+
+	if (p.MaxRate == "") != (p.BufSize == "") {
+		return fmt.Errorf("max_rate and buf_size must be set together")
+	}
+
+	if p.KeyframeInterval <= 0 {
+		return fmt.Errorf("keyframe_interval must be positive, got %d", p.KeyframeInterval)
+	}
+
+	if hlsTime%p.KeyframeInterval != 0 {
+		return fmt.Errorf("keyframe_interval %d does not divide hls_time %d", p.KeyframeInterval, hlsTime)
+	}
+
+	if !slices.Contains(audioCodecs, p.AudioCodec) {
+		return fmt.Errorf("unsupported audio_codec %q", p.AudioCodec)
+	}
+
+	if p.SegmentFormat != "" && !slices.Contains(segmentFormats, p.SegmentFormat) {
+		return fmt.Errorf("unsupported segment_format %q", p.SegmentFormat)
+	}
+
+	return nil
+}
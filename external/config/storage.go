@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	StorageDriverLocal = "local"
+	StorageDriverS3    = "s3"
+)
+
+// s3MaxPresignTTL is the longest a presigned S3 URL may be valid for
+const s3MaxPresignTTL = 7 * 24 * time.Hour
+
+func (s Storage) validate() error {
+	switch s.Driver {
+	case StorageDriverLocal:
+		if s.PresignTTL != 0 {
+			return fmt.Errorf("presign_ttl requires the %s driver", StorageDriverS3)
+		}
+	case StorageDriverS3:
+		if s.S3.Endpoint == "" || s.S3.Bucket == "" {
+			return fmt.Errorf("s3 endpoint and bucket are required")
+		}
+		if s.S3.AccessKey == "" || s.S3.SecretKey == "" {
+			return fmt.Errorf("s3 access_key and S3_SECRET_KEY are required")
+		}
+		if s.PresignTTL < 0 || s.PresignTTL > s3MaxPresignTTL {
+			return fmt.Errorf("presign_ttl must be between 0 and %s, got %s", s3MaxPresignTTL, s.PresignTTL)
+		}
+	default:
+		return fmt.Errorf("driver must be %s or %s, got %q", StorageDriverLocal, StorageDriverS3, s.Driver)
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package response
 
 import (
 	"encoding/json"
+	"go-fitness/external/logger/sl"
 	"log/slog"
 	"net/http"
 )
@@ -26,7 +27,7 @@ func Respond(w http.ResponseWriter, rd Response) {
 
 	responseJson, err := json.Marshal(response)
 	if err != nil {
-		slog.Error("failed to marshal response", err)
+		slog.Error("failed to marshal response", sl.Err(err))
 	}
 
 	_, err = w.Write(responseJson)
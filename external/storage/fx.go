@@ -0,0 +1,12 @@
+package storage
+
+import "go.uber.org/fx"
+
+func NewStorage() fx.Option {
+	return fx.Module(
+		"storage",
+		fx.Provide(
+			New,
+		),
+	)
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local keeps objects as files under a root directory, a key being the slash separated path of its file
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{
+		root: root,
+	}
+}
+
+// path returns the file of a key, refusing keys that would escape the root
+func (l *Local) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned != "/"+strings.TrimSuffix(key, "/") {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+
+	return filepath.Join(l.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (l *Local) Put(_ context.Context, key string, body io.Reader, size int64, _ string) error {
+	dst, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	// The object is swapped in with a rename, so it is never read half written
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, body)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if written != size {
+		tmp.Close()
+		return fmt.Errorf("wrote %d bytes of %d", written, size)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (l *Local) Get(_ context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	src, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return nil, localError(err)
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if length < 0 {
+		return file, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+func (l *Local) Stat(_ context.Context, key string) (ObjectInfo, error) {
+	src, err := l.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	stat, err := os.Stat(src)
+	if err != nil {
+		return ObjectInfo{}, localError(err)
+	}
+
+	if !stat.Mode().IsRegular() {
+		return ObjectInfo{}, ErrNotFound
+	}
+
+	return localObjectInfo(key, stat), nil
+}
+
+func (l *Local) List(_ context.Context, prefix string) ([]ObjectInfo, error) {
+	// Only the directory holding the prefix is walked
+	dir := prefix
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+
+	root, err := l.path(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []ObjectInfo
+
+	err = filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, file)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, localObjectInfo(key, stat))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+func (l *Local) DeletePrefix(ctx context.Context, prefix string) error {
+	// A directory prefix goes along with its folder
+	if strings.HasSuffix(prefix, "/") {
+		dir, err := l.path(prefix)
+		if err != nil {
+			return err
+		}
+
+		return os.RemoveAll(dir)
+	}
+
+	objects, err := l.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		file, err := l.path(object.Key)
+		if err != nil {
+			return err
+		}
+
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *Local) Presign(context.Context, string, time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+// localObjectInfo describes a file, validated by its modification time and size
+func localObjectInfo(key string, stat fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:     key,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+		ETag:    fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()),
+	}
+}
+
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,434 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3PartSize is the part size of multipart uploads, which objects above it are stored with
+	s3PartSize = 64 << 20
+	// s3DeleteBatch is the most keys a DeleteObjects request takes
+	s3DeleteBatch = 1000
+	// s3MaxPresignTTL is the longest a SigV4 presigned URL is valid for
+	s3MaxPresignTTL = 7 * 24 * time.Hour
+)
+
+// S3Options configures an S3-compatible bucket
+type S3Options struct {
+	// Endpoint is the base URL of the service, such as https://s3.eu-west-1.amazonaws.com or http://minio:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket in the path rather than in the host name, as MinIO expects
+	PathStyle bool
+}
+
+// S3 keeps objects in a bucket of an S3-compatible service, talking to its REST API with SigV4 signed requests
+type S3 struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	signer    sigV4Signer
+}
+
+func NewS3(opts S3Options) (*S3, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", opts.Endpoint)
+	}
+
+	return &S3{
+		client:    &http.Client{},
+		endpoint:  endpoint,
+		bucket:    opts.Bucket,
+		pathStyle: opts.PathStyle,
+		signer: sigV4Signer{
+			accessKey: opts.AccessKey,
+			secretKey: opts.SecretKey,
+			region:    opts.Region,
+		},
+	}, nil
+}
+
+// objectURL returns the URL of a key, the bucket itself when key is empty
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+
+	objectPath := "/" + key
+	if s.pathStyle {
+		objectPath = "/" + s.bucket + objectPath
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+
+	u.Path = strings.TrimSuffix(s.endpoint.Path, "/") + objectPath
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	return &u
+}
+
+// do sends a signed request, turning error responses into errors
+func (s *S3) do(
+	ctx context.Context,
+	method string,
+	u *url.URL,
+	header http.Header,
+	body io.Reader,
+	size int64,
+	payloadHash string,
+) (*http.Response, error) {
+	if body != nil && size == 0 {
+		body = http.NoBody
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.signer.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, s3Error(method, u, resp)
+	}
+
+	return resp, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	if size > s3PartSize {
+		return s.putMultipart(ctx, key, body, size, contentType)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(key, nil), header, body, size, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+type s3InitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletePart `xml:"Part"`
+}
+
+type s3CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart stores a large object in parts of s3PartSize, aborting the upload on failure
+func (s *S3) putMultipart(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+
+	resp, err := s.do(ctx, http.MethodPost, s.objectURL(key, url.Values{"uploads": {""}}), header, nil, 0, emptyPayloadHash())
+	if err != nil {
+		return err
+	}
+
+	var initiated s3InitiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode multipart upload: %w", err)
+	}
+
+	if err := s.uploadParts(ctx, key, initiated.UploadID, body, size); err != nil {
+		abortURL := s.objectURL(key, url.Values{"uploadId": {initiated.UploadID}})
+		if resp, abortErr := s.do(context.WithoutCancel(ctx), http.MethodDelete, abortURL, nil, nil, 0, emptyPayloadHash()); abortErr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *S3) uploadParts(ctx context.Context, key string, uploadID string, body io.Reader, size int64) error {
+	var complete s3CompleteMultipartUpload
+
+	for number, offset := 1, int64(0); offset < size; number, offset = number+1, offset+s3PartSize {
+		partSize := min(s3PartSize, size-offset)
+
+		partURL := s.objectURL(key, url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		})
+
+		resp, err := s.do(ctx, http.MethodPut, partURL, nil, io.LimitReader(body, partSize), partSize, unsignedPayload)
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %w", number, err)
+		}
+		resp.Body.Close()
+
+		complete.Parts = append(complete.Parts, s3CompletePart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	}
+
+	encoded, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, s.objectURL(key, url.Values{"uploadId": {uploadID}}), nil,
+		bytes.NewReader(encoded), int64(len(encoded)), payloadHash(encoded))
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// A failure after the upload started is reported in the body of a 200 response
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(content, []byte("<Error>")) {
+		return decodeS3Error(http.MethodPost, key, resp.StatusCode, content)
+	}
+
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+
+	switch {
+	case length == 0:
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	case length > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key, nil), header, nil, 0, emptyPayloadHash())
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(key, nil), nil, nil, 0, emptyPayloadHash())
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return ObjectInfo{
+		Key:     key,
+		Size:    resp.ContentLength,
+		ModTime: modTime,
+		ETag:    resp.Header.Get("ETag"),
+	}, nil
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {prefix},
+	}
+
+	for {
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL("", query), nil, nil, 0, emptyPayloadHash())
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode object list: %w", err)
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:     content.Key,
+				Size:    content.Size,
+				ModTime: content.LastModified,
+				ETag:    content.ETag,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+type s3Delete struct {
+	XMLName xml.Name         `xml:"Delete"`
+	Quiet   bool             `xml:"Quiet"`
+	Objects []s3DeleteObject `xml:"Object"`
+}
+
+type s3DeleteObject struct {
+	Key string `xml:"Key"`
+}
+
+type s3DeleteResult struct {
+	Errors []struct {
+		Key     string `xml:"Key"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+}
+
+func (s *S3) DeletePrefix(ctx context.Context, prefix string) error {
+	objects, err := s.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(objects); start += s3DeleteBatch {
+		batch := s3Delete{Quiet: true}
+		for _, object := range objects[start:min(start+s3DeleteBatch, len(objects))] {
+			batch.Objects = append(batch.Objects, s3DeleteObject{Key: object.Key})
+		}
+
+		if err := s.deleteObjects(ctx, batch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *S3) deleteObjects(ctx context.Context, batch s3Delete) error {
+	encoded, err := xml.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	// DeleteObjects requires the MD5 of its body
+	sum := md5.Sum(encoded)
+	header := http.Header{}
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	header.Set("Content-Type", "application/xml")
+
+	resp, err := s.do(ctx, http.MethodPost, s.objectURL("", url.Values{"delete": {""}}), header,
+		bytes.NewReader(encoded), int64(len(encoded)), payloadHash(encoded))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result s3DeleteResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to decode delete result: %w", err)
+	}
+
+	if len(result.Errors) > 0 {
+		failed := result.Errors[0]
+		return fmt.Errorf("failed to delete %d objects, %s: %s: %s", len(result.Errors), failed.Key, failed.Code, failed.Message)
+	}
+
+	return nil
+}
+
+func (s *S3) Presign(_ context.Context, key string, ttl time.Duration) (string, error) {
+	if ttl <= 0 || ttl > s3MaxPresignTTL {
+		return "", fmt.Errorf("presign ttl must be between 1s and %s, got %s", s3MaxPresignTTL, ttl)
+	}
+
+	u := s.objectURL(key, nil)
+	s.signer.presign(u, ttl, time.Now())
+
+	return u.String(), nil
+}
+
+func payloadHash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func emptyPayloadHash() string {
+	return payloadHash(nil)
+}
+
+type s3ErrorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// s3Error turns an error response into ErrNotFound for missing objects, or an error carrying the S3 error code
+func s3Error(method string, u *url.URL, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	return decodeS3Error(method, u.Path, resp.StatusCode, content)
+}
+
+func decodeS3Error(method string, target string, status int, content []byte) error {
+	var response s3ErrorResponse
+	if err := xml.Unmarshal(content, &response); err != nil || response.Code == "" {
+		return fmt.Errorf("s3 %s %s: status %d", method, target, status)
+	}
+
+	return fmt.Errorf("s3 %s %s: %s: %s", method, target, response.Code, response.Message)
+}
@@ -0,0 +1,484 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testBucket = "media"
+
+// fakeS3 is an in-memory bucket answering the subset of the S3 REST API the driver uses
+type fakeS3 struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	// pageSize is the most keys a list response holds
+	pageSize int
+
+	// ranges records the Range header of every GET of an object
+	ranges []string
+	// listCalls counts the list requests, deleteBatches the keys of every DeleteObjects request
+	listCalls     int
+	deleteBatches []int
+	partSizes     []int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3) {
+	t.Helper()
+
+	fake := &fakeS3{
+		t:        t,
+		objects:  make(map[string][]byte),
+		uploads:  make(map[string]map[int][]byte),
+		pageSize: 1000,
+	}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	s3, err := NewS3(S3Options{
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Bucket:    testBucket,
+		AccessKey: "access",
+		SecretKey: "secret",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fake, s3
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		f.t.Errorf("%s %s is not signed", r.Method, r.URL)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		f.t.Errorf("failed to read body: %v", err)
+		return
+	}
+
+	if hash := r.Header.Get("X-Amz-Content-Sha256"); hash != unsignedPayload && hash != payloadHash(body) {
+		f.t.Errorf("%s %s has a payload hash of another body", r.Method, r.URL)
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+testBucket+"/")
+	if !ok {
+		f.t.Errorf("%s %s is outside of the bucket", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.list(w, query)
+	case key == "" && r.Method == http.MethodPost && query.Has("delete"):
+		f.delete(w, r, body)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadID := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][number] = body
+		f.partSizes = append(f.partSizes, len(body))
+		w.Header().Set("ETag", etagOf(body))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.complete(w, key, query.Get("uploadId"), body)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+		w.Header().Set("ETag", etagOf(body))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		content, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet {
+			f.ranges = append(f.ranges, r.Header.Get("Range"))
+		}
+		w.Header().Set("ETag", etagOf(content))
+		http.ServeContent(w, r, "", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), bytes.NewReader(content))
+	default:
+		f.t.Errorf("unexpected %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	f.listCalls++
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if token := query.Get("continuation-token"); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := min(start+f.pageSize, len(keys))
+
+	var b strings.Builder
+	b.WriteString("<ListBucketResult>")
+	for _, key := range keys[start:end] {
+		fmt.Fprintf(&b, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>2024-01-02T03:04:05.000Z</LastModified>"+
+			"<ETag>%s</ETag></Contents>", key, len(f.objects[key]), etagOf(f.objects[key]))
+	}
+	if end < len(keys) {
+		fmt.Fprintf(&b, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+	} else {
+		b.WriteString("<IsTruncated>false</IsTruncated>")
+	}
+	b.WriteString("</ListBucketResult>")
+
+	_, _ = io.WriteString(w, b.String())
+}
+
+func (f *fakeS3) delete(w http.ResponseWriter, r *http.Request, body []byte) {
+	sum := md5.Sum(body)
+	if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+		f.t.Error("DeleteObjects has a Content-MD5 of another body")
+	}
+
+	var batch s3Delete
+	if err := xml.Unmarshal(body, &batch); err != nil {
+		f.t.Errorf("failed to decode delete: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	f.deleteBatches = append(f.deleteBatches, len(batch.Objects))
+	for _, object := range batch.Objects {
+		delete(f.objects, object.Key)
+	}
+
+	_, _ = io.WriteString(w, "<DeleteResult></DeleteResult>")
+}
+
+func (f *fakeS3) complete(w http.ResponseWriter, key string, uploadID string, body []byte) {
+	var complete s3CompleteMultipartUpload
+	if err := xml.Unmarshal(body, &complete); err != nil {
+		f.t.Errorf("failed to decode complete: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var content []byte
+	for i, part := range complete.Parts {
+		stored := f.uploads[uploadID][part.PartNumber]
+		if part.PartNumber != i+1 || part.ETag != etagOf(stored) {
+			f.t.Errorf("part %d has an unexpected number or etag", i+1)
+		}
+		content = append(content, stored...)
+	}
+
+	f.objects[key] = content
+	delete(f.uploads, uploadID)
+
+	_, _ = io.WriteString(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+}
+
+func etagOf(content []byte) string {
+	sum := md5.Sum(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func TestS3PutGet(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	ctx := context.Background()
+
+	content := []byte("0123456789abcdef")
+	if err := s3.Put(ctx, "videos/hash/720_001.m4s", bytes.NewReader(content), int64(len(content)), "video/iso.segment"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fake.objects["videos/hash/720_001.m4s"]; !bytes.Equal(got, content) {
+		t.Fatalf("stored %q, want %q", got, content)
+	}
+
+	tests := []struct {
+		offset, length int64
+		wantRange      string
+		want           string
+	}{
+		{0, -1, "", "0123456789abcdef"},
+		{4, 3, "bytes=4-6", "456"},
+		{10, -1, "bytes=10-", "abcdef"},
+	}
+
+	for _, tt := range tests {
+		fake.ranges = nil
+
+		body, err := s3.Get(ctx, "videos/hash/720_001.m4s", tt.offset, tt.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(got) != tt.want {
+			t.Errorf("Get(%d, %d) = %q, want %q", tt.offset, tt.length, got, tt.want)
+		}
+		if len(fake.ranges) != 1 || fake.ranges[0] != tt.wantRange {
+			t.Errorf("Get(%d, %d) sent ranges %q, want %q", tt.offset, tt.length, fake.ranges, tt.wantRange)
+		}
+	}
+
+	if _, err := s3.Get(ctx, "videos/hash/missing.m4s", 0, -1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing object = %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3PutMultipart(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+
+	size := int64(s3PartSize + 1<<10)
+	content := bytes.Repeat([]byte("0123456789abcdef"), int(size/16))
+
+	if err := s3.Put(context.Background(), "videos/hash/source.mp4", bytes.NewReader(content), size, "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{s3PartSize, 1 << 10}; fmt.Sprint(fake.partSizes) != fmt.Sprint(want) {
+		t.Errorf("part sizes = %v, want %v", fake.partSizes, want)
+	}
+	if !bytes.Equal(fake.objects["videos/hash/source.mp4"], content) {
+		t.Error("the completed object differs from the uploaded content")
+	}
+	if len(fake.uploads) != 0 {
+		t.Error("the multipart upload was not completed")
+	}
+}
+
+func TestS3Stat(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	fake.objects["videos/hash/master.m3u8"] = []byte("#EXTM3U\n")
+
+	info, err := s3.Stat(context.Background(), "videos/hash/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ObjectInfo{
+		Key:     "videos/hash/master.m3u8",
+		Size:    8,
+		ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		ETag:    etagOf([]byte("#EXTM3U\n")),
+	}
+	if info != want {
+		t.Errorf("Stat = %+v, want %+v", info, want)
+	}
+
+	if _, err := s3.Stat(context.Background(), "videos/hash/missing.m3u8"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat of a missing object = %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3ListPaginates(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	fake.pageSize = 2
+
+	for _, key := range []string{"videos/a/1", "videos/a/2", "videos/a/3", "videos/a/4", "videos/a/5", "videos/b/1"} {
+		fake.objects[key] = []byte(key)
+	}
+
+	objects, err := s3.List(context.Background(), "videos/a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+		if object.Size != int64(len(object.Key)) || object.ETag != etagOf([]byte(object.Key)) {
+			t.Errorf("object %s has an unexpected size or etag", object.Key)
+		}
+	}
+
+	if want := "[videos/a/1 videos/a/2 videos/a/3 videos/a/4 videos/a/5]"; fmt.Sprint(keys) != want {
+		t.Errorf("List = %v, want %s", keys, want)
+	}
+	if fake.listCalls != 3 {
+		t.Errorf("List sent %d requests, want 3", fake.listCalls)
+	}
+}
+
+func TestS3DeletePrefixBatches(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+
+	for i := 0; i < s3DeleteBatch+1; i++ {
+		fake.objects[fmt.Sprintf("videos/a/%04d.m4s", i)] = nil
+	}
+	fake.objects["videos/b/0001.m4s"] = nil
+
+	if err := s3.DeletePrefix(context.Background(), "videos/a/"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{s3DeleteBatch, 1}; fmt.Sprint(fake.deleteBatches) != fmt.Sprint(want) {
+		t.Errorf("delete batches = %v, want %v", fake.deleteBatches, want)
+	}
+	if len(fake.objects) != 1 {
+		t.Errorf("%d objects left, want only the one outside of the prefix", len(fake.objects))
+	}
+}
+
+func TestS3Presign(t *testing.T) {
+	_, s3 := newFakeS3(t)
+
+	location, err := s3.Presign(context.Background(), "videos/hash/720_001.m4s", 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Path != "/"+testBucket+"/videos/hash/720_001.m4s" {
+		t.Errorf("presigned path = %q", u.Path)
+	}
+
+	query := u.Query()
+	for name, want := range map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Expires":       "600",
+		"X-Amz-SignedHeaders": "host",
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if !strings.HasPrefix(query.Get("X-Amz-Credential"), "access/") || query.Get("X-Amz-Signature") == "" {
+		t.Errorf("presigned url %s lacks its credential or signature", location)
+	}
+
+	for _, ttl := range []time.Duration{0, s3MaxPresignTTL + time.Second} {
+		if _, err := s3.Presign(context.Background(), "videos/hash/720_001.m4s", ttl); err == nil {
+			t.Errorf("Presign with a ttl of %s succeeded", ttl)
+		}
+	}
+}
+
+func TestObjectReaderServeContent(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	ctx := context.Background()
+
+	content := []byte("0123456789abcdefghij")
+	fake.objects["videos/hash/720_001.m4s"] = content
+
+	info, err := s3.Stat(ctx, "videos/hash/720_001.m4s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rangeHeader string
+		wantStatus  int
+		wantBody    string
+		wantRanges  []string
+	}{
+		{"", http.StatusOK, string(content), []string{""}},
+		{"bytes=5-9", http.StatusPartialContent, "56789", []string{"bytes=5-"}},
+		{"bytes=-4", http.StatusPartialContent, "ghij", []string{"bytes=16-"}},
+	}
+
+	for _, tt := range tests {
+		fake.ranges = nil
+
+		reader := NewReader(ctx, s3, info)
+
+		req := httptest.NewRequest(http.MethodGet, "/videos/uuid/720_001.m4s", nil)
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		// With a content type set, ServeContent does not read the object to sniff one
+		rec.Header().Set("Content-Type", "video/iso.segment")
+
+		http.ServeContent(rec, req, "720_001.m4s", info.ModTime, reader)
+		reader.Close()
+
+		if rec.Code != tt.wantStatus || rec.Body.String() != tt.wantBody {
+			t.Errorf("Range %q: %d %q, want %d %q", tt.rangeHeader, rec.Code, rec.Body.String(), tt.wantStatus, tt.wantBody)
+		}
+		if fmt.Sprint(fake.ranges) != fmt.Sprint(tt.wantRanges) {
+			t.Errorf("Range %q fetched ranges %q, want %q", tt.rangeHeader, fake.ranges, tt.wantRanges)
+		}
+	}
+}
+
+func TestObjectReaderSeek(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	ctx := context.Background()
+
+	fake.objects["key"] = []byte("0123456789")
+	reader := NewReader(ctx, s3, ObjectInfo{Key: "key", Size: 10})
+	defer reader.Close()
+
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "012" {
+		t.Fatalf("Read = %q, %v", buf, err)
+	}
+
+	// Seeking to the current offset keeps the open body
+	if _, err := reader.Seek(0, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "345" {
+		t.Fatalf("Read = %q, %v", buf, err)
+	}
+
+	if pos, err := reader.Seek(-2, io.SeekEnd); err != nil || pos != 8 {
+		t.Fatalf("Seek = %d, %v", pos, err)
+	}
+	rest, err := io.ReadAll(reader)
+	if err != nil || string(rest) != "89" {
+		t.Fatalf("ReadAll = %q, %v", rest, err)
+	}
+
+	if want := []string{"", "bytes=8-"}; fmt.Sprint(fake.ranges) != fmt.Sprint(want) {
+		t.Errorf("fetched ranges %q, want %q", fake.ranges, want)
+	}
+
+	if _, err := reader.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek to a negative position succeeded")
+	}
+}
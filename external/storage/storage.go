@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-fitness/external/config"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound           = errors.New("object not found")
+	ErrPresignUnsupported = errors.New("presigned urls are not supported")
+)
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	// ETag is a strong validator of the content of the object, quoted
+	ETag string
+}
+
+// Storage keeps objects under slash separated keys, such as videos/<hash>/720.m3u8
+type Storage interface {
+	// Put stores size bytes of body under key, replacing the object atomically
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get reads length bytes of an object from offset, up to its end when length is negative
+	Get(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List returns every object whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// DeletePrefix removes every object whose key starts with prefix
+	DeletePrefix(ctx context.Context, prefix string) error
+	// Presign returns a URL reading an object without credentials until ttl elapses
+	Presign(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// New returns the storage driver selected by the config
+func New(cfg *config.Config) (Storage, error) {
+	switch cfg.Storage.Driver {
+	case config.StorageDriverLocal:
+		return NewLocal(cfg.HTTPServer.StoragePath), nil
+	case config.StorageDriverS3:
+		return NewS3(S3Options{
+			Endpoint:  cfg.Storage.S3.Endpoint,
+			Region:    cfg.Storage.S3.Region,
+			Bucket:    cfg.Storage.S3.Bucket,
+			AccessKey: cfg.Storage.S3.AccessKey,
+			SecretKey: cfg.Storage.S3.SecretKey,
+			PathStyle: cfg.Storage.S3.PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Storage.Driver)
+	}
+}
+
+// objectReader reads an object as an io.ReadSeekCloser, fetching it from the storage from the current offset
+// on the first read after a seek, so http.ServeContent can answer range requests without reading the whole object
+type objectReader struct {
+	ctx     context.Context
+	storage Storage
+	info    ObjectInfo
+
+	offset int64
+	body   io.ReadCloser
+}
+
+// NewReader returns a reader of the object described by info
+func NewReader(ctx context.Context, storage Storage, info ObjectInfo) io.ReadSeekCloser {
+	return &objectReader{
+		ctx:     ctx,
+		storage: storage,
+		info:    info,
+	}
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.info.Size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		body, err := r.storage.Get(r.ctx, r.info.Key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+
+	return n, err
+}
+
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = r.offset + offset
+	case io.SeekEnd:
+		target = r.info.Size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if target < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if target != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = target
+
+	return target, nil
+}
+
+func (r *objectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+
+	return err
+}
@@ -0,0 +1,158 @@
+package urlsign
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testClaims() Claims {
+	return Claims{
+		Video:   "0f8a6f3e-6a4e-4d1c-9a53-1b0e0c6c2f41",
+		User:    "5d2c1c7a-3b9e-4c44-8f0a-2e6b7f9d1a10",
+		Expires: now.Add(time.Hour).Unix(),
+		IP:      "203.0.113.7",
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	signer := New("secret")
+
+	claims, err := signer.Verify(signer.Sign(testClaims()), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims != testClaims() {
+		t.Errorf("Verify = %+v, want %+v", claims, testClaims())
+	}
+}
+
+func TestSignedTokenIsURLSafe(t *testing.T) {
+	token := New("secret").Sign(testClaims())
+
+	if strings.ContainsAny(token, "+/=&?# ") {
+		t.Errorf("token %q needs escaping in a query", token)
+	}
+}
+
+func TestVerifyRejectsTamperedTokens(t *testing.T) {
+	signer := New("secret")
+	token := signer.Sign(testClaims())
+	encoded, signature, _ := strings.Cut(token, ".")
+
+	// reencode returns the token with claims changed by edit under the original signature
+	reencode := func(edit func(*Claims)) string {
+		claims := testClaims()
+		edit(&claims)
+
+		payload, err := json.Marshal(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(payload) + "." + signature
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"another video", reencode(func(claims *Claims) { claims.Video = "8c1e2b9f-2d7a-4f3b-b6c5-9e0d4a7f1c22" })},
+		{"another user", reencode(func(claims *Claims) { claims.User = "a3e7d5b1-9c2f-4e8a-b0d6-7f1c3e5a9b24" })},
+		{"later expiry", reencode(func(claims *Claims) { claims.Expires += 3600 })},
+		{"unbound ip", reencode(func(claims *Claims) { claims.IP = "" })},
+		{"signature of another secret", encoded + "." + strings.SplitN(New("other").Sign(testClaims()), ".", 2)[1]},
+		{"truncated signature", token[:len(token)-2]},
+		{"flipped signature", encoded + "." + strings.ToUpper(signature)},
+	}
+
+	for _, tt := range tests {
+		if _, err := signer.Verify(tt.token, now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: Verify = %v, want ErrInvalidToken", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyRejectsMalformedTokens(t *testing.T) {
+	signer := New("secret")
+
+	// sign returns a correctly signed token of an arbitrary payload
+	sign := func(payload string) string {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+		return encoded + "." + base64.RawURLEncoding.EncodeToString(signer.mac(encoded))
+	}
+
+	tests := []string{
+		"",
+		".",
+		"no-separator",
+		"a.b.c",
+		"!!!.???",
+		sign("not json"),
+		sign(`{"v":1}`),
+	}
+
+	for _, token := range tests {
+		if _, err := signer.Verify(token, now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidToken", token, err)
+		}
+	}
+}
+
+func TestVerifyRejectsExpiredTokens(t *testing.T) {
+	signer := New("secret")
+	token := signer.Sign(testClaims())
+
+	expiresAt := time.Unix(testClaims().Expires, 0)
+
+	if _, err := signer.Verify(token, expiresAt.Add(-time.Second)); err != nil {
+		t.Errorf("Verify a second before expiry: %v", err)
+	}
+	if _, err := signer.Verify(token, expiresAt); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Verify at expiry = %v, want ErrExpiredToken", err)
+	}
+	if _, err := signer.Verify(token, expiresAt.Add(time.Hour)); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Verify after expiry = %v, want ErrExpiredToken", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+
+	if _, ok := FromContext(req.Context()); ok {
+		t.Error("FromContext found claims in a bare context")
+	}
+
+	claims, ok := FromContext(NewContext(req.Context(), testClaims()))
+	if !ok || claims != testClaims() {
+		t.Errorf("FromContext = %+v, %t, want %+v", claims, ok, testClaims())
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{"203.0.113.7:51234", "203.0.113.7"},
+		{"[2001:db8::1]:443", "2001:db8::1"},
+		// The RealIP middleware sets the remote address without a port
+		{"203.0.113.7", "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+
+		if got := ClientIP(req); got != tt.want {
+			t.Errorf("ClientIP(%q) = %q, want %q", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/pusher/pusher-http-go/v5"
 	"go-fitness/external/config"
 	"go-fitness/external/db"
+	"go-fitness/external/storage"
 	"go-fitness/internal/api/event"
 	"go-fitness/internal/api/http/handler"
 	"go-fitness/internal/api/http/middleware"
@@ -22,6 +23,7 @@ func NewApp() *fx.App {
 			handler.NewHandler(),
 			middleware.NewMiddleware(),
 			db.NewDataBase(),
+			storage.NewStorage(),
 		),
 		fx.Provide(
 			config.NewConfig,
@@ -36,7 +38,11 @@ func NewApp() *fx.App {
 			NewServer,
 			NewPusher,
 		),
-		fx.Invoke(RunServer),
+		fx.Invoke(
+			RunServer,
+			RunTranscodeWorkers,
+			RunUploadService,
+		),
 	)
 }
 
@@ -0,0 +1,8 @@
+package data
+
+type UploadCreateData struct {
+	Filename    string
+	Name        string
+	Description string
+	Size        int64
+}
@@ -1,10 +1,46 @@
 package data
 
-import "mime/multipart"
+import "io"
 
 type VideoUploadData struct {
-	File        multipart.File
-	Header      *multipart.FileHeader
+	File        io.Reader
+	Filename    string
 	Name        string
 	Description string
 }
+
+// VideoPosterData selects the custom poster of a video, either from an uploaded Image
+// or from the source frame at Timestamp seconds
+type VideoPosterData struct {
+	UUID      string
+	Image     io.Reader
+	Timestamp *float64
+}
+
+// VideoCaptionData is an SRT or WebVTT caption file uploaded for a video
+type VideoCaptionData struct {
+	UUID     string
+	File     io.Reader
+	Language string
+	Name     string
+	Default  bool
+}
+
+// VideoAudioTrackData is an alternate audio file uploaded for a video, such as a dub or a commentary
+type VideoAudioTrackData struct {
+	UUID     string
+	File     io.Reader
+	Filename string
+	Language string
+	Name     string
+	Kind     string
+	Default  bool
+}
+
+// PlaylistAccess is who a playlist is served to, so the URIs it references can be signed for them
+type PlaylistAccess struct {
+	User string
+	IP   string
+	// Token is the signed URL token the playlist was requested with, passed on to its URIs instead of a new one
+	Token string
+}
@@ -0,0 +1,60 @@
+package enum
+
+type AudioTrackKind int
+
+const (
+	AudioTrackKindUnknown AudioTrackKind = iota
+	AudioTrackKindDub
+	AudioTrackKindCommentary
+	AudioTrackKindMusic
+	// AudioTrackKindMain is the audio of the source, packaged as its own track instead of being muxed into the renditions
+	AudioTrackKindMain
+)
+
+func (k AudioTrackKind) String() string {
+	switch k {
+	case AudioTrackKindDub:
+		return "dub"
+	case AudioTrackKindCommentary:
+		return "commentary"
+	case AudioTrackKindMusic:
+		return "music"
+	case AudioTrackKindMain:
+		return "main"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseAudioTrackKind returns the uploadable kind named by s, or AudioTrackKindUnknown
+func ParseAudioTrackKind(s string) AudioTrackKind {
+	for _, k := range []AudioTrackKind{AudioTrackKindDub, AudioTrackKindCommentary, AudioTrackKindMusic} {
+		if k.String() == s {
+			return k
+		}
+	}
+
+	return AudioTrackKindUnknown
+}
+
+type AudioTrackState int
+
+const (
+	AudioTrackStateUnknown AudioTrackState = iota
+	AudioTrackStatePending
+	AudioTrackStateReady
+	AudioTrackStateFailed
+)
+
+func (s AudioTrackState) String() string {
+	switch s {
+	case AudioTrackStatePending:
+		return "pending"
+	case AudioTrackStateReady:
+		return "ready"
+	case AudioTrackStateFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
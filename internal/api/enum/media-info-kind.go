@@ -0,0 +1,20 @@
+package enum
+
+type MediaInfoKind int
+
+const (
+	MediaInfoKindUnknown MediaInfoKind = iota
+	MediaInfoKindSource
+	MediaInfoKindRendition
+)
+
+func (k MediaInfoKind) String() string {
+	switch k {
+	case MediaInfoKindSource:
+		return "source"
+	case MediaInfoKindRendition:
+		return "rendition"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,20 @@
+package enum
+
+type TranscodeJobKind int
+
+const (
+	TranscodeJobKindUnknown TranscodeJobKind = iota
+	TranscodeJobKindVideo
+	TranscodeJobKindAudio
+)
+
+func (k TranscodeJobKind) String() string {
+	switch k {
+	case TranscodeJobKindVideo:
+		return "video"
+	case TranscodeJobKindAudio:
+		return "audio"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,29 @@
+package enum
+
+type TranscodeJobState int
+
+const (
+	TranscodeJobStateUnknown TranscodeJobState = iota
+	TranscodeJobStatePending
+	TranscodeJobStateRunning
+	TranscodeJobStateCompleted
+	TranscodeJobStateFailed
+	TranscodeJobStateCancelled
+)
+
+func (s TranscodeJobState) String() string {
+	switch s {
+	case TranscodeJobStatePending:
+		return "pending"
+	case TranscodeJobStateRunning:
+		return "running"
+	case TranscodeJobStateCompleted:
+		return "completed"
+	case TranscodeJobStateFailed:
+		return "failed"
+	case TranscodeJobStateCancelled:
+		return "cancelled"
+	default:
+		return "unknown"
+	}
+}
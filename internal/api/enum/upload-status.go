@@ -0,0 +1,27 @@
+package enum
+
+type UploadStatus int
+
+const (
+	UploadStatusUnknown UploadStatus = iota
+	UploadStatusInProgress
+	UploadStatusCompleted
+	UploadStatusFailed
+	// UploadStatusFinishing is a fully received upload being handed over to the video upload flow
+	UploadStatusFinishing
+)
+
+func (s UploadStatus) String() string {
+	switch s {
+	case UploadStatusInProgress:
+		return "in_progress"
+	case UploadStatusCompleted:
+		return "completed"
+	case UploadStatusFailed:
+		return "failed"
+	case UploadStatusFinishing:
+		return "finishing"
+	default:
+		return "unknown"
+	}
+}
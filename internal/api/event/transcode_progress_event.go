@@ -0,0 +1,38 @@
+package event
+
+import "go-fitness/internal/api/types"
+
+type TranscodeProgressEvent struct {
+	videoUUID string
+	jobID     int64
+	progress  types.TranscodeProgress
+}
+
+func NewTranscodeProgressEvent(
+	videoUUID string,
+	jobID int64,
+	progress types.TranscodeProgress,
+) *TranscodeProgressEvent {
+	return &TranscodeProgressEvent{
+		videoUUID: videoUUID,
+		jobID:     jobID,
+		progress:  progress,
+	}
+}
+
+func (e *TranscodeProgressEvent) Channel() string {
+	return "transcode"
+}
+
+func (e *TranscodeProgressEvent) EventType() string {
+	return "transcode-progress"
+}
+
+func (e *TranscodeProgressEvent) Data() map[string]interface{} {
+	return map[string]interface{}{
+		"video_uuid": e.videoUUID,
+		"job_id":     e.jobID,
+		"overall":    e.progress.Overall,
+		"renditions": e.progress.Renditions,
+	}
+}
@@ -5,14 +5,17 @@ import (
 )
 
 type Handlers struct {
-	Video *VideoHandler
+	Video  *VideoHandler
+	Upload *UploadHandler
 }
 
 func NewHandlers(
 	Video *VideoHandler,
+	Upload *UploadHandler,
 ) *Handlers {
 	return &Handlers{
-		Video: Video,
+		Video:  Video,
+		Upload: Upload,
 	}
 }
 
@@ -22,6 +25,7 @@ func NewHandler() fx.Option {
 		fx.Options(),
 		fx.Provide(
 			NewVideoHandler,
+			NewUploadHandler,
 			NewHandlers,
 		),
 	)
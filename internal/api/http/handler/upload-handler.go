@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"go-fitness/external/config"
+	"go-fitness/external/logger/sl"
+	"go-fitness/external/response"
+	"go-fitness/external/validation"
+	"go-fitness/internal/api/data"
+	"go-fitness/internal/api/http/request"
+	"go-fitness/internal/api/service"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,termination"
+)
+
+type UploadHandler struct {
+	log           *slog.Logger
+	cfg           *config.Config
+	uploadService service.UploadServiceInterface
+	validation    *validator.Validate
+}
+
+func NewUploadHandler(
+	log *slog.Logger,
+	cfg *config.Config,
+	uploadService service.UploadServiceInterface,
+) *UploadHandler {
+	return &UploadHandler{
+		log:           log,
+		cfg:           cfg,
+		uploadService: uploadService,
+		validation:    validator.New(),
+	}
+}
+
+// Options describes the tus protocol capabilities of the server
+func (h *UploadHandler) Options() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", TusVersion)
+		w.Header().Set("Tus-Version", TusVersion)
+		w.Header().Set("Tus-Extension", TusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.cfg.UploadService.MaxSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CreateUpload creates a new resumable upload (tus creation extension)
+func (h *UploadHandler) CreateUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "UploadHandler.CreateUpload"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		if !h.checkTusResumable(w, r) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil {
+			log.Error("invalid upload length", sl.Err(err))
+			response.Respond(w, response.Response{
+				Status:  http.StatusBadRequest,
+				Message: "bad request",
+				Data:    "Upload-Length header is required",
+			})
+			return
+		}
+
+		metadata := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+
+		name := metadata["name"]
+		if name == "" {
+			name = metadata["filename"]
+		}
+
+		createRequest := request.UploadCreateRequest{
+			Filename:    metadata["filename"],
+			Name:        name,
+			Description: metadata["description"],
+			Size:        size,
+		}
+
+		var validateErr validator.ValidationErrors
+		if err := h.validation.Struct(createRequest); err != nil {
+			errors.As(err, &validateErr)
+			log.Error("invalid request", sl.Err(validateErr))
+			response.Respond(w, response.Response{
+				Status:  http.StatusBadRequest,
+				Message: "bad request",
+				Data:    validation.ValidationError(validateErr).Error(),
+			})
+			return
+		}
+
+		upload, err := h.uploadService.ProcessCreateUpload(ctx, data.UploadCreateData{
+			Filename:    createRequest.Filename,
+			Name:        createRequest.Name,
+			Description: createRequest.Description,
+			Size:        createRequest.Size,
+		})
+		if err != nil {
+			log.Error("failed to create upload", sl.Err(err))
+			h.respondError(w, err)
+			return
+		}
+
+		w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+upload.UUID)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+}
+
+// HeadUpload returns the current offset of a resumable upload, and its status,
+// which tells whether a fully received upload was handed over to the video upload flow
+func (h *UploadHandler) HeadUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "UploadHandler.HeadUpload"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		if !h.checkTusResumable(w, r) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		upload, err := h.uploadService.ProcessGetUpload(ctx, chi.URLParam(r, "uuid"))
+		if err != nil {
+			log.Error("failed to get upload", sl.Err(err))
+			h.respondError(w, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+		w.Header().Set("Upload-Status", upload.Status.String())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+}
+
+// PatchUpload appends a chunk to a resumable upload
+func (h *UploadHandler) PatchUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "UploadHandler.PatchUpload"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		if !h.checkTusResumable(w, r) {
+			return
+		}
+
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			response.Respond(w, response.Response{
+				Status:  http.StatusUnsupportedMediaType,
+				Message: "unsupported media type",
+				Data:    "Content-Type must be application/offset+octet-stream",
+			})
+			return
+		}
+
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			response.Respond(w, response.Response{
+				Status:  http.StatusBadRequest,
+				Message: "bad request",
+				Data:    "Upload-Offset header is required",
+			})
+			return
+		}
+
+		upload, err := h.uploadService.ProcessAppendUpload(r.Context(), chi.URLParam(r, "uuid"), offset, r.Body)
+		if err != nil {
+			log.Error("failed to append upload", sl.Err(err))
+			if upload.UUID != "" {
+				w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			}
+			h.respondError(w, err)
+			return
+		}
+
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+}
+
+// DeleteUpload terminates a resumable upload (tus termination extension)
+func (h *UploadHandler) DeleteUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "UploadHandler.DeleteUpload"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		if !h.checkTusResumable(w, r) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		if err := h.uploadService.ProcessTerminateUpload(ctx, chi.URLParam(r, "uuid")); err != nil {
+			log.Error("failed to terminate upload", sl.Err(err))
+			h.respondError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+}
+
+// checkTusResumable sets the Tus-Resumable header and rejects unsupported protocol versions
+func (h *UploadHandler) checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", TusVersion)
+
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		w.Header().Set("Tus-Version", TusVersion)
+		response.Respond(w, response.Response{
+			Status:  http.StatusPreconditionFailed,
+			Message: "precondition failed",
+			Data:    "unsupported tus version",
+		})
+		return false
+	}
+
+	return true
+}
+
+func (h *UploadHandler) respondError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	message := "internal server error"
+
+	var mediaErr *service.MediaValidationError
+	if errors.As(err, &mediaErr) {
+		response.Respond(w, response.Response{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "unprocessable entity",
+			Data:    mediaErr,
+		})
+		return
+	}
+
+	switch {
+	case errors.Is(err, service.ErrUploadNotFound):
+		status, message = http.StatusNotFound, "not found"
+	case errors.Is(err, service.ErrUploadOffsetMismatch), errors.Is(err, service.ErrUploadClosed):
+		status, message = http.StatusConflict, "conflict"
+	case errors.Is(err, service.ErrUploadLocked):
+		status, message = http.StatusLocked, "locked"
+	case errors.Is(err, service.ErrUploadMisdirected):
+		status, message = http.StatusMisdirectedRequest, "misdirected request"
+	case errors.Is(err, service.ErrUploadTooLarge):
+		status, message = http.StatusRequestEntityTooLarge, "request entity too large"
+	case errors.Is(err, service.ErrUnsupportedMediaType):
+		status, message = http.StatusUnsupportedMediaType, "unsupported media type"
+	}
+
+	response.Respond(w, response.Response{
+		Status:  status,
+		Message: message,
+		Data:    err.Error(),
+	})
+}
+
+// parseUploadMetadata decodes the tus Upload-Metadata header ("key base64value,key2 base64value")
+func parseUploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if parts[0] == "" {
+			continue
+		}
+
+		if len(parts) == 1 {
+			metadata[parts[0]] = ""
+			continue
+		}
+
+		value, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			continue
+		}
+
+		metadata[parts[0]] = string(value)
+	}
+
+	return metadata
+}
@@ -0,0 +1,418 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"go-fitness/external/config"
+	"go-fitness/internal/api/data"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/repository"
+	"go-fitness/internal/api/service"
+	"go-fitness/internal/api/types"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMaxSize = 64
+
+type fakeUploadRepository struct {
+	repository.UploadRepositoryInterface
+
+	mu      sync.Mutex
+	uploads map[string]types.Upload
+}
+
+func (r *fakeUploadRepository) Create(_ context.Context, upload types.Upload) (types.Upload, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	upload.ID = int64(len(r.uploads) + 1)
+	upload.UUID = fmt.Sprintf("00000000-0000-0000-0000-%012d", upload.ID)
+	r.uploads[upload.UUID] = upload
+
+	return upload, nil
+}
+
+func (r *fakeUploadRepository) GetByUUID(_ context.Context, uuid string) (types.Upload, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	upload, ok := r.uploads[uuid]
+	if !ok {
+		return types.Upload{}, sql.ErrNoRows
+	}
+
+	return upload, nil
+}
+
+func (r *fakeUploadRepository) UpdateOffset(_ context.Context, id int64, offset int64) error {
+	return r.update(id, func(upload *types.Upload) { upload.Offset = offset })
+}
+
+func (r *fakeUploadRepository) UpdateStatus(_ context.Context, id int64, status enum.UploadStatus) error {
+	return r.update(id, func(upload *types.Upload) { upload.Status = status })
+}
+
+func (r *fakeUploadRepository) Delete(_ context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for uuid, upload := range r.uploads {
+		if upload.ID == id {
+			delete(r.uploads, uuid)
+		}
+	}
+
+	return nil
+}
+
+func (r *fakeUploadRepository) update(id int64, apply func(*types.Upload)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for uuid, upload := range r.uploads {
+		if upload.ID == id {
+			apply(&upload)
+			r.uploads[uuid] = upload
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// fakeVideoService records the files handed over to it. ProcessUpload waits for release when it is set
+// and fails with err when it is set.
+type fakeVideoService struct {
+	service.VideoServiceInterface
+
+	release chan struct{}
+
+	mu       sync.Mutex
+	err      error
+	received [][]byte
+}
+
+func (s *fakeVideoService) ProcessUpload(_ context.Context, upload data.VideoUploadData) error {
+	if s.release != nil {
+		<-s.release
+	}
+
+	content, err := io.ReadAll(upload.File)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	s.received = append(s.received, content)
+
+	return nil
+}
+
+type tusTest struct {
+	t       *testing.T
+	router  http.Handler
+	uploads *service.UploadService
+	videos  *fakeVideoService
+}
+
+func newTusTest(t *testing.T) *tusTest {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.HTTPServer.StoragePath = t.TempDir()
+	cfg.UploadService.UploadPath = "uploads"
+	cfg.UploadService.MaxSize = testMaxSize
+	cfg.UploadService.FinishTimeout = time.Minute
+	cfg.UploadService.Node = "test"
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	videos := &fakeVideoService{}
+	uploads := service.NewUploadService(log, cfg, &fakeUploadRepository{uploads: make(map[string]types.Upload)}, videos)
+	h := NewUploadHandler(log, cfg, uploads)
+
+	router := chi.NewRouter()
+	router.Post("/uploads", h.CreateUpload())
+	router.Head("/uploads/{uuid}", h.HeadUpload())
+	router.Patch("/uploads/{uuid}", h.PatchUpload())
+	router.Delete("/uploads/{uuid}", h.DeleteUpload())
+
+	test := &tusTest{t: t, router: router, uploads: uploads, videos: videos}
+	t.Cleanup(test.waitForFinish)
+
+	return test
+}
+
+func (tt *tusTest) do(method string, target string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	tt.t.Helper()
+
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Tus-Resumable", TusVersion)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	rec := httptest.NewRecorder()
+	tt.router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// create creates an upload of size bytes and returns its URL
+func (tt *tusTest) create(size int) string {
+	tt.t.Helper()
+
+	rec := tt.do(http.MethodPost, "/uploads", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(size),
+		"Upload-Metadata": "filename Y2xpcC5tcDQ=",
+	})
+	if rec.Code != http.StatusCreated {
+		tt.t.Fatalf("POST = %d, want 201: %s", rec.Code, rec.Body)
+	}
+
+	return rec.Header().Get("Location")
+}
+
+func (tt *tusTest) patch(url string, offset int, body io.Reader) *httptest.ResponseRecorder {
+	tt.t.Helper()
+
+	return tt.do(http.MethodPatch, url, body, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	})
+}
+
+func (tt *tusTest) head(url string) *httptest.ResponseRecorder {
+	tt.t.Helper()
+
+	return tt.do(http.MethodHead, url, nil, nil)
+}
+
+func (tt *tusTest) waitForFinish() {
+	tt.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := tt.uploads.WaitForFinishingUploads(ctx); err != nil {
+		tt.t.Fatal(err)
+	}
+}
+
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, method string, want int) {
+	t.Helper()
+
+	if rec.Code != want {
+		t.Fatalf("%s = %d, want %d: %s", method, rec.Code, want, rec.Body)
+	}
+}
+
+func expectHeader(t *testing.T, rec *httptest.ResponseRecorder, name string, want string) {
+	t.Helper()
+
+	if got := rec.Header().Get(name); got != want {
+		t.Errorf("%s = %q, want %q", name, got, want)
+	}
+}
+
+// failingReader returns its content and then fails, like a connection dropped in the middle of a PATCH
+type failingReader struct {
+	content io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.content.Read(p)
+	if errors.Is(err, io.EOF) {
+		return n, errors.New("connection reset by peer")
+	}
+
+	return n, err
+}
+
+func TestTusUploadResumesAfterAPartialPatch(t *testing.T) {
+	tt := newTusTest(t)
+
+	content := []byte("0123456789abcdefghij")
+	url := tt.create(len(content))
+
+	rec := tt.patch(url, 0, bytes.NewReader(content[:5]))
+	expectStatus(t, rec, "PATCH", http.StatusNoContent)
+	expectHeader(t, rec, "Upload-Offset", "5")
+
+	// The connection drops after 7 more bytes, which are kept
+	rec = tt.patch(url, 5, &failingReader{content: bytes.NewReader(content[5:12])})
+	expectStatus(t, rec, "PATCH", http.StatusInternalServerError)
+	expectHeader(t, rec, "Upload-Offset", "12")
+
+	rec = tt.head(url)
+	expectStatus(t, rec, "HEAD", http.StatusOK)
+	expectHeader(t, rec, "Upload-Offset", "12")
+	expectHeader(t, rec, "Upload-Length", strconv.Itoa(len(content)))
+	expectHeader(t, rec, "Upload-Status", "in_progress")
+
+	rec = tt.patch(url, 12, bytes.NewReader(content[12:]))
+	expectStatus(t, rec, "PATCH", http.StatusNoContent)
+	expectHeader(t, rec, "Upload-Offset", strconv.Itoa(len(content)))
+
+	tt.waitForFinish()
+
+	if len(tt.videos.received) != 1 || !bytes.Equal(tt.videos.received[0], content) {
+		t.Fatalf("handed over %q, want %q", tt.videos.received, content)
+	}
+
+	rec = tt.head(url)
+	expectStatus(t, rec, "HEAD", http.StatusOK)
+	expectHeader(t, rec, "Upload-Status", "completed")
+
+	rec = tt.patch(url, len(content), bytes.NewReader(nil))
+	expectStatus(t, rec, "PATCH after completion", http.StatusConflict)
+}
+
+func TestTusPatchOffsetMismatch(t *testing.T) {
+	tt := newTusTest(t)
+
+	url := tt.create(10)
+	expectStatus(t, tt.patch(url, 0, bytes.NewReader([]byte("0123"))), "PATCH", http.StatusNoContent)
+
+	for _, offset := range []int{0, 2, 6} {
+		rec := tt.patch(url, offset, bytes.NewReader([]byte("45")))
+		expectStatus(t, rec, fmt.Sprintf("PATCH at %d", offset), http.StatusConflict)
+		expectHeader(t, rec, "Upload-Offset", "4")
+	}
+
+	expectHeader(t, tt.head(url), "Upload-Offset", "4")
+}
+
+func TestTusPatchLocked(t *testing.T) {
+	t.Run("by a running PATCH", func(t *testing.T) {
+		tt := newTusTest(t)
+
+		url := tt.create(10)
+
+		body, writer := io.Pipe()
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			done <- tt.patch(url, 0, body)
+		}()
+
+		// The first PATCH holds the lock once its first bytes are being written
+		if _, err := writer.Write([]byte("01")); err != nil {
+			t.Fatal(err)
+		}
+
+		expectStatus(t, tt.patch(url, 0, bytes.NewReader([]byte("01"))), "concurrent PATCH", http.StatusLocked)
+		expectStatus(t, tt.do(http.MethodDelete, url, nil, nil), "concurrent DELETE", http.StatusLocked)
+
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		rec := <-done
+		expectStatus(t, rec, "PATCH", http.StatusNoContent)
+		expectHeader(t, rec, "Upload-Offset", "2")
+	})
+
+	t.Run("by the finish", func(t *testing.T) {
+		tt := newTusTest(t)
+		tt.videos.release = make(chan struct{})
+
+		url := tt.create(4)
+
+		rec := tt.patch(url, 0, bytes.NewReader([]byte("0123")))
+		expectStatus(t, rec, "last PATCH", http.StatusNoContent)
+		expectHeader(t, rec, "Upload-Offset", "4")
+
+		expectHeader(t, tt.head(url), "Upload-Status", "finishing")
+		expectStatus(t, tt.patch(url, 4, bytes.NewReader(nil)), "PATCH while finishing", http.StatusLocked)
+		expectStatus(t, tt.do(http.MethodDelete, url, nil, nil), "DELETE while finishing", http.StatusLocked)
+
+		close(tt.videos.release)
+		tt.waitForFinish()
+
+		expectHeader(t, tt.head(url), "Upload-Status", "completed")
+	})
+}
+
+func TestTusFinishRetry(t *testing.T) {
+	tt := newTusTest(t)
+	tt.videos.err = errors.New("storage unavailable")
+
+	url := tt.create(4)
+
+	expectStatus(t, tt.patch(url, 0, bytes.NewReader([]byte("0123"))), "last PATCH", http.StatusNoContent)
+	tt.waitForFinish()
+
+	rec := tt.head(url)
+	expectHeader(t, rec, "Upload-Status", "in_progress")
+	expectHeader(t, rec, "Upload-Offset", "4")
+
+	tt.videos.mu.Lock()
+	tt.videos.err = nil
+	tt.videos.mu.Unlock()
+
+	expectStatus(t, tt.patch(url, 4, bytes.NewReader(nil)), "empty PATCH", http.StatusNoContent)
+	tt.waitForFinish()
+
+	expectHeader(t, tt.head(url), "Upload-Status", "completed")
+	if len(tt.videos.received) != 1 || string(tt.videos.received[0]) != "0123" {
+		t.Errorf("handed over %q, want the upload once", tt.videos.received)
+	}
+}
+
+func TestTusFinishRejected(t *testing.T) {
+	tt := newTusTest(t)
+	tt.videos.err = &service.MediaValidationError{Violations: []service.MediaViolation{{Field: "duration", Message: "too long"}}}
+
+	url := tt.create(4)
+
+	expectStatus(t, tt.patch(url, 0, bytes.NewReader([]byte("0123"))), "last PATCH", http.StatusNoContent)
+	tt.waitForFinish()
+
+	expectHeader(t, tt.head(url), "Upload-Status", "failed")
+	expectStatus(t, tt.patch(url, 4, bytes.NewReader(nil)), "PATCH after rejection", http.StatusConflict)
+}
+
+func TestTusUploadTooLarge(t *testing.T) {
+	tt := newTusTest(t)
+
+	rec := tt.do(http.MethodPost, "/uploads", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(testMaxSize + 1),
+		"Upload-Metadata": "filename Y2xpcC5tcDQ=",
+	})
+	expectStatus(t, rec, "POST", http.StatusRequestEntityTooLarge)
+
+	url := tt.create(4)
+
+	rec = tt.patch(url, 0, bytes.NewReader([]byte("012345")))
+	expectStatus(t, rec, "PATCH past Upload-Length", http.StatusRequestEntityTooLarge)
+	expectHeader(t, rec, "Upload-Offset", "4")
+}
+
+func TestTusHeadNotFound(t *testing.T) {
+	tt := newTusTest(t)
+
+	expectStatus(t, tt.head("/uploads/00000000-0000-0000-0000-000000000099"), "HEAD of an unknown upload", http.StatusNotFound)
+
+	url := tt.create(10)
+	expectStatus(t, tt.head(url), "HEAD", http.StatusOK)
+
+	expectStatus(t, tt.do(http.MethodDelete, url, nil, nil), "DELETE", http.StatusNoContent)
+	expectStatus(t, tt.head(url), "HEAD of a terminated upload", http.StatusNotFound)
+	expectStatus(t, tt.patch(url, 0, bytes.NewReader([]byte("01"))), "PATCH of a terminated upload", http.StatusNotFound)
+}
@@ -3,37 +3,55 @@ package handler
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
+	"go-fitness/external/config"
 	"go-fitness/external/logger/sl"
 	"go-fitness/external/response"
+	"go-fitness/external/urlsign"
 	"go-fitness/external/validation"
 	"go-fitness/internal/api/data"
 	"go-fitness/internal/api/http/request"
 	"go-fitness/internal/api/service"
 	"go-fitness/internal/api/types"
 	"go.mongodb.org/mongo-driver/bson"
+	"io"
 	"log/slog"
 	"mime/multipart"
 	"net/http"
+	"path"
 	"strconv"
-	"strings"
 	"time"
 )
 
+const (
+	// maxUploadFieldsSize bounds every non-file form field and the multipart overhead of an upload
+	maxUploadFieldsSize = 1 << 20
+	// segmentCacheControl lets audio and video segments be cached for good, they are written once per hash
+	// before the video becomes playable
+	segmentCacheControl = "max-age=31536000, immutable"
+	// playlistCacheControl keeps playlists short-lived, they are signed per user and change as tracks are added.
+	// Caption tracks share it, a caption replaced in a language is rewritten under the same names.
+	playlistCacheControl = "private, max-age=10"
+)
+
 type VideoHandler struct {
 	log          *slog.Logger
+	cfg          *config.Config
 	videoService service.VideoServiceInterface
 	validation   *validator.Validate
 }
 
 func NewVideoHandler(
 	log *slog.Logger,
+	cfg *config.Config,
 	videoService service.VideoServiceInterface,
 ) *VideoHandler {
 	return &VideoHandler{
 		log:          log,
+		cfg:          cfg,
 		videoService: videoService,
 		validation:   validator.New(),
 	}
@@ -117,58 +135,70 @@ func (h *VideoHandler) GetVideo() http.HandlerFunc {
 		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
 		defer cancel()
 
-		if strings.Contains(r.URL.Path, ".ts") {
-			video, err := h.videoService.ProcessGetVideoTS(ctx, r.URL.Path)
+		// Media playlists, the DASH manifest and the master are told apart from the files served next to them,
+		// whose extension picks their content type
+		if ext := path.Ext(r.URL.Path); ext != "" && ext != ".m3u8" && ext != ".mpd" {
+			segment, err := h.videoService.ProcessGetVideoSegment(ctx, r.URL.Path)
 			if err != nil {
-				log.Error("failed to get videos", sl.Err(err))
+				log.Error("failed to get segment", sl.Err(err))
+
+				status, message := http.StatusInternalServerError, "internal server error"
+				if errors.Is(err, service.ErrVideoMediaNotFound) {
+					status, message = http.StatusNotFound, "not found"
+				}
+
 				response.Respond(w, response.Response{
-					Status:  http.StatusInternalServerError,
-					Message: "internal server error",
+					Status:  status,
+					Message: message,
 					Data:    err.Error(),
 				})
 				return
 			}
 
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Content-Type", "video/mp2ts")
-			w.Header().Set("Content-Length", strconv.Itoa(len(video)))
+			cacheControl := segmentCacheControl
+			if ext == ".vtt" {
+				cacheControl = playlistCacheControl
+			}
 
-			_, err = w.Write(video)
+			serveMediaFile(w, r, segment, cacheControl)
+		} else if ext == ".mpd" {
+			manifest, err := h.videoService.ProcessGetVideoManifestByUUID(ctx, chi.URLParam(r, "uuid"), playlistAccess(r))
 			if err != nil {
-				log.Error("failed to write video", sl.Err(err))
+				log.Error("failed to get manifest", sl.Err(err))
+
+				status, message := http.StatusInternalServerError, "internal server error"
+				if errors.Is(err, service.ErrVideoMediaNotFound) {
+					status, message = http.StatusNotFound, "not found"
+				}
+
 				response.Respond(w, response.Response{
-					Status:  http.StatusInternalServerError,
-					Message: "internal server error",
+					Status:  status,
+					Message: message,
 					Data:    err.Error(),
 				})
 				return
 			}
-		} else if strings.Contains(r.URL.Path, ".m3u8") {
-			video, err := h.videoService.ProcessGetVideoM3U8(r.URL.Path)
+
+			serveMediaFile(w, r, manifest, playlistCacheControl)
+		} else if ext == ".m3u8" {
+			video, err := h.videoService.ProcessGetVideoM3U8(ctx, r.URL.Path, playlistAccess(r))
 			if err != nil {
 				log.Error("failed to get videos", sl.Err(err))
-				response.Respond(w, response.Response{
-					Status:  http.StatusInternalServerError,
-					Message: "internal server error",
-					Data:    err.Error(),
-				})
-				return
-			}
 
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Cache-Control", "no-cache")
-			w.Header().Set("Content-Type", "application/x-mpegURL")
+				status, message := http.StatusInternalServerError, "internal server error"
+				if errors.Is(err, service.ErrVideoMediaNotFound) {
+					status, message = http.StatusNotFound, "not found"
+				}
 
-			_, err = w.Write(video)
-			if err != nil {
-				log.Error("failed to write video", sl.Err(err))
 				response.Respond(w, response.Response{
-					Status:  http.StatusInternalServerError,
-					Message: "internal server error",
+					Status:  status,
+					Message: message,
 					Data:    err.Error(),
 				})
 				return
 			}
+
+			serveMediaFile(w, r, video, playlistCacheControl)
 		} else {
 			videoUUID := chi.URLParam(r, "uuid")
 
@@ -182,39 +212,123 @@ func (h *VideoHandler) GetVideo() http.HandlerFunc {
 				return
 			}
 
-			video, err := h.videoService.ProcessGetVideoPlayListByUUID(ctx, videoUUID)
+			video, err := h.videoService.ProcessGetVideoPlayListByUUID(ctx, videoUUID, playlistAccess(r))
 			if err != nil {
 				log.Error("failed to get videos", sl.Err(err))
+
+				status, message := http.StatusInternalServerError, "internal server error"
+				if errors.Is(err, service.ErrVideoMediaNotFound) {
+					status, message = http.StatusNotFound, "not found"
+				}
+
 				response.Respond(w, response.Response{
-					Status:  http.StatusInternalServerError,
-					Message: "internal server error",
+					Status:  status,
+					Message: message,
 					Data:    err.Error(),
 				})
 				return
 			}
 
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Cache-Control", "no-cache")
-			w.Header().Set("Content-Type", "application/x-mpegURL")
+			serveMediaFile(w, r, video, playlistCacheControl)
+		}
+	}
+}
 
-			_, writeErr := w.Write(video)
-			if writeErr != nil {
-				log.Error("failed to write video", sl.Err(writeErr))
-				response.Respond(w, response.Response{
-					Status:  http.StatusInternalServerError,
-					Message: "internal server error",
-					Data:    err.Error(),
-				})
-				return
+// serveMediaFile streams a media file with http.ServeContent, which answers Range requests
+// and conditional requests against its ETag and modification time, or redirects to its presigned URL
+func serveMediaFile(w http.ResponseWriter, r *http.Request, file service.MediaFile, cacheControl string) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// A presigned URL expires, so the redirect itself must not be cached
+	if file.Location != "" {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, file.Location, http.StatusFound)
+		return
+	}
+
+	defer file.Content.Close()
+
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("Content-Type", file.ContentType)
+	if file.ETag != "" {
+		w.Header().Set("ETag", file.ETag)
+	}
+
+	http.ServeContent(w, r, "", file.ModTime, file.Content)
+}
+
+// playlistAccess returns who a playlist is served to: the holder of the signed URL it was requested with,
+// or the authenticated user
+func playlistAccess(r *http.Request) data.PlaylistAccess {
+	access := data.PlaylistAccess{
+		IP: urlsign.ClientIP(r),
+	}
+
+	if claims, ok := urlsign.FromContext(r.Context()); ok {
+		access.User = claims.User
+		access.Token = r.URL.Query().Get(urlsign.QueryParam)
+	} else if user, ok := r.Context().Value("user").(types.User); ok {
+		access.User = user.UUID
+	}
+
+	return access
+}
+
+// GetVideoKey serves the AES-128 key an encrypted media playlist references
+func (h *VideoHandler) GetVideoKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.GetVideoKey"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		index, err := strconv.Atoi(chi.URLParam(r, "index"))
+		if err != nil || index < 0 {
+			log.Error("invalid key index", sl.String("index", chi.URLParam(r, "index")))
+			response.Respond(w, response.Response{
+				Status:  http.StatusBadRequest,
+				Message: "bad request",
+				Data:    "invalid key index",
+			})
+			return
+		}
+
+		key, err := h.videoService.ProcessGetVideoKey(ctx, chi.URLParam(r, "uuid"), index)
+		if err != nil {
+			log.Error("failed to get video key", sl.Err(err))
+
+			status, message := http.StatusInternalServerError, "internal server error"
+			if errors.Is(err, service.ErrVideoKeyNotFound) {
+				status, message = http.StatusNotFound, "not found"
 			}
+
+			response.Respond(w, response.Response{
+				Status:  status,
+				Message: message,
+				Data:    err.Error(),
+			})
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(key)))
+
+		if _, err := w.Write(key); err != nil {
+			log.Error("failed to write video key", sl.Err(err))
 		}
 	}
 }
 
-// GetVideoPosition gets the video position by uuid and user uuid
-func (h *VideoHandler) GetVideoPosition() http.HandlerFunc {
+// GetVideoImage serves the poster, thumbnails and storyboard of a video
+func (h *VideoHandler) GetVideoImage() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		const op string = "VideoHandler.GetVideoPosition"
+		const op string = "VideoHandler.GetVideoImage"
 
 		log := h.log.With(
 			sl.String("op", op),
@@ -234,178 +348,915 @@ func (h *VideoHandler) GetVideoPosition() http.HandlerFunc {
 			return
 		}
 
-		userID := ctx.Value("user").(types.User).ID
-
-		position, err := h.videoService.ProcessGetVideoPosition(ctx, userID, videoUUID)
+		image, contentType, err := h.videoService.ProcessGetVideoImage(ctx, videoUUID, chi.URLParam(r, "file"))
 		if err != nil {
-			log.Error("failed to get video position", sl.Err(err))
+			log.Error("failed to get video image", sl.Err(err))
+
+			status, message := http.StatusInternalServerError, "internal server error"
+			if errors.Is(err, service.ErrVideoImageNotFound) {
+				status, message = http.StatusNotFound, "not found"
+			}
+
+			response.Respond(w, response.Response{
+				Status:  status,
+				Message: message,
+				Data:    err.Error(),
+			})
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+
+		if _, err := w.Write(image); err != nil {
+			log.Error("failed to write video image", sl.Err(err))
+		}
+	}
+}
+
+// SetVideoPoster replaces the poster of a video with the uploaded "image" file
+// or with the source frame at the "timestamp" form field, in seconds
+func (h *VideoHandler) SetVideoPoster() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.SetVideoPoster"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+		defer cancel()
+
+		videoUUID := chi.URLParam(r, "uuid")
+		if videoUUID == "" {
+			log.Error("uuid is required")
 			response.Respond(w, response.Response{
 				Status:  http.StatusInternalServerError,
 				Message: "internal server error",
-				Data:    err,
+				Data:    "uuid is required",
+			})
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, h.cfg.VideoService.Images.PosterMaxSize+maxUploadFieldsSize)
+
+		if err := r.ParseMultipartForm(maxUploadFieldsSize); err != nil {
+			log.Error("failed to parse multipart form", sl.Err(err))
+			h.respondPosterError(w, err)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		posterData := data.VideoPosterData{
+			UUID: videoUUID,
+		}
+
+		file, _, fileErr := r.FormFile("image")
+		timestamp := r.FormValue("timestamp")
+
+		switch {
+		case fileErr == nil && timestamp == "":
+			defer file.Close()
+			posterData.Image = file
+		case errors.Is(fileErr, http.ErrMissingFile) && timestamp != "":
+			seconds, err := strconv.ParseFloat(timestamp, 64)
+			if err != nil {
+				log.Error("invalid timestamp", sl.Err(err))
+				response.Respond(w, response.Response{
+					Status:  http.StatusBadRequest,
+					Message: "bad request",
+					Data:    "timestamp must be a number of seconds",
+				})
+				return
+			}
+			posterData.Timestamp = &seconds
+		default:
+			if file != nil {
+				file.Close()
+			}
+			log.Error("invalid poster request", sl.Err(fileErr))
+			response.Respond(w, response.Response{
+				Status:  http.StatusBadRequest,
+				Message: "bad request",
+				Data:    "exactly one of image or timestamp is required",
 			})
 			return
 		}
 
+		video, err := h.videoService.ProcessSetVideoPoster(ctx, posterData)
+		if err != nil {
+			log.Error("failed to set video poster", sl.Err(err))
+			h.respondPosterError(w, err)
+			return
+		}
+
 		response.Respond(w, response.Response{
 			Status:  http.StatusOK,
 			Message: "ok",
-			Data:    position,
+			Data:    video,
 		})
-
 		return
 	}
 }
 
-// SaveVideoPosition saves the video position to the database
-func (h *VideoHandler) SaveVideoPosition() http.HandlerFunc {
+// respondPosterError maps poster selection failures to their HTTP status
+func (h *VideoHandler) respondPosterError(w http.ResponseWriter, err error) {
+	status, message := http.StatusInternalServerError, "internal server error"
+
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, service.ErrVideoNotFound):
+		status, message = http.StatusNotFound, "not found"
+	case errors.Is(err, service.ErrPosterTooLarge), errors.As(err, &maxBytesErr):
+		status, message = http.StatusRequestEntityTooLarge, "request entity too large"
+		err = fmt.Errorf("poster exceeds the maximum size of %d bytes", h.cfg.VideoService.Images.PosterMaxSize)
+	case errors.Is(err, service.ErrInvalidPosterImage), errors.Is(err, service.ErrInvalidPosterTimestamp):
+		status, message = http.StatusUnprocessableEntity, "unprocessable entity"
+	case errors.Is(err, service.ErrPosterSourceUnavailable):
+		status, message = http.StatusConflict, "conflict"
+	case errors.Is(err, http.ErrNotMultipart), errors.Is(err, multipart.ErrMessageTooLarge):
+		status, message = http.StatusBadRequest, "bad request"
+	}
+
+	response.Respond(w, response.Response{
+		Status:  status,
+		Message: message,
+		Data:    err.Error(),
+	})
+}
+
+// UploadCaption adds or replaces the caption track of a video in the "language" form field
+// from an SRT or WebVTT "file"
+func (h *VideoHandler) UploadCaption() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		const op string = "VideoHandler.SaveVideoPosition"
+		const op string = "VideoHandler.UploadCaption"
 
 		log := h.log.With(
 			sl.String("op", op),
 		)
 
-		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
 
 		videoUUID := chi.URLParam(r, "uuid")
 		if videoUUID == "" {
-			log.Error("video_uuid is required")
+			log.Error("uuid is required")
 			response.Respond(w, response.Response{
 				Status:  http.StatusInternalServerError,
 				Message: "internal server error",
-				Data:    "video_uuid is required",
+				Data:    "uuid is required",
 			})
 			return
 		}
 
-		var positionRequest request.VideoSavePositionRequest
+		r.Body = http.MaxBytesReader(w, r.Body, service.MaxCaptionSize+maxUploadFieldsSize)
 
-		if err := render.DecodeJSON(r.Body, &positionRequest); err != nil {
-			log.Error("failed to decode request body", sl.Err(err))
-			response.Respond(w, response.Response{
-				Status:  http.StatusInternalServerError,
-				Message: "internal server error",
-				Data:    err.Error(),
-			})
+		if err := r.ParseMultipartForm(maxUploadFieldsSize); err != nil {
+			log.Error("failed to parse multipart form", sl.Err(err))
+			h.respondCaptionError(w, err)
 			return
 		}
+		defer r.MultipartForm.RemoveAll()
+
+		captionRequest := request.VideoCaptionRequest{
+			Language: r.FormValue("language"),
+			Name:     r.FormValue("name"),
+			Default:  r.FormValue("default") == "true" || r.FormValue("default") == "1",
+		}
 
 		var validateErr validator.ValidationErrors
-		if err := h.validation.Struct(positionRequest); err != nil {
+		if err := h.validation.Struct(captionRequest); err != nil {
 			errors.As(err, &validateErr)
 			log.Error("invalid request", sl.Err(validateErr))
 			response.Respond(w, response.Response{
-				Status:  http.StatusInternalServerError,
-				Message: "internal server error",
+				Status:  http.StatusBadRequest,
+				Message: "bad request",
 				Data:    validation.ValidationError(validateErr).Error(),
 			})
 			return
 		}
 
-		userID := ctx.Value("user").(types.User).ID
-
-		if err := h.videoService.ProcessSaveOrUpdateVideoPosition(ctx, userID, videoUUID, positionRequest.Position); err != nil {
-			log.Error("failed to save video position", sl.Err(err))
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			log.Error("file is required", sl.Err(err))
 			response.Respond(w, response.Response{
-				Status:  http.StatusInternalServerError,
-				Message: "internal server error",
-				Data:    err.Error(),
+				Status:  http.StatusBadRequest,
+				Message: "bad request",
+				Data:    "file is required",
 			})
 			return
 		}
+		defer file.Close()
+
+		caption, err := h.videoService.ProcessUploadCaption(ctx, data.VideoCaptionData{
+			UUID:     videoUUID,
+			File:     file,
+			Language: captionRequest.Language,
+			Name:     captionRequest.Name,
+			Default:  captionRequest.Default,
+		})
+		if err != nil {
+			log.Error("failed to upload caption", sl.Err(err))
+			h.respondCaptionError(w, err)
+			return
+		}
 
 		response.Respond(w, response.Response{
 			Status:  http.StatusOK,
 			Message: "ok",
-			Data:    "ok",
+			Data:    caption,
 		})
-
 		return
 	}
 }
 
-// ProcessUpload processes the video upload
-// It returns a http.HandlerFunc
-func (h *VideoHandler) ProcessUpload() http.HandlerFunc {
+// GetCaptions lists the caption tracks of a video
+func (h *VideoHandler) GetCaptions() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		const op string = "VideoHandler.ProcessUpload"
+		const op string = "VideoHandler.GetCaptions"
 
 		log := h.log.With(
 			sl.String("op", op),
 		)
 
-		log.Info("processing upload")
-
-		ctx := r.Context()
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
 
-		// 8 << 30 is 8GB
-		if err := r.ParseMultipartForm(8 << 30); err != nil {
-			log.Error("failed to parse multipart form", sl.Err(err))
-			response.Respond(w, response.Response{
-				Status:  http.StatusInternalServerError,
-				Message: "internal server error",
-				Data:    err.Error(),
-			})
+		captions, err := h.videoService.ProcessGetCaptions(ctx, chi.URLParam(r, "uuid"))
+		if err != nil {
+			log.Error("failed to get captions", sl.Err(err))
+			h.respondCaptionError(w, err)
 			return
 		}
 
-		file, header, err := r.FormFile("file")
+		response.Respond(w, response.Response{
+			Status:  http.StatusOK,
+			Message: "ok",
+			Data:    captions,
+		})
+		return
+	}
+}
+
+// DeleteCaption removes the caption track of a video in one language
+func (h *VideoHandler) DeleteCaption() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.DeleteCaption"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		err := h.videoService.ProcessDeleteCaption(ctx, chi.URLParam(r, "uuid"), chi.URLParam(r, "language"))
 		if err != nil {
-			log.Error("failed to get form file", sl.Err(err))
-			response.Respond(w, response.Response{
-				Status:  http.StatusInternalServerError,
-				Message: "internal server error",
-				Data:    err.Error(),
-			})
+			log.Error("failed to delete caption", sl.Err(err))
+			h.respondCaptionError(w, err)
 			return
 		}
-		defer func(file multipart.File) {
-			if err := file.Close(); err != nil {
-				log.Error("failed to close file", sl.Err(err))
-			}
-		}(file)
 
-		uploadRequest := request.VideoUploadRequest{
-			File:        *header,
-			Name:        r.FormValue("name"),
-			Description: r.FormValue("description"),
-		}
+		response.Respond(w, response.Response{
+			Status:  http.StatusOK,
+			Message: "ok",
+		})
+		return
+	}
+}
 
-		var validateErr validator.ValidationErrors
-		if err := h.validation.Struct(uploadRequest); err != nil {
-			errors.As(err, &validateErr)
-			log.Error("invalid request", sl.Err(validateErr))
+// respondCaptionError maps caption failures to their HTTP status
+func (h *VideoHandler) respondCaptionError(w http.ResponseWriter, err error) {
+	status, message := http.StatusInternalServerError, "internal server error"
+
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, service.ErrVideoNotFound), errors.Is(err, service.ErrCaptionNotFound):
+		status, message = http.StatusNotFound, "not found"
+	case errors.Is(err, service.ErrCaptionTooLarge), errors.As(err, &maxBytesErr):
+		status, message = http.StatusRequestEntityTooLarge, "request entity too large"
+		err = fmt.Errorf("caption file exceeds the maximum size of %d bytes", service.MaxCaptionSize)
+	case errors.Is(err, service.ErrInvalidCaption):
+		status, message = http.StatusUnprocessableEntity, "unprocessable entity"
+	case errors.Is(err, http.ErrNotMultipart), errors.Is(err, multipart.ErrMessageTooLarge):
+		status, message = http.StatusBadRequest, "bad request"
+	}
+
+	response.Respond(w, response.Response{
+		Status:  status,
+		Message: message,
+		Data:    err.Error(),
+	})
+}
+
+// UploadAudioTrack attaches an alternate audio "file" to a video and queues its transcode.
+// Like ProcessUpload the multipart body is streamed: the language, name, kind and default
+// fields must come before the file part.
+func (h *VideoHandler) UploadAudioTrack() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.UploadAudioTrack"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		ctx := r.Context()
+
+		videoUUID := chi.URLParam(r, "uuid")
+		if videoUUID == "" {
+			log.Error("uuid is required")
 			response.Respond(w, response.Response{
 				Status:  http.StatusInternalServerError,
 				Message: "internal server error",
-				Data:    validation.ValidationError(validateErr).Error(),
+				Data:    "uuid is required",
 			})
 			return
 		}
 
-		uploadData := data.VideoUploadData{
-			File:        file,
-			Header:      header,
-			Name:        uploadRequest.Name,
-			Description: uploadRequest.Description,
-		}
+		r.Body = http.MaxBytesReader(w, r.Body, h.cfg.UploadService.MaxSize+maxUploadFieldsSize)
 
-		if err = h.videoService.ProcessUpload(ctx, uploadData); err != nil {
-			log.Error("failed to process upload", sl.Err(err))
+		reader, err := r.MultipartReader()
+		if err != nil {
+			log.Error("failed to read multipart form", sl.Err(err))
 			response.Respond(w, response.Response{
-				Status:  http.StatusInternalServerError,
-				Message: "internal server error",
+				Status:  http.StatusBadRequest,
+				Message: "bad request",
 				Data:    err.Error(),
 			})
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		render.JSON(w, r, "ok")
-		return
-	}
+		fields := make(map[string]string)
+
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				log.Error("file part is missing")
+				response.Respond(w, response.Response{
+					Status:  http.StatusBadRequest,
+					Message: "bad request",
+					Data:    "file is required",
+				})
+				return
+			}
+			if err != nil {
+				log.Error("failed to read multipart part", sl.Err(err))
+				h.respondAudioTrackError(w, err)
+				return
+			}
+
+			if part.FormName() != "file" {
+				value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldsSize))
+				if err != nil {
+					log.Error("failed to read form field", sl.Err(err))
+					h.respondAudioTrackError(w, err)
+					return
+				}
+
+				fields[part.FormName()] = string(value)
+				continue
+			}
+
+			audioRequest := request.VideoAudioTrackRequest{
+				Language: fields["language"],
+				Name:     fields["name"],
+				Kind:     fields["kind"],
+				Default:  fields["default"] == "true" || fields["default"] == "1",
+			}
+
+			var validateErr validator.ValidationErrors
+			if err := h.validation.Struct(audioRequest); err != nil {
+				errors.As(err, &validateErr)
+				log.Error("invalid request", sl.Err(validateErr))
+				response.Respond(w, response.Response{
+					Status:  http.StatusBadRequest,
+					Message: "bad request",
+					Data:    validation.ValidationError(validateErr).Error() + " (form fields must precede the file)",
+				})
+				return
+			}
+
+			track, err := h.videoService.ProcessUploadAudioTrack(ctx, data.VideoAudioTrackData{
+				UUID:     videoUUID,
+				File:     part,
+				Filename: part.FileName(),
+				Language: audioRequest.Language,
+				Name:     audioRequest.Name,
+				Kind:     audioRequest.Kind,
+				Default:  audioRequest.Default,
+			})
+			if err != nil {
+				log.Error("failed to upload audio track", sl.Err(err))
+				h.respondAudioTrackError(w, err)
+				return
+			}
+
+			response.Respond(w, response.Response{
+				Status:  http.StatusOK,
+				Message: "ok",
+				Data:    track,
+			})
+			return
+		}
+	}
+}
+
+// GetAudioTracks lists the alternate audio tracks of a video
+func (h *VideoHandler) GetAudioTracks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.GetAudioTracks"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		tracks, err := h.videoService.ProcessGetAudioTracks(ctx, chi.URLParam(r, "uuid"))
+		if err != nil {
+			log.Error("failed to get audio tracks", sl.Err(err))
+			h.respondAudioTrackError(w, err)
+			return
+		}
+
+		response.Respond(w, response.Response{
+			Status:  http.StatusOK,
+			Message: "ok",
+			Data:    tracks,
+		})
+		return
+	}
+}
+
+// DeleteAudioTrack removes an alternate audio track of a video
+func (h *VideoHandler) DeleteAudioTrack() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.DeleteAudioTrack"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			log.Error("invalid audio track id", sl.Err(err))
+			response.Respond(w, response.Response{
+				Status:  http.StatusBadRequest,
+				Message: "bad request",
+				Data:    "invalid audio track id",
+			})
+			return
+		}
+
+		if err := h.videoService.ProcessDeleteAudioTrack(ctx, chi.URLParam(r, "uuid"), id); err != nil {
+			log.Error("failed to delete audio track", sl.Err(err))
+			h.respondAudioTrackError(w, err)
+			return
+		}
+
+		response.Respond(w, response.Response{
+			Status:  http.StatusOK,
+			Message: "ok",
+		})
+		return
+	}
+}
+
+// respondAudioTrackError maps audio track failures to their HTTP status
+func (h *VideoHandler) respondAudioTrackError(w http.ResponseWriter, err error) {
+	status, message := http.StatusInternalServerError, "internal server error"
+
+	var maxBytesErr *http.MaxBytesError
+	var mediaErr *service.MediaValidationError
+	switch {
+	case errors.As(err, &mediaErr):
+		response.Respond(w, response.Response{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "unprocessable entity",
+			Data:    mediaErr,
+		})
+		return
+	case errors.Is(err, service.ErrVideoNotFound), errors.Is(err, service.ErrAudioTrackNotFound):
+		status, message = http.StatusNotFound, "not found"
+	case errors.Is(err, service.ErrAudioTrackExists):
+		status, message = http.StatusConflict, "conflict"
+	case errors.Is(err, service.ErrUploadTooLarge), errors.As(err, &maxBytesErr):
+		status, message = http.StatusRequestEntityTooLarge, "request entity too large"
+		err = fmt.Errorf("audio file exceeds the maximum size of %d bytes", h.cfg.UploadService.MaxSize)
+	case errors.Is(err, service.ErrInvalidAudioTrack):
+		status, message = http.StatusUnprocessableEntity, "unprocessable entity"
+	}
+
+	response.Respond(w, response.Response{
+		Status:  status,
+		Message: message,
+		Data:    err.Error(),
+	})
+}
+
+// GetTranscodeWorkers reports the health of the transcode workers of this node
+func (h *VideoHandler) GetTranscodeWorkers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		workers := h.videoService.ProcessGetTranscodeWorkersHealth()
+
+		status, message := http.StatusOK, "ok"
+		for _, worker := range workers {
+			if !worker.Healthy {
+				status, message = http.StatusServiceUnavailable, "unhealthy"
+				break
+			}
+		}
+
+		response.Respond(w, response.Response{
+			Status:  status,
+			Message: message,
+			Data:    workers,
+		})
+		return
+	}
+}
+
+// GetVideoJob returns the latest transcode job of a video with its progress
+func (h *VideoHandler) GetVideoJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.GetVideoJob"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		videoUUID := chi.URLParam(r, "uuid")
+		if videoUUID == "" {
+			log.Error("uuid is required")
+			response.Respond(w, response.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "internal server error",
+				Data:    "uuid is required",
+			})
+			return
+		}
+
+		job, err := h.videoService.ProcessGetVideoJob(ctx, videoUUID)
+		if err != nil {
+			log.Error("failed to get video job", sl.Err(err))
+			response.Respond(w, response.Response{
+				Status:  http.StatusNotFound,
+				Message: "not found",
+				Data:    err.Error(),
+			})
+			return
+		}
+
+		response.Respond(w, response.Response{
+			Status:  http.StatusOK,
+			Message: "ok",
+			Data:    job,
+		})
+		return
+	}
+}
+
+// GetVideoDetails returns a video with the technical media info of its source and renditions
+func (h *VideoHandler) GetVideoDetails() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.GetVideoDetails"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		videoUUID := chi.URLParam(r, "uuid")
+		if videoUUID == "" {
+			log.Error("uuid is required")
+			response.Respond(w, response.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "internal server error",
+				Data:    "uuid is required",
+			})
+			return
+		}
+
+		details, err := h.videoService.ProcessGetVideoDetails(ctx, videoUUID)
+		if err != nil {
+			log.Error("failed to get video details", sl.Err(err))
+			response.Respond(w, response.Response{
+				Status:  http.StatusNotFound,
+				Message: "not found",
+				Data:    err.Error(),
+			})
+			return
+		}
+
+		response.Respond(w, response.Response{
+			Status:  http.StatusOK,
+			Message: "ok",
+			Data:    details,
+		})
+		return
+	}
+}
+
+// CancelVideoJob cancels the pending or running transcode job of a video
+func (h *VideoHandler) CancelVideoJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.CancelVideoJob"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		videoUUID := chi.URLParam(r, "uuid")
+		if videoUUID == "" {
+			log.Error("uuid is required")
+			response.Respond(w, response.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "internal server error",
+				Data:    "uuid is required",
+			})
+			return
+		}
+
+		if err := h.videoService.ProcessCancelVideoJob(ctx, videoUUID); err != nil {
+			log.Error("failed to cancel video job", sl.Err(err))
+
+			status, message := http.StatusInternalServerError, "internal server error"
+			switch {
+			case errors.Is(err, service.ErrTranscodeJobNotFound):
+				status, message = http.StatusNotFound, "not found"
+			case errors.Is(err, service.ErrTranscodeJobNotCancellable):
+				status, message = http.StatusConflict, "conflict"
+			}
+
+			response.Respond(w, response.Response{
+				Status:  status,
+				Message: message,
+				Data:    err.Error(),
+			})
+			return
+		}
+
+		response.Respond(w, response.Response{
+			Status:  http.StatusOK,
+			Message: "ok",
+			Data:    "transcode job cancelled",
+		})
+		return
+	}
+}
+
+// GetVideoPosition gets the video position by uuid and user uuid
+func (h *VideoHandler) GetVideoPosition() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.GetVideoPosition"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		videoUUID := chi.URLParam(r, "uuid")
+		if videoUUID == "" {
+			log.Error("uuid is required")
+			response.Respond(w, response.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "internal server error",
+				Data:    "uuid is required",
+			})
+			return
+		}
+
+		userID := ctx.Value("user").(types.User).ID
+
+		position, err := h.videoService.ProcessGetVideoPosition(ctx, userID, videoUUID)
+		if err != nil {
+			log.Error("failed to get video position", sl.Err(err))
+			response.Respond(w, response.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "internal server error",
+				Data:    err,
+			})
+			return
+		}
+
+		response.Respond(w, response.Response{
+			Status:  http.StatusOK,
+			Message: "ok",
+			Data:    position,
+		})
+
+		return
+	}
+}
+
+// SaveVideoPosition saves the video position to the database
+func (h *VideoHandler) SaveVideoPosition() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.SaveVideoPosition"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		videoUUID := chi.URLParam(r, "uuid")
+		if videoUUID == "" {
+			log.Error("video_uuid is required")
+			response.Respond(w, response.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "internal server error",
+				Data:    "video_uuid is required",
+			})
+			return
+		}
+
+		var positionRequest request.VideoSavePositionRequest
+
+		if err := render.DecodeJSON(r.Body, &positionRequest); err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+			response.Respond(w, response.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "internal server error",
+				Data:    err.Error(),
+			})
+			return
+		}
+
+		var validateErr validator.ValidationErrors
+		if err := h.validation.Struct(positionRequest); err != nil {
+			errors.As(err, &validateErr)
+			log.Error("invalid request", sl.Err(validateErr))
+			response.Respond(w, response.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "internal server error",
+				Data:    validation.ValidationError(validateErr).Error(),
+			})
+			return
+		}
+
+		userID := ctx.Value("user").(types.User).ID
+
+		if err := h.videoService.ProcessSaveOrUpdateVideoPosition(ctx, userID, videoUUID, positionRequest.Position); err != nil {
+			log.Error("failed to save video position", sl.Err(err))
+			response.Respond(w, response.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "internal server error",
+				Data:    err.Error(),
+			})
+			return
+		}
+
+		response.Respond(w, response.Response{
+			Status:  http.StatusOK,
+			Message: "ok",
+			Data:    "ok",
+		})
+
+		return
+	}
+}
+
+// ProcessUpload processes the video upload
+// It returns a http.HandlerFunc.
+// The multipart body is read part by part: the name and description fields must come
+// before the file part, which is streamed straight into storage.
+func (h *VideoHandler) ProcessUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op string = "VideoHandler.ProcessUpload"
+
+		log := h.log.With(
+			sl.String("op", op),
+		)
+
+		log.Info("processing upload")
+
+		ctx := r.Context()
+
+		r.Body = http.MaxBytesReader(w, r.Body, h.cfg.UploadService.MaxSize+maxUploadFieldsSize)
+
+		reader, err := r.MultipartReader()
+		if err != nil {
+			log.Error("failed to read multipart form", sl.Err(err))
+			response.Respond(w, response.Response{
+				Status:  http.StatusBadRequest,
+				Message: "bad request",
+				Data:    err.Error(),
+			})
+			return
+		}
+
+		fields := make(map[string]string)
+
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				log.Error("file part is missing")
+				response.Respond(w, response.Response{
+					Status:  http.StatusBadRequest,
+					Message: "bad request",
+					Data:    "file is required",
+				})
+				return
+			}
+			if err != nil {
+				log.Error("failed to read multipart part", sl.Err(err))
+				h.respondUploadError(w, err)
+				return
+			}
+
+			if part.FormName() != "file" {
+				value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldsSize))
+				if err != nil {
+					log.Error("failed to read form field", sl.Err(err))
+					h.respondUploadError(w, err)
+					return
+				}
+
+				fields[part.FormName()] = string(value)
+				continue
+			}
+
+			uploadRequest := request.VideoUploadRequest{
+				Filename:    part.FileName(),
+				Name:        fields["name"],
+				Description: fields["description"],
+			}
+
+			var validateErr validator.ValidationErrors
+			if err := h.validation.Struct(uploadRequest); err != nil {
+				errors.As(err, &validateErr)
+				log.Error("invalid request", sl.Err(validateErr))
+				response.Respond(w, response.Response{
+					Status:  http.StatusBadRequest,
+					Message: "bad request",
+					Data:    validation.ValidationError(validateErr).Error() + " (form fields must precede the file)",
+				})
+				return
+			}
+
+			uploadData := data.VideoUploadData{
+				File:        part,
+				Filename:    uploadRequest.Filename,
+				Name:        uploadRequest.Name,
+				Description: uploadRequest.Description,
+			}
+
+			if err = h.videoService.ProcessUpload(ctx, uploadData); err != nil {
+				log.Error("failed to process upload", sl.Err(err))
+				h.respondUploadError(w, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+			render.JSON(w, r, "ok")
+			return
+		}
+	}
+}
+
+// respondUploadError maps streaming upload failures to their HTTP status
+func (h *VideoHandler) respondUploadError(w http.ResponseWriter, err error) {
+	status, message := http.StatusInternalServerError, "internal server error"
+
+	var maxBytesErr *http.MaxBytesError
+	var mediaErr *service.MediaValidationError
+	switch {
+	case errors.As(err, &mediaErr):
+		response.Respond(w, response.Response{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "unprocessable entity",
+			Data:    mediaErr,
+		})
+		return
+	case errors.Is(err, service.ErrUploadTooLarge), errors.As(err, &maxBytesErr):
+		status, message = http.StatusRequestEntityTooLarge, "request entity too large"
+		err = fmt.Errorf("upload exceeds the maximum size of %d bytes", h.cfg.UploadService.MaxSize)
+	case errors.Is(err, service.ErrUnsupportedMediaType):
+		status, message = http.StatusUnsupportedMediaType, "unsupported media type"
+	}
+
+	response.Respond(w, response.Response{
+		Status:  status,
+		Message: message,
+		Data:    err.Error(),
+	})
 }
 
 // DeleteVideo deletes a video by uuid from the database and storage
@@ -7,15 +7,18 @@ import (
 type Middleware struct {
 	ClientAuthMiddleware *ClientAuthMiddleware
 	AdminAuthMiddleware  *AdminAuthMiddleware
+	SignedURLMiddleware  *SignedURLMiddleware
 }
 
 func NewMiddlewares(
 	clientAuth *ClientAuthMiddleware,
 	adminAuth *AdminAuthMiddleware,
+	signedURL *SignedURLMiddleware,
 ) *Middleware {
 	return &Middleware{
 		ClientAuthMiddleware: clientAuth,
 		AdminAuthMiddleware:  adminAuth,
+		SignedURLMiddleware:  signedURL,
 	}
 }
 
@@ -25,6 +28,7 @@ func NewMiddleware() fx.Option {
 		fx.Provide(
 			NewClientAuthMiddleware,
 			NewAdminAuthMiddleware,
+			NewSignedURLMiddleware,
 			NewMiddlewares,
 		),
 	)
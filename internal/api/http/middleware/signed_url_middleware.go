@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go-fitness/external/config"
+	"go-fitness/external/logger/sl"
+	"go-fitness/external/response"
+	"go-fitness/external/urlsign"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type SignedURLMiddleware struct {
+	log *slog.Logger
+	// signer is nil while signing is disabled
+	signer *urlsign.Signer
+
+	clientAuth *ClientAuthMiddleware
+}
+
+func NewSignedURLMiddleware(
+	log *slog.Logger,
+	cfg *config.Config,
+	clientAuth *ClientAuthMiddleware,
+) *SignedURLMiddleware {
+	var signer *urlsign.Signer
+	if cfg.VideoService.SignedURLs.Secret != "" {
+		signer = urlsign.New(cfg.VideoService.SignedURLs.Secret)
+	}
+
+	return &SignedURLMiddleware{
+		log:        log,
+		signer:     signer,
+		clientAuth: clientAuth,
+	}
+}
+
+// New verifies the token of a signed URL against the video of the route without any database lookup.
+// Requests without a token, or any request while signing is disabled, go through ClientAuthMiddleware instead.
+func (m *SignedURLMiddleware) New() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authenticated := m.clientAuth.New()(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const op string = "http.middleware.SignedURLMiddleware.New"
+
+			token := r.URL.Query().Get(urlsign.QueryParam)
+			if token == "" || m.signer == nil {
+				authenticated.ServeHTTP(w, r)
+				return
+			}
+
+			log := m.log.With(
+				sl.String("op", op),
+				sl.String("request_id", middleware.GetReqID(r.Context())),
+			)
+
+			forbiddenResponse := func(logMessage string, err error) {
+				if err != nil {
+					log.Warn(logMessage, sl.Err(err))
+				} else {
+					log.Warn(logMessage)
+				}
+				response.Respond(w, response.Response{
+					Status:  http.StatusForbidden,
+					Message: "Forbidden",
+				})
+			}
+
+			claims, err := m.signer.Verify(token, time.Now())
+			if err != nil {
+				forbiddenResponse("failed to verify url token", err)
+				return
+			}
+
+			if claims.Video != chi.URLParam(r, "uuid") {
+				forbiddenResponse("url token was issued for another video", nil)
+				return
+			}
+
+			if claims.IP != "" && claims.IP != urlsign.ClientIP(r) {
+				forbiddenResponse("url token was issued to another ip", nil)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(urlsign.NewContext(r.Context(), claims)))
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/patrickmn/go-cache"
+	"go-fitness/external/config"
+	"go-fitness/external/urlsign"
+	"go-fitness/internal/api/repository"
+	"go-fitness/internal/api/types"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	testVideoUUID = "0f8a6f3e-6a4e-4d1c-9a53-1b0e0c6c2f41"
+	testUserUUID  = "5d2c1c7a-3b9e-4c44-8f0a-2e6b7f9d1a10"
+	testJWT       = "jwt-secret"
+	testURLSecret = "url-secret"
+)
+
+type fakeUserRepository struct {
+	repository.UserRepositoryInterface
+}
+
+func (fakeUserRepository) GetUserByUUID(_ context.Context, uuid string) (types.User, error) {
+	if uuid != testUserUUID {
+		return types.User{}, sql.ErrNoRows
+	}
+
+	return types.User{ID: 1, UUID: uuid}, nil
+}
+
+// newTestRouter returns a router serving the media of a video behind SignedURLMiddleware,
+// which reports the user the request was authenticated as: the one of the url token or of the JWT
+func newTestRouter(t *testing.T, urlSecret string) http.Handler {
+	t.Helper()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	cfg := &config.Config{JWT: testJWT}
+	cfg.VideoService.SignedURLs.Secret = urlSecret
+
+	clientAuth := NewClientAuthMiddleware(log, cache.New(time.Minute, time.Minute), fakeUserRepository{}, cfg)
+
+	router := chi.NewRouter()
+	router.With(NewSignedURLMiddleware(log, cfg, clientAuth).New()).Get("/videos/{uuid}/{file}", func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := urlsign.FromContext(r.Context()); ok {
+			_, _ = io.WriteString(w, "token:"+claims.User)
+			return
+		}
+		if user, ok := r.Context().Value("user").(types.User); ok {
+			_, _ = io.WriteString(w, "jwt:"+user.UUID)
+			return
+		}
+		t.Error("the request reached the handler unauthenticated")
+	})
+
+	return router
+}
+
+func signedToken(claims urlsign.Claims) string {
+	return urlsign.New(testURLSecret).Sign(claims)
+}
+
+func bearerToken(t *testing.T, userUUID string) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_uuid": userUUID}).SignedString([]byte(testJWT))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return "Bearer " + token
+}
+
+func TestSignedURLMiddleware(t *testing.T) {
+	valid := urlsign.Claims{
+		Video:   testVideoUUID,
+		User:    testUserUUID,
+		Expires: time.Now().Add(time.Hour).Unix(),
+	}
+
+	withClaims := func(edit func(*urlsign.Claims)) string {
+		claims := valid
+		edit(&claims)
+		return signedToken(claims)
+	}
+
+	tests := []struct {
+		name          string
+		urlSecret     string
+		token         string
+		authorization string
+		remoteAddr    string
+		wantStatus    int
+		wantBody      string
+	}{
+		{
+			name:       "valid token",
+			urlSecret:  testURLSecret,
+			token:      signedToken(valid),
+			wantStatus: http.StatusOK,
+			wantBody:   "token:" + testUserUUID,
+		},
+		{
+			name:       "token bound to the client ip",
+			urlSecret:  testURLSecret,
+			token:      withClaims(func(claims *urlsign.Claims) { claims.IP = "203.0.113.7" }),
+			remoteAddr: "203.0.113.7:51234",
+			wantStatus: http.StatusOK,
+			wantBody:   "token:" + testUserUUID,
+		},
+		{
+			name:       "token bound to another ip",
+			urlSecret:  testURLSecret,
+			token:      withClaims(func(claims *urlsign.Claims) { claims.IP = "198.51.100.1" }),
+			remoteAddr: "203.0.113.7:51234",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "token of another video",
+			urlSecret:  testURLSecret,
+			token:      withClaims(func(claims *urlsign.Claims) { claims.Video = "8c1e2b9f-2d7a-4f3b-b6c5-9e0d4a7f1c22" }),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "expired token",
+			urlSecret:  testURLSecret,
+			token:      withClaims(func(claims *urlsign.Claims) { claims.Expires = time.Now().Add(-time.Minute).Unix() }),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "token signed with another secret",
+			urlSecret:  testURLSecret,
+			token:      urlsign.New("other").Sign(valid),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "malformed token",
+			urlSecret:  testURLSecret,
+			token:      "malformed",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:          "invalid token with a valid JWT",
+			urlSecret:     testURLSecret,
+			token:         "malformed",
+			authorization: bearerToken(t, testUserUUID),
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "no token falls back to the JWT",
+			urlSecret:     testURLSecret,
+			authorization: bearerToken(t, testUserUUID),
+			wantStatus:    http.StatusOK,
+			wantBody:      "jwt:" + testUserUUID,
+		},
+		{
+			name:       "no token and no JWT",
+			urlSecret:  testURLSecret,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "no token and the JWT of an unknown user",
+			urlSecret:     testURLSecret,
+			authorization: bearerToken(t, "a3e7d5b1-9c2f-4e8a-b0d6-7f1c3e5a9b24"),
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:       "token while signing is disabled",
+			token:      signedToken(valid),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "JWT while signing is disabled",
+			token:         signedToken(valid),
+			authorization: bearerToken(t, testUserUUID),
+			wantStatus:    http.StatusOK,
+			wantBody:      "jwt:" + testUserUUID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/videos/" + testVideoUUID + "/720_000.ts"
+			if tt.token != "" {
+				target += "?" + urlsign.QueryParam + "=" + tt.token
+			}
+
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+
+			rec := httptest.NewRecorder()
+			newTestRouter(t, tt.urlSecret).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
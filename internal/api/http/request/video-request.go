@@ -1,11 +1,9 @@
 package request
 
-import "mime/multipart"
-
 type VideoUploadRequest struct {
-	File        multipart.FileHeader `json:"file" validate:"required"`
-	Name        string               `json:"name" validate:"required"`
-	Description string               `json:"description"`
+	Filename    string `json:"filename" validate:"required"`
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
 }
 
 type VideoSavePositionRequest struct {
@@ -16,3 +14,23 @@ type VideoUpdateRequest struct {
 	Name        string `json:"name" validate:"required"`
 	Description string `json:"description"`
 }
+
+type UploadCreateRequest struct {
+	Filename    string `json:"filename" validate:"required"`
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+	Size        int64  `json:"size" validate:"min=1"`
+}
+
+type VideoCaptionRequest struct {
+	Language string `json:"language" validate:"required"`
+	Name     string `json:"name" validate:"required,max=255"`
+	Default  bool   `json:"default"`
+}
+
+type VideoAudioTrackRequest struct {
+	Language string `json:"language" validate:"required"`
+	Name     string `json:"name" validate:"required,max=255"`
+	Kind     string `json:"kind" validate:"required,oneof=dub commentary music"`
+	Default  bool   `json:"default"`
+}
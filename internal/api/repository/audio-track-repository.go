@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-fitness/external/db"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/types"
+	"time"
+)
+
+const audioTrackColumns = `
+	id,hash_name,language,name,kind,is_default,state,bandwidth,average_bandwidth,codecs,created_at,updated_at
+`
+
+type AudioTrackRepository struct {
+	db db.SqlInterface
+}
+
+type AudioTrackRepositoryInterface interface {
+	Create(context.Context, types.VideoAudioTrack) (int64, error)
+	GetByID(context.Context, int64) (types.VideoAudioTrack, error)
+	GetByHashName(context.Context, string) ([]types.VideoAudioTrack, error)
+	GetReadyByHashName(context.Context, string) ([]types.VideoAudioTrack, error)
+	MarkReady(context.Context, int64, int64, int64, string) error
+	UpdateState(context.Context, int64, enum.AudioTrackState) error
+	Delete(context.Context, int64) error
+	DeleteByHashName(context.Context, string) error
+}
+
+func NewAudioTrackRepository(
+	db db.SqlInterface,
+) *AudioTrackRepository {
+	return &AudioTrackRepository{
+		db: db,
+	}
+}
+
+// Create inserts a pending audio track. A default track takes the default flag from the other tracks of the hash.
+func (r *AudioTrackRepository) Create(ctx context.Context, track types.VideoAudioTrack) (int64, error) {
+	const op string = "AudioTrackRepository.Create"
+
+	const clearDefaultQuery string = `
+		UPDATE video_audio_tracks 
+		SET is_default = 0 
+		WHERE hash_name = ?
+	`
+
+	const query string = `
+		INSERT INTO video_audio_tracks 
+		    (hash_name,language,name,kind,is_default,state,created_at,updated_at) 
+		VALUES (?,?,?,?,?,?,?,?)
+	`
+
+	var id int64
+
+	err := r.db.DoInTransaction(func(tx *sql.Tx) error {
+		now := time.Now()
+
+		if track.IsDefault {
+			if _, err := tx.ExecContext(ctx, clearDefaultQuery, track.HashName); err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.ExecContext(ctx, query,
+			track.HashName,
+			track.Language,
+			track.Name,
+			track.Kind,
+			track.IsDefault,
+			enum.AudioTrackStatePending,
+			now,
+			now,
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func (r *AudioTrackRepository) GetByID(ctx context.Context, id int64) (types.VideoAudioTrack, error) {
+	const op string = "AudioTrackRepository.GetByID"
+
+	const query string = `
+		SELECT ` + audioTrackColumns + `
+		FROM video_audio_tracks 
+		WHERE id = ?
+	`
+
+	track, err := scanAudioTrack(r.db.GetExecer().QueryRowContext(ctx, query, id))
+	if err != nil {
+		return track, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return track, nil
+}
+
+func (r *AudioTrackRepository) GetByHashName(ctx context.Context, hashName string) ([]types.VideoAudioTrack, error) {
+	const op string = "AudioTrackRepository.GetByHashName"
+
+	const query string = `
+		SELECT ` + audioTrackColumns + `
+		FROM video_audio_tracks 
+		WHERE hash_name = ? 
+		ORDER BY id
+	`
+
+	tracks, err := r.queryAudioTracks(ctx, query, hashName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tracks, nil
+}
+
+// GetReadyByHashName returns the tracks of a hash that can be listed in its master playlist
+func (r *AudioTrackRepository) GetReadyByHashName(ctx context.Context, hashName string) ([]types.VideoAudioTrack, error) {
+	const op string = "AudioTrackRepository.GetReadyByHashName"
+
+	const query string = `
+		SELECT ` + audioTrackColumns + `
+		FROM video_audio_tracks 
+		WHERE hash_name = ? 
+		  AND state = ? 
+		ORDER BY id
+	`
+
+	tracks, err := r.queryAudioTracks(ctx, query, hashName, enum.AudioTrackStateReady)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tracks, nil
+}
+
+// MarkReady stores the measured bandwidth and codecs of a transcoded track and marks it as ready
+func (r *AudioTrackRepository) MarkReady(
+	ctx context.Context,
+	id int64,
+	bandwidth int64,
+	averageBandwidth int64,
+	codecs string,
+) error {
+	const op string = "AudioTrackRepository.MarkReady"
+
+	const query string = `
+		UPDATE video_audio_tracks 
+		SET state = ?, bandwidth = ?, average_bandwidth = ?, codecs = ?, updated_at = ? 
+		WHERE id = ?
+	`
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query,
+		enum.AudioTrackStateReady,
+		bandwidth,
+		averageBandwidth,
+		codecs,
+		time.Now(),
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (r *AudioTrackRepository) UpdateState(ctx context.Context, id int64, state enum.AudioTrackState) error {
+	const op string = "AudioTrackRepository.UpdateState"
+
+	const query string = `
+		UPDATE video_audio_tracks 
+		SET state = ?, updated_at = ? 
+		WHERE id = ?
+	`
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, state, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (r *AudioTrackRepository) Delete(ctx context.Context, id int64) error {
+	const op string = "AudioTrackRepository.Delete"
+
+	const query string = "DELETE FROM video_audio_tracks WHERE id = ?"
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (r *AudioTrackRepository) DeleteByHashName(ctx context.Context, hashName string) error {
+	const op string = "AudioTrackRepository.DeleteByHashName"
+
+	const query string = "DELETE FROM video_audio_tracks WHERE hash_name = ?"
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, hashName)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (r *AudioTrackRepository) queryAudioTracks(ctx context.Context, query string, args ...any) ([]types.VideoAudioTrack, error) {
+	rows, err := r.db.GetExecer().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tracks []types.VideoAudioTrack
+	for rows.Next() {
+		var track types.VideoAudioTrack
+
+		if err = rows.Scan(
+			&track.ID,
+			&track.HashName,
+			&track.Language,
+			&track.Name,
+			&track.Kind,
+			&track.IsDefault,
+			&track.State,
+			&track.Bandwidth,
+			&track.AverageBandwidth,
+			&track.Codecs,
+			&track.CreatedAt,
+			&track.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		tracks = append(tracks, track)
+	}
+
+	return tracks, nil
+}
+
+func scanAudioTrack(row *sql.Row) (types.VideoAudioTrack, error) {
+	var track types.VideoAudioTrack
+
+	err := row.Scan(
+		&track.ID,
+		&track.HashName,
+		&track.Language,
+		&track.Name,
+		&track.Kind,
+		&track.IsDefault,
+		&track.State,
+		&track.Bandwidth,
+		&track.AverageBandwidth,
+		&track.Codecs,
+		&track.CreatedAt,
+		&track.UpdatedAt,
+	)
+
+	return track, err
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-fitness/external/db"
+	"go-fitness/internal/api/types"
+	"time"
+)
+
+type CaptionRepository struct {
+	db db.SqlInterface
+}
+
+type CaptionRepositoryInterface interface {
+	Save(context.Context, types.VideoCaption) error
+	GetByHashName(context.Context, string) ([]types.VideoCaption, error)
+	Delete(context.Context, string, string) error
+	DeleteByHashName(context.Context, string) error
+}
+
+func NewCaptionRepository(
+	db db.SqlInterface,
+) *CaptionRepository {
+	return &CaptionRepository{
+		db: db,
+	}
+}
+
+// Save inserts a caption or replaces the one of the same hash and language.
+// A default caption takes the default flag from the other captions of the hash.
+func (r *CaptionRepository) Save(ctx context.Context, caption types.VideoCaption) error {
+	const op string = "CaptionRepository.Save"
+
+	const clearDefaultQuery string = `
+		UPDATE video_captions 
+		SET is_default = 0 
+		WHERE hash_name = ?
+	`
+
+	const query string = `
+		INSERT INTO video_captions 
+		    (hash_name,language,name,is_default,created_at,updated_at) 
+		VALUES (?,?,?,?,?,?)
+		ON DUPLICATE KEY UPDATE
+		    name = VALUES(name), is_default = VALUES(is_default), updated_at = VALUES(updated_at)
+	`
+
+	err := r.db.DoInTransaction(func(tx *sql.Tx) error {
+		now := time.Now()
+
+		if caption.IsDefault {
+			if _, err := tx.ExecContext(ctx, clearDefaultQuery, caption.HashName); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, query,
+			caption.HashName,
+			caption.Language,
+			caption.Name,
+			caption.IsDefault,
+			now,
+			now,
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (r *CaptionRepository) GetByHashName(ctx context.Context, hashName string) ([]types.VideoCaption, error) {
+	const op string = "CaptionRepository.GetByHashName"
+
+	const query string = `
+		SELECT id,hash_name,language,name,is_default,created_at,updated_at 
+		FROM video_captions 
+		WHERE hash_name = ? 
+		ORDER BY language
+	`
+
+	rows, err := r.db.GetExecer().QueryContext(ctx, query, hashName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var captions []types.VideoCaption
+	for rows.Next() {
+		var caption types.VideoCaption
+
+		if err = rows.Scan(
+			&caption.ID,
+			&caption.HashName,
+			&caption.Language,
+			&caption.Name,
+			&caption.IsDefault,
+			&caption.CreatedAt,
+			&caption.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		captions = append(captions, caption)
+	}
+
+	return captions, nil
+}
+
+// Delete removes the caption of a hash in the given language, returning sql.ErrNoRows when there is none
+func (r *CaptionRepository) Delete(ctx context.Context, hashName string, language string) error {
+	const op string = "CaptionRepository.Delete"
+
+	const query string = "DELETE FROM video_captions WHERE hash_name = ? AND language = ?"
+
+	result, err := r.db.GetExecer().ExecContext(ctx, query, hashName, language)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+func (r *CaptionRepository) DeleteByHashName(ctx context.Context, hashName string) error {
+	const op string = "CaptionRepository.DeleteByHashName"
+
+	const query string = "DELETE FROM video_captions WHERE hash_name = ?"
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, hashName)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
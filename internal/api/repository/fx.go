@@ -22,6 +22,36 @@ func NewRepository() fx.Option {
 				NewUserRepository,
 				fx.As(new(UserRepositoryInterface)),
 			),
+
+			fx.Annotate(
+				NewUploadRepository,
+				fx.As(new(UploadRepositoryInterface)),
+			),
+
+			fx.Annotate(
+				NewTranscodeJobRepository,
+				fx.As(new(TranscodeJobRepositoryInterface)),
+			),
+
+			fx.Annotate(
+				NewMediaInfoRepository,
+				fx.As(new(MediaInfoRepositoryInterface)),
+			),
+
+			fx.Annotate(
+				NewCaptionRepository,
+				fx.As(new(CaptionRepositoryInterface)),
+			),
+
+			fx.Annotate(
+				NewAudioTrackRepository,
+				fx.As(new(AudioTrackRepositoryInterface)),
+			),
+
+			fx.Annotate(
+				NewVideoKeyRepository,
+				fx.As(new(VideoKeyRepositoryInterface)),
+			),
 		),
 	)
 }
@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"go-fitness/external/db"
+	"go-fitness/internal/api/types"
+	"time"
+)
+
+type MediaInfoRepository struct {
+	db db.SqlInterface
+}
+
+type MediaInfoRepositoryInterface interface {
+	Save(context.Context, types.VideoMediaInfo) error
+	GetByHashName(context.Context, string) ([]types.VideoMediaInfo, error)
+	DeleteByHashName(context.Context, string) error
+}
+
+func NewMediaInfoRepository(
+	db db.SqlInterface,
+) *MediaInfoRepository {
+	return &MediaInfoRepository{
+		db: db,
+	}
+}
+
+// Save inserts the media info of a source or rendition, replacing the previous one of the same hash, kind and label
+func (r *MediaInfoRepository) Save(ctx context.Context, info types.VideoMediaInfo) error {
+	const op string = "MediaInfoRepository.Save"
+
+	if err := saveMediaInfo(ctx, r.db.GetExecer(), info); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func saveMediaInfo(ctx context.Context, execer db.QueryExecer, info types.VideoMediaInfo) error {
+	now := time.Now()
+
+	const query string = `
+		INSERT INTO video_media_info
+		    (hash_name,kind,label,container,duration,bitrate,peak_bitrate,size,segment_count,
+		     video_codec,video_profile,video_level,width,height,rotation,sample_aspect_ratio,frame_rate,
+		     audio_codec,audio_profile,audio_channels,created_at,updated_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+		ON DUPLICATE KEY UPDATE
+		    container = VALUES(container), duration = VALUES(duration), bitrate = VALUES(bitrate),
+		    peak_bitrate = VALUES(peak_bitrate), size = VALUES(size), segment_count = VALUES(segment_count),
+		    video_codec = VALUES(video_codec), video_profile = VALUES(video_profile), video_level = VALUES(video_level),
+		    width = VALUES(width), height = VALUES(height), rotation = VALUES(rotation),
+		    sample_aspect_ratio = VALUES(sample_aspect_ratio), frame_rate = VALUES(frame_rate),
+		    audio_codec = VALUES(audio_codec), audio_profile = VALUES(audio_profile),
+		    audio_channels = VALUES(audio_channels), updated_at = VALUES(updated_at)
+	`
+
+	_, err := execer.ExecContext(ctx, query,
+		info.HashName,
+		info.Kind,
+		info.Label,
+		info.Container,
+		info.Duration,
+		info.Bitrate,
+		info.PeakBitrate,
+		info.Size,
+		info.SegmentCount,
+		info.VideoCodec,
+		info.VideoProfile,
+		info.VideoLevel,
+		info.Width,
+		info.Height,
+		info.Rotation,
+		info.SampleAspectRatio,
+		info.FrameRate,
+		info.AudioCodec,
+		info.AudioProfile,
+		info.AudioChannels,
+		now,
+		now,
+	)
+	return err
+}
+
+func (r *MediaInfoRepository) GetByHashName(ctx context.Context, hashName string) ([]types.VideoMediaInfo, error) {
+	const op string = "MediaInfoRepository.GetByHashName"
+
+	const query string = `
+		SELECT id,hash_name,kind,label,container,duration,bitrate,peak_bitrate,size,segment_count,
+		       video_codec,video_profile,video_level,width,height,rotation,sample_aspect_ratio,frame_rate,
+		       audio_codec,audio_profile,audio_channels,created_at,updated_at
+		FROM video_media_info
+		WHERE hash_name = ?
+		ORDER BY kind, height
+	`
+
+	rows, err := r.db.GetExecer().QueryContext(ctx, query, hashName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var infos []types.VideoMediaInfo
+	for rows.Next() {
+		var info types.VideoMediaInfo
+
+		if err = rows.Scan(
+			&info.ID,
+			&info.HashName,
+			&info.Kind,
+			&info.Label,
+			&info.Container,
+			&info.Duration,
+			&info.Bitrate,
+			&info.PeakBitrate,
+			&info.Size,
+			&info.SegmentCount,
+			&info.VideoCodec,
+			&info.VideoProfile,
+			&info.VideoLevel,
+			&info.Width,
+			&info.Height,
+			&info.Rotation,
+			&info.SampleAspectRatio,
+			&info.FrameRate,
+			&info.AudioCodec,
+			&info.AudioProfile,
+			&info.AudioChannels,
+			&info.CreatedAt,
+			&info.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func (r *MediaInfoRepository) DeleteByHashName(ctx context.Context, hashName string) error {
+	const op string = "MediaInfoRepository.DeleteByHashName"
+
+	const query string = `
+		DELETE FROM video_media_info
+		WHERE hash_name = ?
+	`
+
+	if _, err := r.db.GetExecer().ExecContext(ctx, query, hashName); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
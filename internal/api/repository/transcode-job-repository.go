@@ -0,0 +1,555 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-fitness/external/db"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/types"
+	"time"
+)
+
+var (
+	ErrTranscodeJobLeaseLost         = errors.New("transcode job lease lost")
+	ErrTranscodeJobNotCancellable    = errors.New("transcode job is not pending or running")
+	ErrTranscodeJobAttemptsExhausted = errors.New("transcode job lease expired on its final attempt")
+)
+
+const transcodeJobColumns = `
+	id,video_id,kind,COALESCE(audio_track_id,0),upload_path,dst_path,chunk_hash,state,attempts,
+	COALESCE(lease_owner,''),lease_expires_at,available_at,COALESCE(last_error,''),COALESCE(progress,''),
+	created_at,updated_at
+`
+
+type TranscodeJobRepository struct {
+	db db.SqlInterface
+}
+
+type TranscodeJobRepositoryInterface interface {
+	Create(context.Context, types.TranscodeJob) (int64, error)
+	Claim(context.Context, string, time.Duration, int) (types.TranscodeJob, error)
+	ExtendLease(context.Context, int64, string, time.Duration) error
+	Complete(context.Context, int64) error
+	Fail(context.Context, int64, string) error
+	Retry(context.Context, int64, string, time.Time) error
+	Release(context.Context, int64, string) error
+	Cancel(context.Context, int64) error
+	RecordAttempt(context.Context, types.TranscodeJobAttempt) error
+	GetAttempts(context.Context, int64) ([]types.TranscodeJobAttempt, error)
+	GetByID(context.Context, int64) (types.TranscodeJob, error)
+	GetLatestByVideoID(context.Context, int64) (types.TranscodeJob, error)
+	UpdateProgress(context.Context, int64, types.TranscodeProgress) error
+	RequeueExpired(context.Context, int) (int64, error)
+}
+
+func NewTranscodeJobRepository(
+	db db.SqlInterface,
+) *TranscodeJobRepository {
+	return &TranscodeJobRepository{
+		db: db,
+	}
+}
+
+func (r *TranscodeJobRepository) Create(ctx context.Context, job types.TranscodeJob) (int64, error) {
+	const op string = "TranscodeJobRepository.Create"
+
+	id, err := insertTranscodeJob(ctx, r.db.GetExecer(), job)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func insertTranscodeJob(ctx context.Context, execer db.QueryExecer, job types.TranscodeJob) (int64, error) {
+	now := time.Now()
+
+	const query string = `
+		INSERT INTO transcode_jobs
+		    (video_id,kind,audio_track_id,upload_path,dst_path,chunk_hash,state,attempts,available_at,created_at,updated_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?)
+	`
+
+	if job.Kind == enum.TranscodeJobKindUnknown {
+		job.Kind = enum.TranscodeJobKindVideo
+	}
+
+	var audioTrackID *int64
+	if job.AudioTrackID != 0 {
+		audioTrackID = &job.AudioTrackID
+	}
+
+	res, err := execer.ExecContext(ctx, query,
+		job.VideoID,
+		job.Kind,
+		audioTrackID,
+		job.UploadPath,
+		job.DstPath,
+		job.ChunkHash,
+		enum.TranscodeJobStatePending,
+		0,
+		now,
+		now,
+		now,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Claim locks the oldest runnable job (pending and due, or running with an expired lease),
+// marks it as running for the given owner and returns it.
+// A job whose lease expired on its maxAttempts attempt, its worker having crashed or been killed,
+// is marked as failed instead and returned in that state for the caller to clean up.
+// It returns sql.ErrNoRows when there is nothing to claim.
+func (r *TranscodeJobRepository) Claim(
+	ctx context.Context,
+	owner string,
+	lease time.Duration,
+	maxAttempts int,
+) (types.TranscodeJob, error) {
+	const op string = "TranscodeJobRepository.Claim"
+
+	const selectQuery string = `
+		SELECT ` + transcodeJobColumns + `
+		FROM transcode_jobs
+		WHERE (state = ? AND (available_at IS NULL OR available_at <= ?))
+		   OR (state = ? AND lease_expires_at < ?)
+		ORDER BY id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`
+
+	const updateQuery string = `
+		UPDATE transcode_jobs
+		SET state = ?, attempts = attempts + 1, lease_owner = ?, lease_expires_at = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	const failQuery string = `
+		UPDATE transcode_jobs
+		SET state = ?, last_error = ?, lease_owner = NULL, lease_expires_at = NULL, updated_at = ?
+		WHERE id = ?
+	`
+
+	var job types.TranscodeJob
+
+	err := r.db.DoInTransaction(func(tx *sql.Tx) error {
+		now := time.Now()
+
+		row := tx.QueryRowContext(ctx, selectQuery,
+			enum.TranscodeJobStatePending,
+			now,
+			enum.TranscodeJobStateRunning,
+			now,
+		)
+
+		var err error
+		if job, err = scanTranscodeJob(row); err != nil {
+			return err
+		}
+
+		if job.State == enum.TranscodeJobStateRunning && job.Attempts >= maxAttempts {
+			if _, err := tx.ExecContext(ctx, failQuery,
+				enum.TranscodeJobStateFailed,
+				ErrTranscodeJobAttemptsExhausted.Error(),
+				now,
+				job.ID,
+			); err != nil {
+				return err
+			}
+
+			job.State = enum.TranscodeJobStateFailed
+			job.LastError = ErrTranscodeJobAttemptsExhausted.Error()
+			job.LeaseOwner = ""
+			job.LeaseExpiresAt = nil
+
+			return nil
+		}
+
+		expiresAt := now.Add(lease)
+
+		if _, err := tx.ExecContext(ctx, updateQuery,
+			enum.TranscodeJobStateRunning,
+			owner,
+			expiresAt,
+			now,
+			job.ID,
+		); err != nil {
+			return err
+		}
+
+		job.State = enum.TranscodeJobStateRunning
+		job.Attempts++
+		job.LeaseOwner = owner
+		job.LeaseExpiresAt = &expiresAt
+
+		return nil
+	})
+	if err != nil {
+		return job, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return job, nil
+}
+
+// ExtendLease pushes the lease expiry of a running job forward.
+// It returns ErrTranscodeJobLeaseLost when the job is no longer owned by the given owner.
+func (r *TranscodeJobRepository) ExtendLease(ctx context.Context, id int64, owner string, lease time.Duration) error {
+	const op string = "TranscodeJobRepository.ExtendLease"
+
+	const query string = `
+		UPDATE transcode_jobs
+		SET lease_expires_at = ?, updated_at = ?
+		WHERE id = ?
+		  AND lease_owner = ?
+		  AND state = ?
+	`
+
+	now := time.Now()
+
+	res, err := r.db.GetExecer().ExecContext(ctx, query, now.Add(lease), now, id, owner, enum.TranscodeJobStateRunning)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, ErrTranscodeJobLeaseLost)
+	}
+
+	return nil
+}
+
+func (r *TranscodeJobRepository) Complete(ctx context.Context, id int64) error {
+	const op string = "TranscodeJobRepository.Complete"
+
+	const query string = `
+		UPDATE transcode_jobs
+		SET state = ?, lease_owner = NULL, lease_expires_at = NULL, updated_at = ?
+		WHERE id = ?
+		  AND state = ?
+	`
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, enum.TranscodeJobStateCompleted, time.Now(), id, enum.TranscodeJobStateRunning)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (r *TranscodeJobRepository) Fail(ctx context.Context, id int64, lastError string) error {
+	const op string = "TranscodeJobRepository.Fail"
+
+	const query string = `
+		UPDATE transcode_jobs
+		SET state = ?, last_error = ?, lease_owner = NULL, lease_expires_at = NULL, updated_at = ?
+		WHERE id = ?
+		  AND state = ?
+	`
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, enum.TranscodeJobStateFailed, lastError, time.Now(), id, enum.TranscodeJobStateRunning)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Retry puts a failed job back to pending, to be claimed again not before availableAt
+func (r *TranscodeJobRepository) Retry(ctx context.Context, id int64, lastError string, availableAt time.Time) error {
+	const op string = "TranscodeJobRepository.Retry"
+
+	const query string = `
+		UPDATE transcode_jobs
+		SET state = ?, last_error = ?, available_at = ?, lease_owner = NULL, lease_expires_at = NULL, updated_at = ?
+		WHERE id = ?
+		  AND state = ?
+	`
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query,
+		enum.TranscodeJobStatePending,
+		lastError,
+		availableAt,
+		time.Now(),
+		id,
+		enum.TranscodeJobStateRunning,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Release hands a running job back to pending without consuming an attempt,
+// used when a worker is interrupted by a shutdown
+func (r *TranscodeJobRepository) Release(ctx context.Context, id int64, owner string) error {
+	const op string = "TranscodeJobRepository.Release"
+
+	const query string = `
+		UPDATE transcode_jobs
+		SET state = ?, attempts = GREATEST(attempts - 1, 0), available_at = ?,
+		    lease_owner = NULL, lease_expires_at = NULL, updated_at = ?
+		WHERE id = ?
+		  AND lease_owner = ?
+		  AND state = ?
+	`
+
+	now := time.Now()
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query,
+		enum.TranscodeJobStatePending,
+		now,
+		now,
+		id,
+		owner,
+		enum.TranscodeJobStateRunning,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Cancel marks a pending or running job as cancelled.
+// It returns ErrTranscodeJobNotCancellable when the job has already finished.
+// The worker running the job notices the cancellation on its next lease heartbeat.
+func (r *TranscodeJobRepository) Cancel(ctx context.Context, id int64) error {
+	const op string = "TranscodeJobRepository.Cancel"
+
+	const query string = `
+		UPDATE transcode_jobs
+		SET state = ?, last_error = ?, lease_expires_at = NULL, updated_at = ?
+		WHERE id = ?
+		  AND state IN (?, ?)
+	`
+
+	res, err := r.db.GetExecer().ExecContext(ctx, query,
+		enum.TranscodeJobStateCancelled,
+		"cancelled by admin",
+		time.Now(),
+		id,
+		enum.TranscodeJobStatePending,
+		enum.TranscodeJobStateRunning,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, ErrTranscodeJobNotCancellable)
+	}
+
+	return nil
+}
+
+func (r *TranscodeJobRepository) RecordAttempt(ctx context.Context, attempt types.TranscodeJobAttempt) error {
+	const op string = "TranscodeJobRepository.RecordAttempt"
+
+	const query string = `
+		INSERT INTO transcode_job_attempts
+		    (job_id,attempt,worker,error,started_at,finished_at)
+		VALUES (?,?,?,?,?,?)
+	`
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query,
+		attempt.JobID,
+		attempt.Attempt,
+		attempt.Worker,
+		attempt.Error,
+		attempt.StartedAt,
+		attempt.FinishedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (r *TranscodeJobRepository) GetAttempts(ctx context.Context, jobID int64) ([]types.TranscodeJobAttempt, error) {
+	const op string = "TranscodeJobRepository.GetAttempts"
+
+	const query string = `
+		SELECT id,job_id,attempt,worker,COALESCE(error,''),started_at,finished_at
+		FROM transcode_job_attempts
+		WHERE job_id = ?
+		ORDER BY attempt
+	`
+
+	rows, err := r.db.GetExecer().QueryContext(ctx, query, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var attempts []types.TranscodeJobAttempt
+	for rows.Next() {
+		var attempt types.TranscodeJobAttempt
+
+		if err = rows.Scan(
+			&attempt.ID,
+			&attempt.JobID,
+			&attempt.Attempt,
+			&attempt.Worker,
+			&attempt.Error,
+			&attempt.StartedAt,
+			&attempt.FinishedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, nil
+}
+
+func (r *TranscodeJobRepository) GetByID(ctx context.Context, id int64) (types.TranscodeJob, error) {
+	const op string = "TranscodeJobRepository.GetByID"
+
+	const query string = `
+		SELECT ` + transcodeJobColumns + `
+		FROM transcode_jobs
+		WHERE id = ?
+	`
+
+	job, err := scanTranscodeJob(r.db.GetExecer().QueryRowContext(ctx, query, id))
+	if err != nil {
+		return job, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return job, nil
+}
+
+// GetLatestByVideoID returns the latest job transcoding the source of a video, audio track jobs are ignored
+func (r *TranscodeJobRepository) GetLatestByVideoID(ctx context.Context, videoID int64) (types.TranscodeJob, error) {
+	const op string = "TranscodeJobRepository.GetLatestByVideoID"
+
+	const query string = `
+		SELECT ` + transcodeJobColumns + `
+		FROM transcode_jobs
+		WHERE video_id = ? AND kind = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`
+
+	job, err := scanTranscodeJob(r.db.GetExecer().QueryRowContext(ctx, query, videoID, enum.TranscodeJobKindVideo))
+	if err != nil {
+		return job, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return job, nil
+}
+
+func (r *TranscodeJobRepository) UpdateProgress(ctx context.Context, id int64, progress types.TranscodeProgress) error {
+	const op string = "TranscodeJobRepository.UpdateProgress"
+
+	const query string = `
+		UPDATE transcode_jobs
+		SET progress = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	encoded, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := r.db.GetExecer().ExecContext(ctx, query, string(encoded), time.Now(), id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RequeueExpired moves running jobs whose lease has expired back to pending.
+// Jobs that expired on their maxAttempts attempt are left to Claim, which fails them.
+func (r *TranscodeJobRepository) RequeueExpired(ctx context.Context, maxAttempts int) (int64, error) {
+	const op string = "TranscodeJobRepository.RequeueExpired"
+
+	const query string = `
+		UPDATE transcode_jobs
+		SET state = ?, lease_owner = NULL, lease_expires_at = NULL, updated_at = ?
+		WHERE state = ?
+		  AND lease_expires_at < ?
+		  AND attempts < ?
+	`
+
+	now := time.Now()
+
+	res, err := r.db.GetExecer().ExecContext(ctx, query,
+		enum.TranscodeJobStatePending,
+		now,
+		enum.TranscodeJobStateRunning,
+		now,
+		maxAttempts,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return affected, nil
+}
+
+func scanTranscodeJob(row *sql.Row) (types.TranscodeJob, error) {
+	var job types.TranscodeJob
+	var progress string
+
+	err := row.Scan(
+		&job.ID,
+		&job.VideoID,
+		&job.Kind,
+		&job.AudioTrackID,
+		&job.UploadPath,
+		&job.DstPath,
+		&job.ChunkHash,
+		&job.State,
+		&job.Attempts,
+		&job.LeaseOwner,
+		&job.LeaseExpiresAt,
+		&job.AvailableAt,
+		&job.LastError,
+		&progress,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		return job, err
+	}
+
+	if progress != "" {
+		if err := json.Unmarshal([]byte(progress), &job.Progress); err != nil {
+			return job, err
+		}
+	}
+
+	return job, nil
+}
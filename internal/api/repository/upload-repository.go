@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go-fitness/external/db"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/types"
+	"time"
+)
+
+type UploadRepository struct {
+	db db.SqlInterface
+}
+
+type UploadRepositoryInterface interface {
+	Create(context.Context, types.Upload) (types.Upload, error)
+	GetByUUID(context.Context, string) (types.Upload, error)
+	UpdateOffset(context.Context, int64, int64) error
+	UpdateStatus(context.Context, int64, enum.UploadStatus) error
+	Delete(context.Context, int64) error
+}
+
+func NewUploadRepository(
+	db db.SqlInterface,
+) *UploadRepository {
+	return &UploadRepository{
+		db: db,
+	}
+}
+
+func (r *UploadRepository) Create(ctx context.Context, upload types.Upload) (types.Upload, error) {
+	const op string = "UploadRepository.Create"
+
+	now := time.Now()
+
+	upload.UUID = uuid.New().String()
+	upload.CreatedAt = now
+	upload.UpdatedAt = now
+
+	const query string = `
+		INSERT INTO video_uploads
+		    (uuid,filename,name,description,size,upload_offset,status,node,created_at,updated_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?)
+	`
+
+	res, err := r.db.GetExecer().ExecContext(ctx, query,
+		upload.UUID,
+		upload.Filename,
+		upload.Name,
+		upload.Description,
+		upload.Size,
+		upload.Offset,
+		upload.Status,
+		upload.Node,
+		upload.CreatedAt,
+		upload.UpdatedAt,
+	)
+	if err != nil {
+		return upload, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return upload, fmt.Errorf("%s: %w", op, err)
+	}
+
+	upload.ID = id
+
+	return upload, nil
+}
+
+func (r *UploadRepository) GetByUUID(ctx context.Context, uuid string) (types.Upload, error) {
+	const op string = "UploadRepository.GetByUUID"
+
+	const query string = `
+		SELECT id,uuid,filename,name,description,size,upload_offset,status,node,created_at,updated_at
+		FROM video_uploads
+		WHERE uuid = ?
+	`
+
+	var upload types.Upload
+
+	if err := r.db.GetExecer().QueryRowContext(ctx, query, uuid).Scan(
+		&upload.ID,
+		&upload.UUID,
+		&upload.Filename,
+		&upload.Name,
+		&upload.Description,
+		&upload.Size,
+		&upload.Offset,
+		&upload.Status,
+		&upload.Node,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+	); err != nil {
+		return upload, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return upload, nil
+}
+
+func (r *UploadRepository) UpdateOffset(ctx context.Context, id int64, offset int64) error {
+	const op string = "UploadRepository.UpdateOffset"
+
+	const query string = `
+		UPDATE video_uploads
+		SET upload_offset = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, offset, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (r *UploadRepository) UpdateStatus(ctx context.Context, id int64, status enum.UploadStatus) error {
+	const op string = "UploadRepository.UpdateStatus"
+
+	const query string = `
+		UPDATE video_uploads
+		SET status = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, status, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (r *UploadRepository) Delete(ctx context.Context, id int64) error {
+	const op string = "UploadRepository.Delete"
+
+	const query string = "DELETE FROM video_uploads WHERE id = ?"
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"go-fitness/external/db"
+	"go-fitness/internal/api/types"
+	"time"
+)
+
+type VideoKeyRepository struct {
+	db db.SqlInterface
+}
+
+type VideoKeyRepositoryInterface interface {
+	Create(context.Context, types.VideoKey) error
+	GetByHashName(context.Context, string) ([]types.VideoKey, error)
+	GetByHashNameAndIndex(context.Context, string, int) (types.VideoKey, error)
+	DeleteByHashName(context.Context, string) error
+}
+
+func NewVideoKeyRepository(
+	db db.SqlInterface,
+) *VideoKeyRepository {
+	return &VideoKeyRepository{
+		db: db,
+	}
+}
+
+// Create inserts a key, keeping the existing one when the hash already has a key at that index
+func (r *VideoKeyRepository) Create(ctx context.Context, key types.VideoKey) error {
+	const op string = "VideoKeyRepository.Create"
+
+	const query string = `
+		INSERT IGNORE INTO video_keys 
+		    (hash_name,key_index,key_bytes,created_at) 
+		VALUES (?,?,?,?)
+	`
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, key.HashName, key.Index, key.Key, time.Now())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// GetByHashName returns the keys of a hash ordered by index
+func (r *VideoKeyRepository) GetByHashName(ctx context.Context, hashName string) ([]types.VideoKey, error) {
+	const op string = "VideoKeyRepository.GetByHashName"
+
+	const query string = `
+		SELECT id,hash_name,key_index,key_bytes,created_at 
+		FROM video_keys 
+		WHERE hash_name = ? 
+		ORDER BY key_index
+	`
+
+	rows, err := r.db.GetExecer().QueryContext(ctx, query, hashName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var keys []types.VideoKey
+	for rows.Next() {
+		var key types.VideoKey
+
+		if err = rows.Scan(
+			&key.ID,
+			&key.HashName,
+			&key.Index,
+			&key.Key,
+			&key.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// GetByHashNameAndIndex returns one key of a hash, or sql.ErrNoRows when there is none at that index
+func (r *VideoKeyRepository) GetByHashNameAndIndex(ctx context.Context, hashName string, index int) (types.VideoKey, error) {
+	const op string = "VideoKeyRepository.GetByHashNameAndIndex"
+
+	const query string = `
+		SELECT id,hash_name,key_index,key_bytes,created_at 
+		FROM video_keys 
+		WHERE hash_name = ? AND key_index = ?
+	`
+
+	var key types.VideoKey
+
+	err := r.db.GetExecer().QueryRowContext(ctx, query, hashName, index).Scan(
+		&key.ID,
+		&key.HashName,
+		&key.Index,
+		&key.Key,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return key, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+func (r *VideoKeyRepository) DeleteByHashName(ctx context.Context, hashName string) error {
+	const op string = "VideoKeyRepository.DeleteByHashName"
+
+	const query string = "DELETE FROM video_keys WHERE hash_name = ?"
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, hashName)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"github.com/google/uuid"
 	"go-fitness/external/db"
@@ -16,9 +17,17 @@ type VideoRepository struct {
 
 type VideoRepositoryInterface interface {
 	Create(context.Context, types.Video) (int64, error)
+	CreateWithTranscodeJob(context.Context, types.Video, types.VideoMediaInfo, types.TranscodeJob) (int64, error)
 	Update(context.Context, types.Video) error
 	UpdateStatus(context.Context, int64, enum.VideoStatus) error
+	UpdatePoster(context.Context, int64, string) error
 	GetByUUID(context.Context, string) (types.Video, error)
+	GetByID(context.Context, int64) (types.Video, error)
+	GetByUUIDAnyStatus(context.Context, string) (types.Video, error)
+	GetPlayableByUUID(context.Context, string) (types.Video, error)
+	GetLatestByHashName(context.Context, string) (types.Video, error)
+	CountByHashName(context.Context, string, int64) (int64, error)
+	UpdateStatusByHashName(context.Context, string, enum.VideoStatus, enum.VideoStatus) error
 	GetList(context.Context, map[string]interface{}) ([]types.Video, error)
 	Delete(context.Context, int64) error
 	SoftDelete(context.Context, int64) error
@@ -74,6 +83,48 @@ func (r *VideoRepository) UpdateVideoPosition(ctx context.Context, videoPosition
 func (r *VideoRepository) Create(ctx context.Context, video types.Video) (int64, error) {
 	const op string = "VideoRepository.Create"
 
+	id, err := insertVideo(ctx, r.db.GetExecer(), video)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// CreateWithTranscodeJob creates a video along with the media info of its source and its transcode job,
+// so a video is never left processing without a job to transcode it
+func (r *VideoRepository) CreateWithTranscodeJob(
+	ctx context.Context,
+	video types.Video,
+	info types.VideoMediaInfo,
+	job types.TranscodeJob,
+) (int64, error) {
+	const op string = "VideoRepository.CreateWithTranscodeJob"
+
+	var id int64
+
+	err := r.db.DoInTransaction(func(tx *sql.Tx) error {
+		var err error
+		if id, err = insertVideo(ctx, tx, video); err != nil {
+			return err
+		}
+
+		if err := saveMediaInfo(ctx, tx, info); err != nil {
+			return err
+		}
+
+		job.VideoID = id
+		_, err = insertTranscodeJob(ctx, tx, job)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func insertVideo(ctx context.Context, execer db.QueryExecer, video types.Video) (int64, error) {
 	now := time.Now()
 
 	video.UUID = uuid.New().String()
@@ -86,7 +137,7 @@ func (r *VideoRepository) Create(ctx context.Context, video types.Video) (int64,
 		VALUES (?,?,?,?,?,?,?,?)
 	`
 
-	inId, err := r.db.GetExecer().ExecContext(ctx, query,
+	inId, err := execer.ExecContext(ctx, query,
 		video.UUID,
 		video.Name,
 		video.HashName,
@@ -97,15 +148,10 @@ func (r *VideoRepository) Create(ctx context.Context, video types.Video) (int64,
 		video.UpdatedAt,
 	)
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
-	}
-
-	id, err := inId.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return 0, err
 	}
 
-	return id, nil
+	return inId.LastInsertId()
 }
 
 func (r *VideoRepository) Update(ctx context.Context, video types.Video) error {
@@ -113,8 +159,8 @@ func (r *VideoRepository) Update(ctx context.Context, video types.Video) error {
 
 	const query string = `
 		UPDATE videos 
-		SET name = $1, hash_name = $2, description = $3, status = $4, updated_at = $5 
-		WHERE id = $6
+		SET name = ?, hash_name = ?, description = ?, status = ?, updated_at = ? 
+		WHERE id = ?
 	`
 
 	_, err := r.db.GetExecer().ExecContext(ctx, query,
@@ -137,8 +183,8 @@ func (r *VideoRepository) UpdateStatus(ctx context.Context, id int64, status enu
 
 	const query string = `
 		UPDATE videos 
-		SET status = $1 
-		WHERE id = $2
+		SET status = ? 
+		WHERE id = ?
 	`
 
 	_, err := r.db.GetExecer().ExecContext(ctx, query, status, id)
@@ -149,11 +195,29 @@ func (r *VideoRepository) UpdateStatus(ctx context.Context, id int64, status enu
 	return nil
 }
 
+// UpdatePoster sets the base name of the custom poster of a video
+func (r *VideoRepository) UpdatePoster(ctx context.Context, id int64, poster string) error {
+	const op string = "VideoRepository.UpdatePoster"
+
+	const query string = `
+		UPDATE videos 
+		SET poster = ?, updated_at = ? 
+		WHERE id = ?
+	`
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, poster, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
 func (r *VideoRepository) GetByUUID(ctx context.Context, uuid string) (types.Video, error) {
 	const op string = "VideoRepository.GetByUUID"
 
 	const query string = `
-		SELECT id,uuid,name,hash_name,description,status,created_at,updated_at 
+		SELECT id,uuid,name,hash_name,description,status,poster,created_at,updated_at 
 		FROM videos 
 		WHERE uuid = ? 
 		  AND status = ?
@@ -168,6 +232,7 @@ func (r *VideoRepository) GetByUUID(ctx context.Context, uuid string) (types.Vid
 		&video.HashName,
 		&video.Description,
 		&video.Status,
+		&video.Poster,
 		&video.CreatedAt,
 		&video.UpdatedAt,
 	); err != nil {
@@ -177,11 +242,157 @@ func (r *VideoRepository) GetByUUID(ctx context.Context, uuid string) (types.Vid
 	return video, nil
 }
 
+func (r *VideoRepository) GetByID(ctx context.Context, id int64) (types.Video, error) {
+	const op string = "VideoRepository.GetByID"
+
+	const query string = `
+		SELECT id,uuid,name,hash_name,description,status,duration,poster,deleted_at,created_at,updated_at 
+		FROM videos 
+		WHERE id = ?
+	`
+
+	video, err := scanVideo(r.db.GetExecer().QueryRowContext(ctx, query, id))
+	if err != nil {
+		return video, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return video, nil
+}
+
+// GetByUUIDAnyStatus returns a video regardless of its status, for admin views
+func (r *VideoRepository) GetByUUIDAnyStatus(ctx context.Context, uuid string) (types.Video, error) {
+	const op string = "VideoRepository.GetByUUIDAnyStatus"
+
+	const query string = `
+		SELECT id,uuid,name,hash_name,description,status,duration,poster,deleted_at,created_at,updated_at 
+		FROM videos 
+		WHERE uuid = ?
+	`
+
+	video, err := scanVideo(r.db.GetExecer().QueryRowContext(ctx, query, uuid))
+	if err != nil {
+		return video, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return video, nil
+}
+
+// GetPlayableByUUID returns a processed video that is not soft deleted, the only videos whose media is served
+func (r *VideoRepository) GetPlayableByUUID(ctx context.Context, uuid string) (types.Video, error) {
+	const op string = "VideoRepository.GetPlayableByUUID"
+
+	const query string = `
+		SELECT id,uuid,name,hash_name,description,status,duration,poster,deleted_at,created_at,updated_at 
+		FROM videos 
+		WHERE uuid = ? 
+		  AND status = ? 
+		  AND deleted_at IS NULL
+	`
+
+	video, err := scanVideo(r.db.GetExecer().QueryRowContext(ctx, query, uuid, enum.VideoStatusProcessed))
+	if err != nil {
+		return video, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return video, nil
+}
+
+// GetLatestByHashName returns the newest live video that is processing or processed from the given source hash
+func (r *VideoRepository) GetLatestByHashName(ctx context.Context, hashName string) (types.Video, error) {
+	const op string = "VideoRepository.GetLatestByHashName"
+
+	const query string = `
+		SELECT id,uuid,name,hash_name,description,status,duration,poster,deleted_at,created_at,updated_at 
+		FROM videos 
+		WHERE hash_name = ? 
+		  AND status IN (?, ?) 
+		  AND deleted_at IS NULL 
+		ORDER BY id DESC 
+		LIMIT 1
+	`
+
+	video, err := scanVideo(r.db.GetExecer().QueryRowContext(ctx, query,
+		hashName,
+		enum.VideoStatusProcessing,
+		enum.VideoStatusProcessed,
+	))
+	if err != nil {
+		return video, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return video, nil
+}
+
+// CountByHashName returns how many other videos, soft deleted ones included, still use the renditions of a hash
+func (r *VideoRepository) CountByHashName(ctx context.Context, hashName string, excludeID int64) (int64, error) {
+	const op string = "VideoRepository.CountByHashName"
+
+	const query string = `
+		SELECT COUNT(*) 
+		FROM videos 
+		WHERE hash_name = ? 
+		  AND id <> ? 
+		  AND status <> ?
+	`
+
+	var count int64
+	if err := r.db.GetExecer().QueryRowContext(ctx, query, hashName, excludeID, enum.VideoStatusFailed).Scan(&count); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return count, nil
+}
+
+// UpdateStatusByHashName moves every video of a hash from one status to another,
+// so videos linked to a running transcode follow its outcome
+func (r *VideoRepository) UpdateStatusByHashName(
+	ctx context.Context,
+	hashName string,
+	from enum.VideoStatus,
+	to enum.VideoStatus,
+) error {
+	const op string = "VideoRepository.UpdateStatusByHashName"
+
+	const query string = `
+		UPDATE videos 
+		SET status = ? 
+		WHERE hash_name = ? 
+		  AND status = ?
+	`
+
+	_, err := r.db.GetExecer().ExecContext(ctx, query, to, hashName, from)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func scanVideo(row *sql.Row) (types.Video, error) {
+	var video types.Video
+
+	err := row.Scan(
+		&video.ID,
+		&video.UUID,
+		&video.Name,
+		&video.HashName,
+		&video.Description,
+		&video.Status,
+		&video.Duration,
+		&video.Poster,
+		&video.DeletedAt,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+	)
+
+	return video, err
+}
+
 func (r *VideoRepository) GetList(ctx context.Context, filters map[string]interface{}) ([]types.Video, error) {
 	const op string = "VideoRepository.GetList"
 
 	var query = `
-		SELECT id,uuid,name,hash_name,description,status,duration,created_at,updated_at 
+		SELECT id,uuid,name,hash_name,description,status,duration,poster,created_at,updated_at 
 		FROM videos 
 		WHERE deleted_at IS NULL
 	`
@@ -210,6 +421,7 @@ func (r *VideoRepository) GetList(ctx context.Context, filters map[string]interf
 			&video.Description,
 			&video.Status,
 			&video.Duration,
+			&video.Poster,
 			&video.CreatedAt,
 			&video.UpdatedAt,
 		); err != nil {
@@ -1 +1,110 @@
-package apiimport (	"github.com/go-chi/chi/v5"	"github.com/go-chi/chi/v5/middleware"	"go-fitness/internal/api/http/handler"	md "go-fitness/internal/api/http/middleware"	"log/slog"	"net/http"	"time")func NewRouter(	log *slog.Logger,	handlers *handler.Handlers,	md *md.Middleware,) *chi.Mux {	r := chi.NewRouter()	r.Use(middleware.RequestID)	r.Use(middleware.RealIP)	r.Use(middleware.Recoverer)	r.Use(middleware.URLFormat)	r.Use(middleware.Timeout(10 * time.Minute))	r.Use(cors())	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {		w.WriteHeader(http.StatusOK)		_, _ = w.Write([]byte("OK"))		return	})	r.Route("/api/v1/ms", func(r chi.Router) {		r.Route("/videos", func(r chi.Router) {			r.Group(func(r chi.Router) {				r.Use(md.AdminAuthMiddleware.New())				r.Delete("/{uuid}/full-delete", handlers.Video.DeleteVideo())				r.Post("/upload", handlers.Video.ProcessUpload())				r.Get("/{uuid}", handlers.Video.GetVideo())				r.Get("/{uuid}/{resolution}", handlers.Video.GetVideo())				//r.Put("/{uuid}/update", handlers.Video.UpdateVideoInfo())				//r.Get("/list", handlers.Video.GetVideos())				//r.Delete("/{uuid}/soft-delete", handlers.Video.SoftDeleteVideo())			})		})		r.Route("/client/videos", func(r chi.Router) {			r.Group(func(r chi.Router) {				r.Use(md.ClientAuthMiddleware.New())				r.Get("/{uuid}", handlers.Video.GetVideo())				r.Get("/{uuid}/{resolution}", handlers.Video.GetVideo())				/*r.Post("/{uuid}/set-time", handlers.Video.SaveVideoPosition())				r.Get("/{uuid}/get-time", handlers.Video.GetVideoPosition())*/				//r.Get("/list", handlers.Video.GetVideosWithPositions())			})		})	})	return r}func cors() func(next http.Handler) http.Handler {	return func(next http.Handler) http.Handler {		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {			if r.Method == "OPTIONS" {				w.Header().Set("Access-Control-Allow-Origin", "*")				w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")				w.WriteHeader(http.StatusOK)				return			}			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")			w.Header().Set("Access-Control-Allow-Origin", "*")			next.ServeHTTP(w, r)		})	}}
\ No newline at end of file
+package api
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go-fitness/internal/api/http/handler"
+	md "go-fitness/internal/api/http/middleware"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+func NewRouter(
+	log *slog.Logger,
+	handlers *handler.Handlers,
+	md *md.Middleware,
+) *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP)
+	r.Use(middleware.Recoverer)
+	r.Use(middleware.URLFormat)
+	r.Use(middleware.Timeout(10 * time.Minute))
+	r.Use(cors())
+
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+
+		_, _ = w.Write([]byte("OK"))
+
+		return
+	})
+
+	r.Route("/api/v1/ms", func(r chi.Router) {
+		r.Route("/videos", func(r chi.Router) {
+			r.Options("/uploads", handlers.Upload.Options())
+			r.Options("/uploads/{uuid}", handlers.Upload.Options())
+
+			r.Group(func(r chi.Router) {
+				r.Use(md.AdminAuthMiddleware.New())
+				r.Delete("/{uuid}/full-delete", handlers.Video.DeleteVideo())
+				r.Post("/upload", handlers.Video.ProcessUpload())
+				r.Post("/uploads", handlers.Upload.CreateUpload())
+				r.Head("/uploads/{uuid}", handlers.Upload.HeadUpload())
+				r.Patch("/uploads/{uuid}", handlers.Upload.PatchUpload())
+				r.Delete("/uploads/{uuid}", handlers.Upload.DeleteUpload())
+				r.Get("/workers", handlers.Video.GetTranscodeWorkers())
+
+				r.Get("/{uuid}", handlers.Video.GetVideo())
+				r.Get("/{uuid}/job", handlers.Video.GetVideoJob())
+				r.Get("/{uuid}/details", handlers.Video.GetVideoDetails())
+				r.Post("/{uuid}/job/cancel", handlers.Video.CancelVideoJob())
+				r.Get("/{uuid}/images/{file}", handlers.Video.GetVideoImage())
+				r.Post("/{uuid}/poster", handlers.Video.SetVideoPoster())
+				r.Get("/{uuid}/captions", handlers.Video.GetCaptions())
+				r.Post("/{uuid}/captions", handlers.Video.UploadCaption())
+				r.Delete("/{uuid}/captions/{language}", handlers.Video.DeleteCaption())
+				r.Get("/{uuid}/audio", handlers.Video.GetAudioTracks())
+				r.Post("/{uuid}/audio", handlers.Video.UploadAudioTrack())
+				r.Delete("/{uuid}/audio/{id}", handlers.Video.DeleteAudioTrack())
+				r.Get("/{uuid}/keys/{index}", handlers.Video.GetVideoKey())
+				r.Get("/{uuid}/{resolution}", handlers.Video.GetVideo())
+				//r.Put("/{uuid}/update", handlers.Video.UpdateVideoInfo())
+				//r.Get("/list", handlers.Video.GetVideos())
+				//r.Delete("/{uuid}/soft-delete", handlers.Video.SoftDeleteVideo())
+			})
+		})
+
+		r.Route("/client/videos", func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(md.ClientAuthMiddleware.New())
+				r.Get("/{uuid}", handlers.Video.GetVideo())
+				r.Get("/{uuid}/images/{file}", handlers.Video.GetVideoImage())
+
+				/*r.Post("/{uuid}/set-time", handlers.Video.SaveVideoPosition())
+				r.Get("/{uuid}/get-time", handlers.Video.GetVideoPosition())*/
+				//r.Get("/list", handlers.Video.GetVideosWithPositions())
+			})
+
+			// Players fetch media playlists, segments and keys with the signed URLs of the master playlist
+			r.Group(func(r chi.Router) {
+				r.Use(md.SignedURLMiddleware.New())
+				r.Get("/{uuid}/keys/{index}", handlers.Video.GetVideoKey())
+				r.Get("/{uuid}/{resolution}", handlers.Video.GetVideo())
+			})
+		})
+	})
+
+	return r
+}
+
+func cors() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Preflight requests are answered here, plain OPTIONS requests (tus discovery) reach the router
+			if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+				w.Header().Set("Access-Control-Allow-Methods", "GET,HEAD,POST,PUT,PATCH,DELETE,OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET,HEAD,POST,PUT,PATCH,DELETE,OPTIONS")
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Length, Upload-Offset, Upload-Status")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"go-fitness/external/config"
 	"go-fitness/external/logger/sl"
+	"go-fitness/internal/api/service"
 	"go.uber.org/fx"
 	"log"
 	"log/slog"
@@ -62,6 +63,32 @@ func RunServer(
 	})
 }
 
+func RunTranscodeWorkers(
+	lc fx.Lifecycle,
+	queue service.UploadAndTranscodeQueueInterface,
+) {
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			queue.WaitForTranscodeVideoSignals()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return queue.StopTranscodeWorkers(ctx)
+		},
+	})
+}
+
+func RunUploadService(
+	lc fx.Lifecycle,
+	uploads service.UploadServiceInterface,
+) {
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return uploads.WaitForFinishingUploads(ctx)
+		},
+	})
+}
+
 func generateAppToken() string {
 	b := make([]byte, 32)
 	_, err := rand.Read(b)
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// subtitleGroupID is the master playlist group every caption track belongs to
+	subtitleGroupID = "subs"
+	// subtitleTimestampMap aligns the cue times of every WebVTT segment with the video:
+	// the mpegts muxer of ffmpeg starts the first TS segment at 1.4s (126000 at 90kHz)
+	subtitleTimestampMap = "MPEGTS:126000,LOCAL:00:00:00.000"
+	// subtitleTimestampMapFMP4 does the same for fMP4 segments, whose timeline starts at zero
+	subtitleTimestampMapFMP4 = "MPEGTS:0,LOCAL:00:00:00.000"
+)
+
+var (
+	vttTimestampRe = regexp.MustCompile(`^(?:([0-9]+):)?([0-5][0-9]):([0-5][0-9])[.,]([0-9]{3})$`)
+	// srtFormattingRe matches the SRT formatting WebVTT does not support, font tags and ASS overrides
+	srtFormattingRe = regexp.MustCompile(`</?font[^>]*>|\{\\[^}]*\}`)
+	blankLinesRe    = regexp.MustCompile(`\n{2,}`)
+)
+
+// captionCue is a single cue of a caption file, with times in seconds
+type captionCue struct {
+	Start    float64
+	End      float64
+	Settings string
+	Text     string
+}
+
+// captionPlaylistFilename returns the subtitle media playlist of a language
+func captionPlaylistFilename(language string) string {
+	return fmt.Sprintf("subs_%s.m3u8", language)
+}
+
+// captionFilename returns the whole WebVTT track of a language
+func captionFilename(language string) string {
+	return fmt.Sprintf("subs_%s.vtt", language)
+}
+
+// captionSegmentFilename returns the n-th WebVTT segment of a language
+func captionSegmentFilename(language string, n int) string {
+	return fmt.Sprintf("subs_%s_%03d.vtt", language, n)
+}
+
+// parseCaptions parses a WebVTT or SRT caption file into its cues
+func parseCaptions(content []byte) ([]captionCue, error) {
+	if !utf8.Valid(content) {
+		return nil, fmt.Errorf("captions must be UTF-8 encoded")
+	}
+
+	text := strings.TrimPrefix(string(content), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.TrimSpace(text)
+
+	isVTT := strings.HasPrefix(text, "WEBVTT")
+
+	blocks := blankLinesRe.Split(text, -1)
+	if isVTT {
+		// The first block is the WEBVTT header
+		blocks = blocks[1:]
+	}
+
+	var cues []captionCue
+	for _, block := range blocks {
+		if isVTT && (strings.HasPrefix(block, "NOTE") ||
+			strings.HasPrefix(block, "STYLE") ||
+			strings.HasPrefix(block, "REGION")) {
+			continue
+		}
+
+		lines := strings.Split(block, "\n")
+
+		// Both formats may put an identifier, the SRT counter, before the timing line
+		timing := 0
+		if !strings.Contains(lines[0], "-->") {
+			timing = 1
+		}
+		if timing >= len(lines) || !strings.Contains(lines[timing], "-->") {
+			return nil, fmt.Errorf("cue %q has no timing line", lines[0])
+		}
+
+		cue, err := parseCueTiming(lines[timing])
+		if err != nil {
+			return nil, err
+		}
+
+		cue.Text = strings.Join(lines[timing+1:], "\n")
+		if !isVTT {
+			cue.Settings = ""
+			cue.Text = srtFormattingRe.ReplaceAllString(cue.Text, "")
+		}
+
+		if strings.TrimSpace(cue.Text) == "" {
+			continue
+		}
+
+		cues = append(cues, cue)
+	}
+
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("captions have no cues")
+	}
+
+	return cues, nil
+}
+
+// parseCueTiming parses a "start --> end [settings]" line, accepting both WebVTT and SRT timestamps
+func parseCueTiming(line string) (captionCue, error) {
+	start, rest, _ := strings.Cut(line, "-->")
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return captionCue{}, fmt.Errorf("invalid timing line %q", line)
+	}
+
+	startTime, err := parseCueTimestamp(strings.TrimSpace(start))
+	if err != nil {
+		return captionCue{}, fmt.Errorf("invalid timing line %q: %w", line, err)
+	}
+
+	endTime, err := parseCueTimestamp(fields[0])
+	if err != nil {
+		return captionCue{}, fmt.Errorf("invalid timing line %q: %w", line, err)
+	}
+
+	if endTime <= startTime {
+		return captionCue{}, fmt.Errorf("invalid timing line %q: the cue ends before it starts", line)
+	}
+
+	return captionCue{
+		Start:    startTime,
+		End:      endTime,
+		Settings: strings.Join(fields[1:], " "),
+	}, nil
+}
+
+func parseCueTimestamp(value string) (float64, error) {
+	match := vttTimestampRe.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+
+	var parts [4]int
+	for i, part := range match[1:] {
+		if part != "" {
+			parts[i], _ = strconv.Atoi(part)
+		}
+	}
+
+	return float64(parts[0]*3600+parts[1]*60+parts[2]) + float64(parts[3])/1000, nil
+}
+
+// writeCueBlock appends a cue to a WebVTT document
+func writeCueBlock(buffer *bytes.Buffer, cue captionCue) {
+	buffer.WriteString(fmt.Sprintf("\n%s --> %s", vttTimestamp(cue.Start), vttTimestamp(cue.End)))
+	if cue.Settings != "" {
+		buffer.WriteString(" " + cue.Settings)
+	}
+	buffer.WriteString("\n" + cue.Text + "\n")
+}
+
+// writeCaptionTrack writes the whole WebVTT track of a language, its WebVTT segments cut every
+// segmentDuration seconds and the subtitle media playlist referencing them. A cue spanning
+// a segment boundary is repeated in every segment it overlaps. A replaced track is swapped in file by file,
+// so players of the live video never read a missing or partial file.
+func writeCaptionTrack(
+	dir string,
+	language string,
+	cues []captionCue,
+	duration float64,
+	segmentDuration int,
+	timestampMap string,
+) error {
+	var track bytes.Buffer
+	track.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		writeCueBlock(&track, cue)
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, captionFilename(language)), track.Bytes()); err != nil {
+		return err
+	}
+
+	target := float64(segmentDuration)
+	count := max(int(math.Ceil(duration/target)), 1)
+
+	var playlist bytes.Buffer
+	playlist.WriteString("#EXTM3U\n")
+	playlist.WriteString("#EXT-X-VERSION:3\n")
+	playlist.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", segmentDuration))
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	playlist.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+
+	written := make(map[string]bool, count)
+	for i := 0; i < count; i++ {
+		start := float64(i) * target
+		end := start + target
+		if i == count-1 && duration > start {
+			end = duration
+		}
+
+		var segment bytes.Buffer
+		segment.WriteString("WEBVTT\n")
+		segment.WriteString("X-TIMESTAMP-MAP=" + timestampMap + "\n")
+
+		for _, cue := range cues {
+			if cue.Start < end && cue.End > start {
+				writeCueBlock(&segment, cue)
+			}
+		}
+
+		filename := captionSegmentFilename(language, i)
+		if err := writeFileAtomic(filepath.Join(dir, filename), segment.Bytes()); err != nil {
+			return err
+		}
+		written[filename] = true
+
+		playlist.WriteString(fmt.Sprintf("#EXTINF:%f,\n", end-start))
+		playlist.WriteString(filename + "\n")
+	}
+
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	if err := writeFileAtomic(filepath.Join(dir, captionPlaylistFilename(language)), playlist.Bytes()); err != nil {
+		return err
+	}
+
+	// Segments of a longer previous track go once the playlist no longer lists them
+	segments, _ := filepath.Glob(filepath.Join(dir, fmt.Sprintf("subs_%s_*.vtt", language)))
+	for _, path := range segments {
+		if !written[filepath.Base(path)] {
+			_ = os.Remove(path)
+		}
+	}
+
+	return nil
+}
+
+// removeCaptionTrack removes the track, segments and playlist of a language
+func removeCaptionTrack(dir string, language string) {
+	segments, _ := filepath.Glob(filepath.Join(dir, fmt.Sprintf("subs_%s_*.vtt", language)))
+	for _, path := range append(segments,
+		filepath.Join(dir, captionFilename(language)),
+		filepath.Join(dir, captionPlaylistFilename(language)),
+	) {
+		_ = os.Remove(path)
+	}
+}
+
+// captionFilePrefixes returns the name prefixes of the files of a caption track,
+// its playlist and whole track then its segments
+func captionFilePrefixes(language string) []string {
+	return []string{fmt.Sprintf("subs_%s.", language), fmt.Sprintf("subs_%s_", language)}
+}
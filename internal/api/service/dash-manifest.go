@@ -0,0 +1,316 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/types"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// dashManifestFilename is the DASH manifest written next to the master playlist of an fMP4 hash
+	dashManifestFilename = "manifest.mpd"
+	dashTimescale        = 1000
+	dashRoleScheme       = "urn:mpeg:dash:role:2011"
+)
+
+type dashMPD struct {
+	XMLName                   xml.Name   `xml:"MPD"`
+	Xmlns                     string     `xml:"xmlns,attr"`
+	Profiles                  string     `xml:"profiles,attr"`
+	Type                      string     `xml:"type,attr"`
+	MediaPresentationDuration string     `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string     `xml:"minBufferTime,attr"`
+	Period                    dashPeriod `xml:"Period"`
+}
+
+type dashPeriod struct {
+	ID             string              `xml:"id,attr"`
+	Start          string              `xml:"start,attr"`
+	AdaptationSets []dashAdaptationSet `xml:"AdaptationSet"`
+}
+
+type dashAdaptationSet struct {
+	ID               int                  `xml:"id,attr"`
+	ContentType      string               `xml:"contentType,attr"`
+	MimeType         string               `xml:"mimeType,attr"`
+	Lang             string               `xml:"lang,attr,omitempty"`
+	SegmentAlignment bool                 `xml:"segmentAlignment,attr,omitempty"`
+	Role             *dashDescriptor      `xml:"Role,omitempty"`
+	Label            string               `xml:"Label,omitempty"`
+	Representations  []dashRepresentation `xml:"Representation"`
+}
+
+type dashDescriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type dashRepresentation struct {
+	ID              string               `xml:"id,attr"`
+	Bandwidth       int64                `xml:"bandwidth,attr"`
+	Codecs          string               `xml:"codecs,attr,omitempty"`
+	Width           int                  `xml:"width,attr,omitempty"`
+	Height          int                  `xml:"height,attr,omitempty"`
+	FrameRate       string               `xml:"frameRate,attr,omitempty"`
+	BaseURL         string               `xml:"BaseURL,omitempty"`
+	SegmentTemplate *dashSegmentTemplate `xml:"SegmentTemplate,omitempty"`
+}
+
+type dashSegmentTemplate struct {
+	Timescale      int                 `xml:"timescale,attr"`
+	Initialization string              `xml:"initialization,attr"`
+	Media          string              `xml:"media,attr"`
+	StartNumber    int                 `xml:"startNumber,attr"`
+	Timeline       []dashTimelineEntry `xml:"SegmentTimeline>S"`
+}
+
+type dashTimelineEntry struct {
+	T *int64 `xml:"t,attr,omitempty"`
+	D int64  `xml:"d,attr"`
+	R int    `xml:"r,attr,omitempty"`
+}
+
+// segmentFormat is a method to get the segment format shared by the configured ladder,
+// MPEG-TS when its encoding profiles mix formats
+func (s *VideoService) segmentFormat() string {
+	if len(s.cfg.VideoService.Resolutions) == 0 {
+		return s.cfg.VideoService.SegmentFormatFor("")
+	}
+
+	for _, label := range s.cfg.VideoService.Resolutions {
+		if s.cfg.VideoService.SegmentFormatFor(label) != SegmentFormatFMP4 {
+			return SegmentFormatTS
+		}
+	}
+
+	return SegmentFormatFMP4
+}
+
+// segmentFormatOf is a method to get the segment format of the audio tracks and captions of an upload path,
+// falling back to the configured format while its renditions are still being transcoded.
+// They follow the MPEG-TS renditions of a ladder mixing formats.
+func (s *VideoService) segmentFormatOf(uploadPath string) string {
+	renditions, err := readRenditions(uploadPath)
+	if err != nil || len(renditions) == 0 {
+		return s.segmentFormat()
+	}
+
+	if dashPackaged(renditions) {
+		return SegmentFormatFMP4
+	}
+
+	return SegmentFormatTS
+}
+
+// dashPackaged reports whether every rendition of a hash is fMP4, so a DASH manifest can share its segments
+func dashPackaged(renditions []Rendition) bool {
+	if len(renditions) == 0 {
+		return false
+	}
+
+	for _, rendition := range renditions {
+		if rendition.SegmentFormat != SegmentFormatFMP4 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeDASHManifest writes the DASH manifest of a hash, referencing the fMP4 segments of the HLS media playlists
+// through segment timelines. Audio tracks packaged as MPEG-TS before DASH was enabled are left out.
+// The manifest has no base URL, its segments resolve next to it like those of a media playlist.
+func writeDASHManifest(
+	uploadPath string,
+	renditions []Rendition,
+	captions []types.VideoCaption,
+	audioTracks []types.VideoAudioTrack,
+) error {
+	video := dashAdaptationSet{
+		ContentType:      "video",
+		MimeType:         "video/mp4",
+		SegmentAlignment: true,
+	}
+
+	var duration float64
+
+	for _, rendition := range renditions {
+		template, length, err := dashSegmentTemplateOf(uploadPath, rendition.Label)
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			return fmt.Errorf("rendition %s has no initialization segment", rendition.Label)
+		}
+
+		duration = max(duration, length)
+
+		video.Representations = append(video.Representations, dashRepresentation{
+			ID:              rendition.Label,
+			Bandwidth:       rendition.Bandwidth,
+			Codecs:          rendition.Codecs,
+			Width:           rendition.Width,
+			Height:          rendition.Height,
+			FrameRate:       dashFrameRate(rendition.FrameRate),
+			SegmentTemplate: template,
+		})
+	}
+
+	adaptationSets := []dashAdaptationSet{video}
+
+	for _, track := range audioTracks {
+		name := audioTrackName(track.ID)
+
+		template, _, err := dashSegmentTemplateOf(uploadPath, name)
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			continue
+		}
+
+		adaptationSets = append(adaptationSets, dashAdaptationSet{
+			ContentType:      "audio",
+			MimeType:         "audio/mp4",
+			Lang:             track.Language,
+			SegmentAlignment: true,
+			Role:             &dashDescriptor{SchemeIDURI: dashRoleScheme, Value: dashAudioRole(track.Kind)},
+			Label:            track.Name,
+			Representations: []dashRepresentation{{
+				ID:              name,
+				Bandwidth:       track.Bandwidth,
+				Codecs:          track.Codecs,
+				SegmentTemplate: template,
+			}},
+		})
+	}
+
+	for _, caption := range captions {
+		filename := captionFilename(caption.Language)
+
+		stat, err := os.Stat(filepath.Join(uploadPath, filename))
+		if err != nil {
+			return err
+		}
+
+		var bandwidth int64 = 1
+		if duration > 0 {
+			bandwidth = max(int64(float64(stat.Size()*8)/duration), 1)
+		}
+
+		adaptationSets = append(adaptationSets, dashAdaptationSet{
+			ContentType: "text",
+			MimeType:    "text/vtt",
+			Lang:        caption.Language,
+			Role:        &dashDescriptor{SchemeIDURI: dashRoleScheme, Value: "subtitle"},
+			Label:       caption.Name,
+			Representations: []dashRepresentation{{
+				ID:        strings.TrimSuffix(filename, filepath.Ext(filename)),
+				Bandwidth: bandwidth,
+				BaseURL:   filename,
+			}},
+		})
+	}
+
+	for i := range adaptationSets {
+		adaptationSets[i].ID = i
+	}
+
+	mpd := dashMPD{
+		Xmlns:                     "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:                  "urn:mpeg:dash:profile:isoff-live:2011",
+		Type:                      "static",
+		MediaPresentationDuration: fmt.Sprintf("PT%.3fS", duration),
+		MinBufferTime:             "PT2S",
+		Period: dashPeriod{
+			ID:             "0",
+			Start:          "PT0S",
+			AdaptationSets: adaptationSets,
+		},
+	}
+
+	encoded, err := xml.MarshalIndent(mpd, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(uploadPath, dashManifestFilename), append([]byte(xml.Header), encoded...))
+}
+
+// stripDASHBaseURL removes the base URL of the hash directory that manifests written before were given,
+// which resolved their segments one directory too deep
+func stripDASHBaseURL(manifest []byte, videoUUID string) []byte {
+	return bytes.Replace(manifest, []byte("\n  <BaseURL>"+videoUUID+"/</BaseURL>"), nil, 1)
+}
+
+// dashSegmentTemplateOf builds the segment template of the fMP4 media playlist name.m3u8 along with its duration.
+// It returns a nil template when the playlist has MPEG-TS segments.
+func dashSegmentTemplateOf(uploadPath string, name string) (*dashSegmentTemplate, float64, error) {
+	playlist, err := readMediaPlaylist(filepath.Join(uploadPath, name+".m3u8"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if playlist.InitURI == "" {
+		return nil, 0, nil
+	}
+
+	template := &dashSegmentTemplate{
+		Timescale:      dashTimescale,
+		Initialization: playlist.InitURI,
+		Media:          strings.Replace(segmentPattern(name, SegmentFormatFMP4), "%03d", "$Number%03d$", 1),
+	}
+
+	// Segment boundaries are accumulated before rounding, so the timeline does not drift from the media
+	var start float64
+	for i, segment := range playlist.Segments {
+		from := int64(math.Round(start * dashTimescale))
+		start += segment.Duration
+		d := int64(math.Round(start*dashTimescale)) - from
+
+		if n := len(template.Timeline); n > 0 && template.Timeline[n-1].D == d {
+			template.Timeline[n-1].R++
+			continue
+		}
+
+		entry := dashTimelineEntry{D: d}
+		if i == 0 {
+			entry.T = &from
+		}
+		template.Timeline = append(template.Timeline, entry)
+	}
+
+	return template, start, nil
+}
+
+// dashAudioRole maps the kind of an audio track to a DASH role
+func dashAudioRole(kind enum.AudioTrackKind) string {
+	switch kind {
+	case enum.AudioTrackKindMain:
+		return "main"
+	case enum.AudioTrackKindDub:
+		return "dub"
+	case enum.AudioTrackKindCommentary:
+		return "commentary"
+	default:
+		return "alternate"
+	}
+}
+
+// dashFrameRate formats a frame rate as a DASH frame rate, an integer or an NTSC fraction
+func dashFrameRate(frameRate float64) string {
+	switch {
+	case frameRate <= 0:
+		return ""
+	case math.Abs(frameRate-math.Round(frameRate)) < 0.01:
+		return fmt.Sprintf("%d", int(math.Round(frameRate)))
+	default:
+		return fmt.Sprintf("%d/1001", int(math.Round(frameRate*1001)))
+	}
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"go-fitness/external/config"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// FFmpegTranscoder is the TranscoderInterface implementation running the ffmpeg binary
+type FFmpegTranscoder struct{}
+
+func NewFFmpegTranscoder() *FFmpegTranscoder {
+	return &FFmpegTranscoder{}
+}
+
+// TranscodeHLS encodes req.InputPath into a single HLS rendition
+func (t *FFmpegTranscoder) TranscodeHLS(ctx context.Context, req TranscodeRequest, onProgress func(outTime float64)) error {
+	args := []string{"-y", "-nostats", "-progress", "pipe:1", "-i", req.InputPath,
+		"-vf", req.Rendition.ScaleFilter(),
+		"-metadata:s:v", "rotate=0",
+	}
+	args = append(args, videoEncodingArgs(req.Profile)...)
+	if req.NoAudio {
+		args = append(args, "-an")
+	} else {
+		args = append(args, audioEncodingArgs(req.Profile)...)
+	}
+	args = append(args, hlsOutputArgs(req)...)
+
+	return runTranscode(ctx, args, onProgress)
+}
+
+// TranscodeAudioHLS encodes the first audio stream of req.InputPath into a single audio only HLS rendition
+func (t *FFmpegTranscoder) TranscodeAudioHLS(ctx context.Context, req TranscodeRequest, onProgress func(outTime float64)) error {
+	args := []string{"-y", "-nostats", "-progress", "pipe:1", "-i", req.InputPath,
+		"-map", "0:a:0",
+		"-vn",
+	}
+	args = append(args, audioEncodingArgs(req.Profile)...)
+	args = append(args, hlsOutputArgs(req)...)
+
+	return runTranscode(ctx, args, onProgress)
+}
+
+// hlsOutputArgs returns the ffmpeg HLS muxer options writing the playlist and segments of req
+func hlsOutputArgs(req TranscodeRequest) []string {
+	args := []string{
+		"-start_number", "0",
+		"-hls_time", strconv.Itoa(req.HLSTime),
+		"-hls_list_size", "0",
+		"-f", "hls",
+	}
+
+	if req.SegmentFormat == SegmentFormatFMP4 {
+		args = append(args,
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", req.InitFilename,
+		)
+	}
+
+	return append(args,
+		"-hls_segment_filename",
+		req.SegmentPattern,
+		req.PlaylistPath,
+	)
+}
+
+func runTranscode(ctx context.Context, args []string, onProgress func(outTime float64)) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	configureProcessGroup(cmd)
+
+	if err := runFFmpeg(cmd, onProgress); err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// bestFrameBatch is the number of frames the thumbnail filter compares to pick the poster
+const bestFrameBatch = 300
+
+// ExtractPoster writes the frame at req.Offset, or the most representative frame of the
+// bestFrameBatch frames following it, as a single image. The input may be a still image too.
+func (t *FFmpegTranscoder) ExtractPoster(ctx context.Context, req FrameRequest) error {
+	filter := fmt.Sprintf("scale=%d:%d,setsar=1", req.Width, req.Height)
+	if req.BestFrame {
+		filter = fmt.Sprintf("thumbnail=%d,%s", bestFrameBatch, filter)
+	}
+
+	args := []string{
+		"-ss", strconv.FormatFloat(req.Offset, 'f', 3, 64),
+		"-i", req.InputPath,
+		"-vf", filter,
+		"-frames:v", "1",
+	}
+	args = append(args, imageQualityArgs(req.OutputPath)...)
+	args = append(args, "-update", "1", req.OutputPath)
+
+	return t.extractImages(ctx, args)
+}
+
+// imageQualityArgs returns the quality option matching the encoder picked from the image extension,
+// libwebp reads -q:v as a 0-100 quality while mjpeg reads it as a 2-31 quantizer
+func imageQualityArgs(outputPath string) []string {
+	if strings.HasSuffix(outputPath, ".webp") {
+		return []string{"-quality", "82"}
+	}
+	return []string{"-q:v", "2"}
+}
+
+// ExtractFrames writes a frame every req.Interval seconds as numbered images,
+// tiled Columns x Rows per image when both are set
+func (t *FFmpegTranscoder) ExtractFrames(ctx context.Context, req FrameRequest) error {
+	filter := fmt.Sprintf("fps=1/%s,scale=%d:%d,setsar=1",
+		strconv.FormatFloat(req.Interval, 'f', -1, 64), req.Width, req.Height)
+	if req.Columns > 0 && req.Rows > 0 {
+		filter = fmt.Sprintf("%s,tile=%dx%d", filter, req.Columns, req.Rows)
+	}
+
+	return t.extractImages(ctx, []string{
+		"-i", req.InputPath,
+		"-vf", filter,
+		"-q:v", "3",
+		"-start_number", "0",
+		req.OutputPath,
+	})
+}
+
+func (t *FFmpegTranscoder) extractImages(ctx context.Context, args []string) error {
+	args = append([]string{"-y", "-nostats", "-progress", "pipe:1"}, args...)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	configureProcessGroup(cmd)
+
+	if err := runFFmpeg(cmd, nil); err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// runFFmpeg runs an ffmpeg command started with "-progress pipe:1" and reports
+// the encoded position in seconds every time ffmpeg emits a progress block
+func runFFmpeg(cmd *exec.Cmd, onProgress func(outTime float64)) error {
+	stderr := &tailBuffer{max: stderrTailSize}
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found || key != "out_time_ms" {
+			continue
+		}
+
+		// Despite its name out_time_ms is expressed in microseconds
+		outTime, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if onProgress != nil {
+			onProgress(float64(outTime) / 1e6)
+		}
+	}
+
+	// ffmpeg blocks once the pipe is full, so whatever the scanner left unread is drained before waiting
+	scanErr := scanner.Err()
+	_, _ = io.Copy(io.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%w: %s", err, stderrTail(stderr.String()))
+	}
+
+	if scanErr != nil {
+		return fmt.Errorf("failed to read ffmpeg progress: %w", scanErr)
+	}
+
+	return nil
+}
+
+// stderrTailSize is how much of the stderr of ffmpeg is kept, the tail holding the actual error
+const stderrTailSize = 16 << 10
+
+// tailBuffer is an io.Writer keeping only the last max bytes written to it
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.max:]...)
+	}
+
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	return string(b.buf)
+}
+
+// videoEncodingArgs returns the ffmpeg video output options of an encoding profile.
+// Keyframes are forced every KeyframeInterval seconds with scene cut detection disabled,
+// so every HLS segment starts with a keyframe (the interval divides hls_time).
+func videoEncodingArgs(profile config.EncodingProfile) []string {
+	args := []string{"-c:v", profile.VideoCodec}
+
+	if profile.Profile != "" {
+		args = append(args, "-profile:v", profile.Profile)
+	}
+	if profile.Level != "" {
+		args = append(args, "-level", profile.Level)
+	}
+	if profile.Preset != "" {
+		args = append(args, "-preset", profile.Preset)
+	}
+
+	if profile.CRF > 0 {
+		args = append(args, "-crf", strconv.Itoa(profile.CRF))
+	} else {
+		args = append(args, "-b:v", profile.Bitrate)
+	}
+	if profile.MaxRate != "" {
+		args = append(args, "-maxrate", profile.MaxRate, "-bufsize", profile.BufSize)
+	}
+
+	args = append(args,
+		"-pix_fmt", "yuv420p",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", profile.KeyframeInterval),
+		"-sc_threshold", "0",
+	)
+
+	if profile.VideoCodec == "libx265" {
+		args = append(args, "-tag:v", "hvc1", "-x265-params", "scenecut=0")
+	}
+
+	return args
+}
+
+// audioEncodingArgs returns the ffmpeg audio output options of an encoding profile
+func audioEncodingArgs(profile config.EncodingProfile) []string {
+	args := []string{"-c:a", profile.AudioCodec}
+	if profile.AudioBitrate != "" {
+		args = append(args, "-b:a", profile.AudioBitrate)
+	}
+
+	return args
+}
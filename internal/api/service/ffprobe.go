@@ -0,0 +1,173 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-fitness/internal/api/types"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type ffprobeOutput struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  ffprobeFormat   `json:"format"`
+}
+
+type ffprobeStream struct {
+	CodecType         string            `json:"codec_type"`
+	CodecName         string            `json:"codec_name"`
+	Profile           string            `json:"profile"`
+	Level             int               `json:"level"`
+	Width             int               `json:"width"`
+	Height            int               `json:"height"`
+	SampleAspectRatio string            `json:"sample_aspect_ratio"`
+	AvgFrameRate      string            `json:"avg_frame_rate"`
+	RFrameRate        string            `json:"r_frame_rate"`
+	Channels          int               `json:"channels"`
+	Tags              map[string]string `json:"tags"`
+	SideDataList      []struct {
+		Rotation float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+type ffprobeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	BitRate    string `json:"bit_rate"`
+	Size       string `json:"size"`
+}
+
+// FFprobeProber is the ProberInterface implementation running the ffprobe binary
+type FFprobeProber struct{}
+
+func NewFFprobeProber() *FFprobeProber {
+	return &FFprobeProber{}
+}
+
+// Probe runs a full ffprobe JSON probe and normalizes the first video and audio streams
+func (p *FFprobeProber) Probe(ctx context.Context, filePath string) (types.MediaInfo, error) {
+	cmd := exec.CommandContext(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		filePath,
+	)
+
+	configureProcessGroup(cmd)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return types.MediaInfo{}, context.Cause(ctx)
+		}
+
+		// A non-zero exit is ffprobe rejecting the file, anything else is failing to run it at all
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return types.MediaInfo{}, fmt.Errorf("%w: %v: %s", ErrUnreadableMedia, err, stderrTail(stderr.String()))
+		}
+		return types.MediaInfo{}, fmt.Errorf("error running ffprobe: %w", err)
+	}
+
+	var out ffprobeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return types.MediaInfo{}, fmt.Errorf("error decoding ffprobe output: %w", err)
+	}
+
+	return out.mediaInfo(), nil
+}
+
+func (o ffprobeOutput) mediaInfo() types.MediaInfo {
+	info := types.MediaInfo{
+		Container: o.Format.FormatName,
+	}
+
+	info.Duration, _ = strconv.ParseFloat(o.Format.Duration, 64)
+	info.Bitrate, _ = strconv.ParseInt(o.Format.BitRate, 10, 64)
+	info.Size, _ = strconv.ParseInt(o.Format.Size, 10, 64)
+
+	for _, stream := range o.Streams {
+		switch stream.CodecType {
+		case "video":
+			if info.VideoCodec != "" || stream.Tags["mimetype"] != "" {
+				continue
+			}
+			info.VideoCodec = stream.CodecName
+			info.VideoProfile = stream.Profile
+			info.VideoLevel = stream.Level
+			info.Width = stream.Width
+			info.Height = stream.Height
+			info.SampleAspectRatio = stream.SampleAspectRatio
+			info.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if info.FrameRate == 0 {
+				info.FrameRate = parseFrameRate(stream.RFrameRate)
+			}
+			info.Rotation = stream.rotation()
+		case "audio":
+			if info.AudioCodec != "" {
+				continue
+			}
+			info.AudioCodec = stream.CodecName
+			info.AudioProfile = stream.Profile
+			info.AudioChannels = stream.Channels
+		}
+	}
+
+	return info
+}
+
+// rotation returns the clockwise display rotation (0, 90, 180 or 270) from the
+// display matrix side data or, for older files, the "rotate" tag
+func (s ffprobeStream) rotation() int {
+	var degrees float64
+
+	if rotate, ok := s.Tags["rotate"]; ok {
+		degrees, _ = strconv.ParseFloat(rotate, 64)
+	}
+
+	for _, sideData := range s.SideDataList {
+		if sideData.Rotation != 0 {
+			// The display matrix rotation is counter-clockwise
+			degrees = -sideData.Rotation
+		}
+	}
+
+	normalized := int(math.Round(degrees/90)) * 90 % 360
+	if normalized < 0 {
+		normalized += 360
+	}
+
+	return normalized
+}
+
+// parseFrameRate parses ffprobe rational frame rates such as "30000/1001"
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	if !found {
+		value, _ := strconv.ParseFloat(rate, 64)
+		return value
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+
+	return n / d
+}
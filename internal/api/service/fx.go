@@ -11,14 +11,27 @@ func NewService() fx.Option {
 	return fx.Module(
 		"service",
 		fx.Provide(
-			NewVideoTranscodeTask,
-
 			fx.Annotate(
 				NewVideoService,
 				fx.As(new(VideoServiceInterface)),
 				fx.As(new(UploadAndTranscodeQueueInterface)),
 			),
 
+			fx.Annotate(
+				NewUploadService,
+				fx.As(new(UploadServiceInterface)),
+			),
+
+			fx.Annotate(
+				NewFFmpegTranscoder,
+				fx.As(new(TranscoderInterface)),
+			),
+
+			fx.Annotate(
+				NewFFprobeProber,
+				fx.As(new(ProberInterface)),
+			),
+
 			fx.Annotate(
 				NewNotificationService,
 				fx.As(new(NotificationServiceInterface)),
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go-fitness/external/logger/sl"
+	"go-fitness/internal/api/types"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// videoKeyURIPattern is the key endpoint relative to the media playlists, by key index
+const videoKeyURIPattern = "keys/%d"
+
+var ErrVideoKeyNotFound = errors.New("video key not found")
+
+// ProcessGetVideoKey is a method to process the AES-128 key at the given index of a video by UUID.
+// Keys are shared by the videos of a hash and only handed out for a video whose media is served.
+func (s *VideoService) ProcessGetVideoKey(ctx context.Context, uuid string, index int) ([]byte, error) {
+	const op string = "VideoService.ProcessGetVideoKey"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+		sl.Int("index", index),
+	)
+
+	video, err := s.getPlayableVideo(ctx, uuid)
+	if err != nil {
+		log.Error("failed to get video by uuid", sl.Err(err))
+		if errors.Is(err, ErrVideoMediaNotFound) {
+			return nil, ErrVideoKeyNotFound
+		}
+		return nil, errors.New("failed to get video by uuid")
+	}
+
+	key, err := s.videoKeyRepo.GetByHashNameAndIndex(ctx, video.HashName, index)
+	if err != nil {
+		log.Error("failed to get video key", sl.Err(err))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrVideoKeyNotFound
+		}
+		return nil, errors.New("failed to get video key")
+	}
+
+	return key.Key, nil
+}
+
+// encryptPlaylists is a method to encrypt the segments of the media playlists name.m3u8 of an upload path
+// with the AES-128 keys of its hash and reference the keys from the playlists.
+// Nothing is done when encryption is disabled.
+func (s *VideoService) encryptPlaylists(ctx context.Context, uploadPath string, hashName string, names []string) error {
+	if !s.cfg.VideoService.Encryption.Enabled {
+		return nil
+	}
+
+	rotation := s.cfg.VideoService.Encryption.KeyRotation
+
+	count := 1
+	for _, name := range names {
+		playlist, err := readMediaPlaylist(filepath.Join(uploadPath, name+".m3u8"))
+		if err != nil {
+			return err
+		}
+
+		count = max(count, keyIndex(len(playlist.Segments)-1, rotation)+1)
+	}
+
+	keys, err := s.ensureVideoKeys(ctx, hashName, count)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := encryptMediaPlaylist(filepath.Join(uploadPath, name+".m3u8"), keys, rotation); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// ensureVideoKeys is a method to get the first count keys of a hash, generating the missing ones.
+// Existing keys are kept, so a retried job or a later audio track encrypts with the keys players may already hold.
+func (s *VideoService) ensureVideoKeys(ctx context.Context, hashName string, count int) ([][]byte, error) {
+	existing, err := s.videoKeyRepo.GetByHashName(ctx, hashName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get video keys: %w", err)
+	}
+
+	stored := make(map[int]bool, len(existing))
+	for _, key := range existing {
+		stored[key.Index] = true
+	}
+
+	for i := 0; i < count; i++ {
+		if stored[i] {
+			continue
+		}
+
+		key := make([]byte, aes.BlockSize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate video key: %w", err)
+		}
+
+		if err := s.videoKeyRepo.Create(ctx, types.VideoKey{HashName: hashName, Index: i, Key: key}); err != nil {
+			return nil, fmt.Errorf("failed to create video key: %w", err)
+		}
+	}
+
+	// Keys are read back, a concurrent job of the hash may have stored one of them first
+	existing, err = s.videoKeyRepo.GetByHashName(ctx, hashName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get video keys: %w", err)
+	}
+
+	keys := make([][]byte, count)
+	for _, key := range existing {
+		if key.Index < count {
+			keys[key.Index] = key.Key
+		}
+	}
+
+	for i, key := range keys {
+		if len(key) != aes.BlockSize {
+			return nil, fmt.Errorf("video key %d is missing", i)
+		}
+	}
+
+	return keys, nil
+}
+
+// keyIndex returns the key encrypting a segment, a single key being used when rotation is zero
+func keyIndex(segment int, rotation int) int {
+	if rotation <= 0 || segment < 0 {
+		return 0
+	}
+
+	return segment / rotation
+}
+
+// encryptMediaPlaylist encrypts the segments of a media playlist in place with AES-128 and inserts an EXT-X-KEY
+// before the first segment of every key. The IV of a segment is its media sequence number, as players
+// assume when EXT-X-KEY has no IV. The fMP4 initialization segment is left in the clear.
+// A playlist already referencing a key is left untouched.
+func encryptMediaPlaylist(playlistPath string, keys [][]byte, rotation int) error {
+	content, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Contains(content, []byte("#EXT-X-KEY")) {
+		return nil
+	}
+
+	dir := filepath.Dir(playlistPath)
+
+	var out bytes.Buffer
+	var sequence int64
+	segment := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, err = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid media sequence %q: %w", line, err)
+			}
+		case strings.HasPrefix(line, "#EXTINF:"):
+			if segment == 0 || rotation > 0 && segment%rotation == 0 {
+				out.WriteString(fmt.Sprintf("#EXT-X-KEY:METHOD=AES-128,URI=\"%s\"\n",
+					fmt.Sprintf(videoKeyURIPattern, keyIndex(segment, rotation)),
+				))
+			}
+		case line != "" && !strings.HasPrefix(line, "#"):
+			index := keyIndex(segment, rotation)
+			if index >= len(keys) {
+				return fmt.Errorf("no key for segment %d", segment)
+			}
+
+			iv := make([]byte, aes.BlockSize)
+			binary.BigEndian.PutUint64(iv[8:], uint64(sequence+int64(segment)))
+
+			if err := encryptSegment(filepath.Join(dir, line), keys[index], iv); err != nil {
+				return fmt.Errorf("failed to encrypt segment %s: %w", line, err)
+			}
+
+			segment++
+		}
+
+		out.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(playlistPath, out.Bytes())
+}
+
+// encryptSegment replaces a segment with its AES-128-CBC encryption, padded with PKCS#7
+func encryptSegment(path string, key []byte, iv []byte) error {
+	plain, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	encrypted := append(plain, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	// The segment is swapped in with a rename, so it is never served half encrypted
+	return writeFileAtomic(path, encrypted)
+}
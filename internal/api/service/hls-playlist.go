@@ -0,0 +1,282 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"go-fitness/internal/api/types"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// hlsSegment is a media segment referenced by an HLS media playlist
+type hlsSegment struct {
+	URI      string
+	Duration float64
+}
+
+// hlsMediaPlaylist is what an HLS media playlist references: its segments,
+// preceded by the initialization segment of EXT-X-MAP for fMP4
+type hlsMediaPlaylist struct {
+	InitURI  string
+	Segments []hlsSegment
+}
+
+// segmentPattern returns the printf pattern of the segments of a media playlist in the given format
+func segmentPattern(name string, format string) string {
+	if format == SegmentFormatFMP4 {
+		return name + "_%03d.m4s"
+	}
+
+	return name + "_%03d.ts"
+}
+
+const (
+	hlsPlaylistContentType  = "application/x-mpegURL"
+	dashManifestContentType = "application/dash+xml"
+)
+
+// segmentContentTypes maps the extension of every file served next to the media playlists to its content type
+var segmentContentTypes = map[string]string{
+	".ts":  "video/mp2t",
+	".m4s": "video/iso.segment",
+	".mp4": "video/mp4",
+	".vtt": "text/vtt; charset=utf-8",
+}
+
+// initSegmentFilename returns the fMP4 initialization segment of a media playlist
+func initSegmentFilename(name string) string {
+	return name + "_init.mp4"
+}
+
+// readMediaPlaylist parses the initialization segment and the segments of an HLS media playlist
+func readMediaPlaylist(path string) (hlsMediaPlaylist, error) {
+	var playlist hlsMediaPlaylist
+
+	file, err := os.Open(path)
+	if err != nil {
+		return playlist, err
+	}
+	defer file.Close()
+
+	var duration float64
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			if duration, err = strconv.ParseFloat(value, 64); err != nil {
+				return playlist, fmt.Errorf("invalid segment duration %q: %w", line, err)
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			_, uri, found := strings.Cut(line, `URI="`)
+			if uri, _, found = strings.Cut(uri, `"`); !found || uri == "" {
+				return playlist, fmt.Errorf("invalid map %q", line)
+			}
+			playlist.InitURI = uri
+		case strings.HasPrefix(line, "#"):
+			continue
+		default:
+			playlist.Segments = append(playlist.Segments, hlsSegment{URI: line, Duration: duration})
+			duration = 0
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return playlist, err
+	}
+
+	return playlist, nil
+}
+
+// probeFirstSegment is a method to probe the first segment of a media playlist.
+// An fMP4 segment carries no codec configuration, so it is probed joined to its initialization segment.
+func (s *VideoService) probeFirstSegment(ctx context.Context, playlistPath string) (types.MediaInfo, error) {
+	playlist, err := readMediaPlaylist(playlistPath)
+	if err != nil {
+		return types.MediaInfo{}, err
+	}
+
+	if len(playlist.Segments) == 0 {
+		return types.MediaInfo{}, fmt.Errorf("playlist %s has no segments", playlistPath)
+	}
+
+	dir := filepath.Dir(playlistPath)
+	segmentPath := filepath.Join(dir, playlist.Segments[0].URI)
+
+	if playlist.InitURI == "" {
+		return s.prober.Probe(ctx, segmentPath)
+	}
+
+	joined, err := os.CreateTemp(dir, ".probe-*.mp4")
+	if err != nil {
+		return types.MediaInfo{}, err
+	}
+	defer os.Remove(joined.Name())
+
+	for _, path := range []string{filepath.Join(dir, playlist.InitURI), segmentPath} {
+		if err := appendFile(joined, path); err != nil {
+			joined.Close()
+			return types.MediaInfo{}, err
+		}
+	}
+
+	if err := joined.Close(); err != nil {
+		return types.MediaInfo{}, err
+	}
+
+	return s.prober.Probe(ctx, joined.Name())
+}
+
+func appendFile(dst io.Writer, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// hlsPlaylistStats is what a media playlist and its segments weigh on disk
+type hlsPlaylistStats struct {
+	// PeakBitrate and AverageBitrate are in bits per second
+	PeakBitrate    int64
+	AverageBitrate int64
+	Size           int64
+	SegmentCount   int
+	Duration       float64
+}
+
+// measureMediaPlaylist computes the peak and average bitrate of a media playlist
+// from the size and duration of its segments, along with its total size on disk
+func measureMediaPlaylist(playlistPath string) (hlsPlaylistStats, error) {
+	var stats hlsPlaylistStats
+
+	playlist, err := readMediaPlaylist(playlistPath)
+	if err != nil {
+		return stats, err
+	}
+
+	segments := playlist.Segments
+	if len(segments) == 0 {
+		return stats, fmt.Errorf("playlist %s has no segments", playlistPath)
+	}
+
+	playlistStat, err := os.Stat(playlistPath)
+	if err != nil {
+		return stats, err
+	}
+	stats.Size = playlistStat.Size()
+
+	if playlist.InitURI != "" {
+		initStat, err := os.Stat(filepath.Join(filepath.Dir(playlistPath), playlist.InitURI))
+		if err != nil {
+			return stats, err
+		}
+		stats.Size += initStat.Size()
+	}
+
+	var peak float64
+	for _, segment := range segments {
+		stat, err := os.Stat(filepath.Join(filepath.Dir(playlistPath), segment.URI))
+		if err != nil {
+			return stats, err
+		}
+
+		stats.Size += stat.Size()
+		stats.Duration += segment.Duration
+
+		if segment.Duration > 0 {
+			peak = max(peak, float64(stat.Size()*8)/segment.Duration)
+		}
+	}
+
+	if stats.Duration <= 0 {
+		return stats, fmt.Errorf("playlist %s has no duration", playlistPath)
+	}
+
+	stats.PeakBitrate = int64(peak)
+	stats.AverageBitrate = int64(float64((stats.Size-playlistStat.Size())*8) / stats.Duration)
+	stats.SegmentCount = len(segments)
+
+	return stats, nil
+}
+
+// hlsCodecs returns the RFC 6381 codecs string of the probed streams
+func hlsCodecs(info types.MediaInfo) string {
+	var codecs []string
+
+	if video := videoCodecTag(info); video != "" {
+		codecs = append(codecs, video)
+	}
+
+	if audio := audioCodecTag(info); audio != "" {
+		codecs = append(codecs, audio)
+	}
+
+	return strings.Join(codecs, ",")
+}
+
+func videoCodecTag(info types.MediaInfo) string {
+	switch info.VideoCodec {
+	case "h264":
+		var profile string
+		switch info.VideoProfile {
+		case "Constrained Baseline":
+			profile = "42E0"
+		case "Baseline":
+			profile = "4200"
+		case "Main":
+			profile = "4D40"
+		case "High 10":
+			profile = "6E00"
+		case "High 4:2:2":
+			profile = "7A00"
+		default:
+			profile = "6400"
+		}
+
+		return fmt.Sprintf("avc1.%s%02X", profile, info.VideoLevel)
+	case "hevc":
+		profile := "1.6"
+		if info.VideoProfile == "Main 10" {
+			profile = "2.4"
+		}
+
+		return fmt.Sprintf("hvc1.%s.L%d.B0", profile, info.VideoLevel)
+	}
+
+	return ""
+}
+
+func audioCodecTag(info types.MediaInfo) string {
+	switch info.AudioCodec {
+	case "aac":
+		switch info.AudioProfile {
+		case "HE-AAC":
+			return "mp4a.40.5"
+		case "HE-AACv2":
+			return "mp4a.40.29"
+		default:
+			return "mp4a.40.2"
+		}
+	case "mp3":
+		return "mp4a.40.34"
+	case "ac3":
+		return "ac-3"
+	case "eac3":
+		return "ec-3"
+	}
+
+	return ""
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MediaFile is a playlist or a segment streamed to players with http.ServeContent
+type MediaFile struct {
+	Content     io.ReadSeekCloser
+	ContentType string
+	// ModTime is zero when Content is not the file on disk as is, such as a signed playlist
+	ModTime time.Time
+	// ETag is a strong validator of Content
+	ETag string
+	// Location is set instead of Content when the file is read from a presigned URL of the storage
+	Location string
+}
+
+// newPlaylistFile wraps a playlist served from memory. It is validated by its digest alone,
+// since signing makes the content differ from the file on disk.
+func newPlaylistFile(content []byte, contentType string) MediaFile {
+	sum := sha256.Sum256(content)
+
+	return MediaFile{
+		Content:     nopSeekCloser{bytes.NewReader(content)},
+		ContentType: contentType,
+		ETag:        fmt.Sprintf(`"%x"`, sum[:16]),
+	}
+}
+
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+// writeFileAtomic writes a file served to players through a temporary file swapped in with a rename,
+// so a concurrent request reads either the previous content or the new one, never a partial file
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".write-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"fmt"
+	"go-fitness/internal/api/types"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MediaViolation is a single reason for rejecting an uploaded source
+type MediaViolation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// MediaValidationError is returned by ProcessUpload when the source breaks the media policy
+type MediaValidationError struct {
+	Violations []MediaViolation `json:"violations"`
+}
+
+func (e *MediaValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, fmt.Sprintf("%s: %s", violation.Field, violation.Message))
+	}
+
+	return "media validation failed: " + strings.Join(messages, "; ")
+}
+
+// unreadableMediaError returns the validation error of a source the prober could not read
+func unreadableMediaError() *MediaValidationError {
+	return &MediaValidationError{Violations: []MediaViolation{{Field: "file", Message: "file is not a readable media file"}}}
+}
+
+func (e *MediaValidationError) add(field string, format string, args ...any) {
+	e.Violations = append(e.Violations, MediaViolation{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// validateMedia is a method to check a probed source against the configured media policy
+func (s *VideoService) validateMedia(info types.MediaInfo) error {
+	policy := s.cfg.VideoService.MediaPolicy
+
+	validationErr := &MediaValidationError{}
+
+	// ffprobe reports every name a demuxer answers to, e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+	if !slices.ContainsFunc(strings.Split(info.Container, ","), func(name string) bool {
+		return slices.Contains(policy.AllowedContainers, name)
+	}) {
+		validationErr.add("container", "unsupported container %q", info.Container)
+	}
+
+	if info.VideoCodec == "" {
+		validationErr.add("video", "file has no video stream")
+	} else {
+		if !slices.Contains(policy.AllowedVideoCodecs, info.VideoCodec) {
+			validationErr.add("video_codec", "unsupported video codec %q", info.VideoCodec)
+		}
+
+		width, height := info.DisplaySize()
+		longSide, shortSide := max(width, height), min(width, height)
+
+		switch {
+		case shortSide <= 0:
+			validationErr.add("resolution", "video has no frame size")
+		case longSide > policy.MaxLongSide || shortSide > policy.MaxShortSide:
+			validationErr.add("resolution", "resolution %dx%d exceeds the maximum of %dx%d",
+				width, height, policy.MaxLongSide, policy.MaxShortSide)
+		}
+	}
+
+	if info.AudioCodec != "" && !slices.Contains(policy.AllowedAudioCodecs, info.AudioCodec) {
+		validationErr.add("audio_codec", "unsupported audio codec %q", info.AudioCodec)
+	}
+
+	duration := time.Duration(info.Duration * float64(time.Second))
+
+	switch {
+	case duration <= 0:
+		validationErr.add("duration", "media has no duration")
+	case policy.MaxDuration > 0 && duration > policy.MaxDuration:
+		validationErr.add("duration", "duration %s exceeds the maximum of %s",
+			duration.Round(time.Second), policy.MaxDuration)
+	}
+
+	if len(validationErr.Violations) > 0 {
+		return validationErr
+	}
+
+	return nil
+}
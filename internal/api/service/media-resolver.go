@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-fitness/external/logger/sl"
+	"go-fitness/internal/api/types"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var ErrVideoMediaNotFound = errors.New("video media not found")
+
+var (
+	// mediaPlaylistFileRe matches the media playlists and segments written for a rendition or an audio track name
+	mediaPlaylistFileRe = regexp.MustCompile(`^(.+?)(\.m3u8|_init\.mp4|_\d{3,}\.(?:ts|m4s))$`)
+	// captionFileRe matches the playlist, the whole track and the segments of a caption language
+	captionFileRe    = regexp.MustCompile(`^subs_([^_]+)(?:\.m3u8|\.vtt|_\d{3,}\.vtt)$`)
+	audioTrackNameRe = regexp.MustCompile(`^audio_\d+$`)
+)
+
+// playableHash is what the media requests of a video are resolved against, cached per video UUID
+type playableHash struct {
+	video types.Video
+	// labels are the names of the renditions a media playlist or segment of the hash may be named after
+	labels map[string]bool
+	// languages are the languages of the captions of the hash
+	languages map[string]bool
+}
+
+// resolveMedia is a method to map the path of a media request, a video UUID followed by a filename,
+// to a file of the video. The video must be processed and not soft deleted, and the filename must be one
+// the transcoder generates for it, so sources, sidecars and temporary files are never served.
+// Anything else is ErrVideoMediaNotFound.
+// Segments are resolved against the hash cached by the media playlist players fetch before them,
+// sparing a database and a storage read per segment. Playlists are always resolved afresh,
+// and so is a segment the cached hash does not know, in case a rendition or a caption was added since.
+func (s *VideoService) resolveMedia(ctx context.Context, url string, cached bool) (types.Video, string, error) {
+	const op string = "VideoService.resolveMedia"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("url", url),
+	)
+
+	videoUUID, filename, err := s.parseURL(url)
+	if err != nil {
+		log.Error("failed to parse url", sl.Err(err))
+		return types.Video{}, "", ErrVideoMediaNotFound
+	}
+
+	hash, err := s.getPlayableHash(ctx, videoUUID, cached)
+	if err != nil {
+		return types.Video{}, "", err
+	}
+
+	if !s.generatedMediaFile(hash, filename) && cached {
+		if hash, err = s.getPlayableHash(ctx, videoUUID, false); err != nil {
+			return types.Video{}, "", err
+		}
+	}
+
+	if !s.generatedMediaFile(hash, filename) {
+		log.Warn("filename is not a generated media file", sl.String("filename", filename))
+		return types.Video{}, "", ErrVideoMediaNotFound
+	}
+
+	return hash.video, filepath.Join(s.hashPath(hash.video), filename), nil
+}
+
+// getPlayableHash is a method to get the playable video of a UUID along with the renditions of its hash,
+// from the media cache when cached is set and it holds them
+func (s *VideoService) getPlayableHash(ctx context.Context, videoUUID string, cached bool) (playableHash, error) {
+	ttl := s.cfg.VideoService.MediaCacheTTL
+
+	if cached && ttl > 0 {
+		if hash, ok := s.mediaCache.Get(videoUUID); ok {
+			return hash.(playableHash), nil
+		}
+	}
+
+	video, err := s.getPlayableVideo(ctx, videoUUID)
+	if err != nil {
+		return playableHash{}, err
+	}
+
+	hash := playableHash{
+		video:     video,
+		labels:    make(map[string]bool),
+		languages: make(map[string]bool),
+	}
+
+	renditions, _ := s.readStoredRenditions(ctx, s.hashPath(video))
+	for _, rendition := range renditions {
+		hash.labels[rendition.Label] = true
+	}
+
+	// Hashes transcoded before the renditions sidecar existed were encoded with the configured labels
+	if len(renditions) == 0 {
+		for _, label := range s.cfg.VideoService.Resolutions {
+			hash.labels[label] = true
+		}
+	}
+
+	captions, err := s.captionRepo.GetByHashName(ctx, video.HashName)
+	if err != nil {
+		return playableHash{}, fmt.Errorf("failed to get captions: %w", err)
+	}
+	for _, caption := range captions {
+		hash.languages[caption.Language] = true
+	}
+
+	if ttl > 0 {
+		s.mediaCache.Set(videoUUID, hash, ttl)
+	}
+
+	return hash, nil
+}
+
+// forgetPlayableHash is a method to drop the cached videos of a hash whose renditions or status changed
+func (s *VideoService) forgetPlayableHash(hashName string) {
+	for videoUUID, item := range s.mediaCache.Items() {
+		if hash, ok := item.Object.(playableHash); ok && hash.video.HashName == hashName {
+			s.mediaCache.Delete(videoUUID)
+		}
+	}
+}
+
+// hashPath is a method to get the hash directory of a video
+func (s *VideoService) hashPath(video types.Video) string {
+	return filepath.Join(s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, video.HashName)
+}
+
+// getPlayableVideo is a method to get a video whose media may be served, ErrVideoMediaNotFound otherwise
+func (s *VideoService) getPlayableVideo(ctx context.Context, videoUUID string) (types.Video, error) {
+	video, err := s.videoRepo.GetPlayableByUUID(ctx, videoUUID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return types.Video{}, ErrVideoMediaNotFound
+		}
+		return types.Video{}, fmt.Errorf("failed to get video by uuid: %w", err)
+	}
+
+	return video, nil
+}
+
+// generatedMediaFile is a method to report whether a filename is a media playlist, a segment or a caption file
+// generated for the renditions, the audio tracks or the captions of a hash
+func (s *VideoService) generatedMediaFile(hash playableHash, filename string) bool {
+	if strings.HasPrefix(filename, ".") {
+		return false
+	}
+
+	if match := captionFileRe.FindStringSubmatch(filename); match != nil {
+		return hash.languages[match[1]]
+	}
+
+	match := mediaPlaylistFileRe.FindStringSubmatch(filename)
+	if match == nil {
+		return false
+	}
+
+	name := match[1]
+
+	return audioTrackNameRe.MatchString(name) || hash.labels[name]
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newResolverTestService returns a service holding a processed video with 360 and 720 renditions
+// and English captions, along with the video
+func newResolverTestService(t *testing.T) (*testVideoService, types.Video) {
+	t.Helper()
+
+	cfg := newTestConfig(t)
+	cfg.VideoService.MediaCacheTTL = time.Minute
+
+	s := newTestVideoService(cfg, NewFakeTranscoder())
+
+	ctx := context.Background()
+
+	id, err := s.videos.Create(ctx, types.Video{
+		Name:     "Clip",
+		HashName: "abc",
+		Status:   enum.VideoStatusProcessed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	video, err := s.videos.GetByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(s.hashPath(video), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRenditions(s.hashPath(video), []Rendition{{Label: "360"}, {Label: "720"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.captions.Save(ctx, types.VideoCaption{HashName: video.HashName, Language: "en"}); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, video
+}
+
+func TestGeneratedMediaFile(t *testing.T) {
+	s := newTestVideoService(newTestConfig(t), NewFakeTranscoder())
+
+	hash := playableHash{
+		labels:    map[string]bool{"360": true, "720": true},
+		languages: map[string]bool{"en": true, "pt-BR": true},
+	}
+
+	tests := []struct {
+		filename string
+		want     bool
+	}{
+		{"720.m3u8", true},
+		{"720_000.ts", true},
+		{"360_012.m4s", true},
+		{"720_init.mp4", true},
+		{"audio_1.m3u8", true},
+		{"audio_1_000.m4s", true},
+		{"subs_en.m3u8", true},
+		{"subs_en.vtt", true},
+		{"subs_pt-BR_003.vtt", true},
+
+		{"..", false},
+		{"../source.mp4", false},
+		{"..%2Fsource.mp4", false},
+		{"%2e%2e%2fsource.mp4", false},
+		{"720%2F..%2Fsource.mp4", false},
+		{".720.m3u8", false},
+		{".env", false},
+		{".incoming", false},
+		{"source.mp4", false},
+		{"source.mov", false},
+		{"renditions.json", false},
+		{"master.m3u8", false},
+		{"manifest.mpd", false},
+		{"720.m3u8.tmp", false},
+		{"720_00.ts", false},
+		{"720.mp4", false},
+		{"1080.m3u8", false},
+		{"1080_000.ts", false},
+		{"480_init.mp4", false},
+		{"audio_x.m3u8", false},
+		{"subs_fr.m3u8", false},
+		{"subs_fr_000.vtt", false},
+		{"subs_en.srt", false},
+		{"subs_.vtt", false},
+	}
+
+	for _, tt := range tests {
+		if got := s.generatedMediaFile(hash, tt.filename); got != tt.want {
+			t.Errorf("generatedMediaFile(%q) = %t, want %t", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestResolveMedia(t *testing.T) {
+	s, video := newResolverTestService(t)
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/videos/" + video.UUID + "/720.m3u8", "720.m3u8"},
+		{"/videos/" + video.UUID + "/360_000.ts", "360_000.ts"},
+		{"/videos/" + video.UUID + "/subs_en_000.vtt", "subs_en_000.vtt"},
+
+		{"/videos/" + video.UUID + "/1080.m3u8", ""},
+		{"/videos/" + video.UUID + "/subs_fr.m3u8", ""},
+		{"/videos/" + video.UUID + "/source.mp4", ""},
+		{"/videos/" + video.UUID + "/renditions.json", ""},
+		{"/videos/" + video.UUID + "/../source.mp4", ""},
+		{"/videos/" + video.UUID + "/..%2Fsource.mp4", ""},
+		{"/videos/" + video.UUID + "/.incoming", ""},
+		{"/videos/" + video.UUID + "/..", ""},
+		{"/videos/../" + video.HashName + "/720.m3u8", ""},
+		{"/videos/" + video.HashName + "/720.m3u8", ""},
+		{"/videos/" + video.UUID, ""},
+	}
+
+	for _, tt := range tests {
+		for _, cached := range []bool{false, true} {
+			_, path, err := s.resolveMedia(context.Background(), tt.url, cached)
+
+			if tt.want == "" {
+				if !errors.Is(err, ErrVideoMediaNotFound) {
+					t.Errorf("resolveMedia(%q, %t) = %q, %v, want ErrVideoMediaNotFound", tt.url, cached, path, err)
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("resolveMedia(%q, %t): %v", tt.url, cached, err)
+				continue
+			}
+			if want := filepath.Join(s.hashPath(video), tt.want); path != want {
+				t.Errorf("resolveMedia(%q, %t) = %q, want %q", tt.url, cached, path, want)
+			}
+		}
+	}
+}
+
+func TestResolveMediaRejectsUnplayableVideos(t *testing.T) {
+	deletedAt := time.Now()
+
+	tests := []struct {
+		name      string
+		status    enum.VideoStatus
+		deletedAt *time.Time
+	}{
+		{"soft deleted", enum.VideoStatusProcessed, &deletedAt},
+		{"processing", enum.VideoStatusProcessing, nil},
+		{"failed", enum.VideoStatusFailed, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, video := newResolverTestService(t)
+
+			err := s.videos.update(func(v *types.Video) bool { return v.ID == video.ID }, func(v *types.Video) {
+				v.Status = tt.status
+				v.DeletedAt = tt.deletedAt
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, cached := range []bool{false, true} {
+				_, _, err := s.resolveMedia(context.Background(), "/videos/"+video.UUID+"/720.m3u8", cached)
+				if !errors.Is(err, ErrVideoMediaNotFound) {
+					t.Errorf("resolveMedia(%t) = %v, want ErrVideoMediaNotFound", cached, err)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveMediaCache(t *testing.T) {
+	segment := func(s *testVideoService, video types.Video, filename string) error {
+		_, _, err := s.resolveMedia(context.Background(), "/videos/"+video.UUID+"/"+filename, true)
+		return err
+	}
+
+	t.Run("deleted video", func(t *testing.T) {
+		s, video := newResolverTestService(t)
+
+		if err := segment(s, video, "720_000.ts"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.ProcessDeleteVideo(context.Background(), video.UUID); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := segment(s, video, "720_000.ts"); !errors.Is(err, ErrVideoMediaNotFound) {
+			t.Errorf("segment of a deleted video = %v, want ErrVideoMediaNotFound", err)
+		}
+	})
+
+	t.Run("soft deleted video", func(t *testing.T) {
+		s, video := newResolverTestService(t)
+
+		if err := segment(s, video, "720_000.ts"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.ProcessSoftDeleteVideo(context.Background(), video.UUID); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := segment(s, video, "720_000.ts"); !errors.Is(err, ErrVideoMediaNotFound) {
+			t.Errorf("segment of a soft deleted video = %v, want ErrVideoMediaNotFound", err)
+		}
+	})
+
+	t.Run("failed transcode of the hash", func(t *testing.T) {
+		s, video := newResolverTestService(t)
+
+		if err := segment(s, video, "720_000.ts"); err != nil {
+			t.Fatal(err)
+		}
+
+		// The hash is transcoded again for a video linked to it, which fails
+		s.failTranscodedVideo(context.Background(), types.TranscodeJob{
+			VideoID:    video.ID,
+			ChunkHash:  video.HashName,
+			UploadPath: s.hashPath(video),
+			DstPath:    filepath.Join(s.hashPath(video), "source.mp4"),
+		})
+
+		if err := segment(s, video, "720_000.ts"); !errors.Is(err, ErrVideoMediaNotFound) {
+			t.Errorf("segment of a failed video = %v, want ErrVideoMediaNotFound", err)
+		}
+	})
+
+	t.Run("renditions and captions added since", func(t *testing.T) {
+		s, video := newResolverTestService(t)
+
+		if err := segment(s, video, "720_000.ts"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := writeRenditions(s.hashPath(video), []Rendition{{Label: "720"}, {Label: "1080"}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.captions.Save(context.Background(), types.VideoCaption{HashName: video.HashName, Language: "fr"}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, filename := range []string{"1080_000.ts", "subs_fr_000.vtt"} {
+			if err := segment(s, video, filename); err != nil {
+				t.Errorf("%s: %v", filename, err)
+			}
+		}
+
+		// The cache now holds the hash as it was re-read
+		if err := segment(s, video, "360_000.ts"); !errors.Is(err, ErrVideoMediaNotFound) {
+			t.Errorf("segment of a removed rendition = %v, want ErrVideoMediaNotFound", err)
+		}
+	})
+}
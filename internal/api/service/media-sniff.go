@@ -0,0 +1,66 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// sniffLength is the number of leading bytes inspected to recognize a video container
+const sniffLength = 512
+
+var ErrUnsupportedMediaType = errors.New("file is not a supported video")
+
+// sniffVideo recognizes video containers from the first bytes of a file.
+// It covers what http.DetectContentType knows (mp4, webm/mkv, avi, mpeg, ogg)
+// plus any ISO BMFF file (mov, m4v, 3gp) and MPEG transport streams.
+func sniffVideo(head []byte) (string, bool) {
+	contentType := http.DetectContentType(head)
+	if strings.HasPrefix(contentType, "video/") || contentType == "application/ogg" {
+		return contentType, true
+	}
+
+	if len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")) {
+		if bytes.Equal(head[8:12], []byte("qt  ")) {
+			return "video/quicktime", true
+		}
+		return "video/mp4", true
+	}
+
+	if len(head) > 188 && head[0] == 0x47 && head[188] == 0x47 {
+		return "video/mp2t", true
+	}
+
+	return contentType, false
+}
+
+// maxSizeReader fails with ErrUploadTooLarge as soon as more than limit bytes are read
+type maxSizeReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func newMaxSizeReader(r io.Reader, limit int64) *maxSizeReader {
+	return &maxSizeReader{r: r, remaining: limit}
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, ErrUploadTooLarge
+	}
+
+	// Read one byte past the limit to tell an exact fit from an oversized stream
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return n + int(m.remaining), ErrUploadTooLarge
+	}
+
+	return n, err
+}
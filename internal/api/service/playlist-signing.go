@@ -0,0 +1,100 @@
+package service
+
+import (
+	"bytes"
+	"go-fitness/external/urlsign"
+	"go-fitness/internal/api/data"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var playlistURIAttrRe = regexp.MustCompile(`URI="([^"]*)"`)
+
+// manifestURLRe matches the URLs of a DASH manifest, the initialization and media templates of the segments
+// and the base URLs of the caption tracks
+var manifestURLRe = regexp.MustCompile(`((?:initialization|media)=")([^"]*)(")|(<BaseURL>)([^<]*)(</BaseURL>)`)
+
+// signPlaylist is a method to append a token granting access to the media of a video to every URI of a playlist,
+// the variant, media, segment and key URIs alike. A playlist requested with a signed URL passes its token on,
+// so following a playlist never extends a token. Playlists are returned unchanged while signing is disabled
+// or when they are not served to a user.
+func (s *VideoService) signPlaylist(playlist []byte, videoUUID string, access data.PlaylistAccess) []byte {
+	if s.cfg.VideoService.SignedURLs.Secret == "" || access.User == "" {
+		return playlist
+	}
+
+	token := s.mediaToken(videoUUID, access)
+
+	lines := bytes.Split(playlist, []byte("\n"))
+	for i, line := range lines {
+		text := strings.TrimSpace(string(line))
+
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "#"):
+			lines[i] = playlistURIAttrRe.ReplaceAllFunc(line, func(attr []byte) []byte {
+				uri := playlistURIAttrRe.FindSubmatch(attr)[1]
+				return []byte(`URI="` + signedURI(string(uri), token) + `"`)
+			})
+		default:
+			lines[i] = []byte(signedURI(text, token))
+		}
+	}
+
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// signManifest is a method to append a token granting access to the media of a video to every URL of a DASH manifest,
+// so players fetch its segments and caption tracks through the signed URL route like those of a playlist.
+// Manifests are returned unchanged under the same conditions as playlists.
+func (s *VideoService) signManifest(manifest []byte, videoUUID string, access data.PlaylistAccess) []byte {
+	if s.cfg.VideoService.SignedURLs.Secret == "" || access.User == "" {
+		return manifest
+	}
+
+	token := s.mediaToken(videoUUID, access)
+
+	return manifestURLRe.ReplaceAllFunc(manifest, func(match []byte) []byte {
+		groups := manifestURLRe.FindSubmatch(match)
+		if groups[1] == nil {
+			groups = groups[3:]
+		}
+
+		// The separator of the query is escaped in the manifest, which is XML
+		uri := html.UnescapeString(string(groups[2]))
+		return []byte(string(groups[1]) + html.EscapeString(signedURI(uri, token)) + string(groups[3]))
+	})
+}
+
+// mediaToken is a method to get the token the URIs of a playlist or manifest are signed with,
+// the token it was requested with or a new one
+func (s *VideoService) mediaToken(videoUUID string, access data.PlaylistAccess) string {
+	if access.Token != "" {
+		return access.Token
+	}
+
+	cfg := s.cfg.VideoService.SignedURLs
+
+	claims := urlsign.Claims{
+		Video:   videoUUID,
+		User:    access.User,
+		Expires: time.Now().Add(cfg.TTL).Unix(),
+	}
+	if cfg.BindIP {
+		claims.IP = access.IP
+	}
+
+	return urlsign.New(cfg.Secret).Sign(claims)
+}
+
+// signedURI appends a token to a playlist URI
+func signedURI(uri string, token string) string {
+	separator := "?"
+	if strings.Contains(uri, "?") {
+		separator = "&"
+	}
+
+	return uri + separator + urlsign.QueryParam + "=" + token
+}
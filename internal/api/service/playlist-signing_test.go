@@ -0,0 +1,316 @@
+package service
+
+import (
+	"encoding/xml"
+	"go-fitness/external/urlsign"
+	"go-fitness/internal/api/data"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const signingTestUUID = "0f8a6f3e-6a4e-4d1c-9a53-1b0e0c6c2f41"
+
+const testMasterPlaylist = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="English",LANGUAGE="en",DEFAULT=YES,URI="` + signingTestUUID + `/audio_1.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",LANGUAGE="en",DEFAULT=NO,URI="` + signingTestUUID + `/subs_en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1200000,RESOLUTION=640x360,CODECS="avc1.64001e,mp4a.40.2",AUDIO="audio",SUBTITLES="subs"
+` + signingTestUUID + `/360.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=4500000,RESOLUTION=1280x720,CODECS="avc1.64001f,mp4a.40.2",AUDIO="audio",SUBTITLES="subs"
+` + signingTestUUID + `/720.m3u8
+`
+
+const testMediaPlaylist = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-KEY:METHOD=AES-128,URI="/api/v1/videos/` + signingTestUUID + `/keys/0",IV=0x00000000000000000000000000000000
+#EXT-X-MAP:URI="720_init.mp4"
+#EXTINF:4.000000,
+720_000.m4s
+#EXTINF:4.000000,
+720_001.m4s
+#EXT-X-KEY:METHOD=AES-128,URI="/api/v1/videos/` + signingTestUUID + `/keys/1?rotation=1",IV=0x00000000000000000000000000000001
+#EXTINF:2.500000,
+720_002.m4s
+#EXT-X-ENDLIST
+`
+
+const testDASHManifest = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011" type="static" mediaPresentationDuration="PT10.5S" minBufferTime="PT4S">
+  <Period id="0" start="PT0S">
+    <AdaptationSet id="0" contentType="video" mimeType="video/mp4" segmentAlignment="true">
+      <Representation id="360" bandwidth="1200000" codecs="avc1.64001e" width="640" height="360">
+        <SegmentTemplate timescale="1000" initialization="` + signingTestUUID + `/360_init.mp4" media="` + signingTestUUID + `/360_$Number%03d$.m4s" startNumber="0">
+          <SegmentTimeline>
+            <S t="0" d="4000" r="1"></S>
+            <S d="2500"></S>
+          </SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+      <Representation id="720" bandwidth="4500000" codecs="avc1.64001f" width="1280" height="720">
+        <SegmentTemplate timescale="1000" initialization="` + signingTestUUID + `/720_init.mp4?v=2&amp;cdn=a" media="` + signingTestUUID + `/720_$Number%03d$.m4s" startNumber="0">
+          <SegmentTimeline>
+            <S t="0" d="4000" r="1"></S>
+            <S d="2500"></S>
+          </SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet id="1" contentType="audio" mimeType="audio/mp4" lang="en">
+      <Representation id="audio_1" bandwidth="128000" codecs="mp4a.40.2">
+        <SegmentTemplate timescale="1000" initialization="` + signingTestUUID + `/audio_1_init.mp4" media="` + signingTestUUID + `/audio_1_$Number%03d$.m4s" startNumber="0">
+          <SegmentTimeline>
+            <S t="0" d="4000" r="1"></S>
+            <S d="2500"></S>
+          </SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet id="2" contentType="text" mimeType="text/vtt" lang="en">
+      <Role schemeIdUri="urn:mpeg:dash:role:2011" value="subtitle"></Role>
+      <Label>English</Label>
+      <Representation id="subs_en" bandwidth="256">
+        <BaseURL>` + signingTestUUID + `/subs_en.vtt</BaseURL>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+func newSigningTestService(t *testing.T, secret string) *testVideoService {
+	t.Helper()
+
+	cfg := newTestConfig(t)
+	cfg.VideoService.SignedURLs.Secret = secret
+	cfg.VideoService.SignedURLs.TTL = time.Hour
+
+	return newTestVideoService(cfg, NewFakeTranscoder())
+}
+
+// playlistURIs returns the URIs of a playlist, those of its URI lines and of the URI attributes of its tags
+func playlistURIs(playlist string) []string {
+	var uris []string
+
+	for _, line := range strings.Split(playlist, "\n") {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			for _, match := range playlistURIAttrRe.FindAllStringSubmatch(line, -1) {
+				uris = append(uris, match[1])
+			}
+		default:
+			uris = append(uris, line)
+		}
+	}
+
+	return uris
+}
+
+// manifestURIs returns the segment templates and base URLs of a DASH manifest
+func manifestURIs(t *testing.T, manifest string) []string {
+	t.Helper()
+
+	var mpd dashMPD
+	if err := xml.Unmarshal([]byte(manifest), &mpd); err != nil {
+		t.Fatalf("the manifest is not valid XML: %v", err)
+	}
+
+	var uris []string
+	for _, set := range mpd.Period.AdaptationSets {
+		for _, representation := range set.Representations {
+			if representation.BaseURL != "" {
+				uris = append(uris, representation.BaseURL)
+			}
+			if template := representation.SegmentTemplate; template != nil {
+				uris = append(uris, template.Initialization, template.Media)
+			}
+		}
+	}
+
+	return uris
+}
+
+// checkSignedURIs checks that every signed URI is the original one with a token appended,
+// a token granting the video to the user
+func checkSignedURIs(t *testing.T, original []string, signed []string, access data.PlaylistAccess) {
+	t.Helper()
+
+	if len(signed) != len(original) {
+		t.Fatalf("%d URIs were signed, want %d:\n%s", len(signed), len(original), strings.Join(signed, "\n"))
+	}
+
+	signer := urlsign.New("secret")
+
+	for i, uri := range signed {
+		base, query, _ := strings.Cut(original[i], "?")
+
+		// Segment templates are not valid URLs until their identifiers are substituted
+		signedBase, signedQuery, _ := strings.Cut(uri, "?")
+		if signedBase != base {
+			t.Errorf("signed URI %s does not point at %s", uri, original[i])
+		}
+
+		values := mustParseQuery(t, signedQuery)
+		for name, value := range mustParseQuery(t, query) {
+			if values.Get(name) != value[0] {
+				t.Errorf("signed URI %s lost the query parameter %s of %s", uri, name, original[i])
+			}
+		}
+
+		if values[urlsign.QueryParam] == nil {
+			t.Errorf("URI %s is not signed", uri)
+			continue
+		}
+
+		claims, err := signer.Verify(values.Get(urlsign.QueryParam), time.Now())
+		if err != nil {
+			t.Errorf("token of %s: %v", uri, err)
+			continue
+		}
+		if claims.Video != signingTestUUID || claims.User != access.User {
+			t.Errorf("token of %s grants %s to %s, want %s to %s", uri, claims.Video, claims.User, signingTestUUID, access.User)
+		}
+	}
+}
+
+func mustParseQuery(t *testing.T, query string) url.Values {
+	t.Helper()
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return values
+}
+
+func TestSignPlaylist(t *testing.T) {
+	s := newSigningTestService(t, "secret")
+	access := data.PlaylistAccess{User: "5d2c1c7a-3b9e-4c44-8f0a-2e6b7f9d1a10", IP: "203.0.113.7"}
+
+	for name, playlist := range map[string]string{"master": testMasterPlaylist, "media": testMediaPlaylist} {
+		t.Run(name, func(t *testing.T) {
+			signed := string(s.signPlaylist([]byte(playlist), signingTestUUID, access))
+
+			checkSignedURIs(t, playlistURIs(playlist), playlistURIs(signed), access)
+
+			// Every line but the URIs is left as it was
+			originalLines := strings.Split(playlist, "\n")
+			for i, line := range strings.Split(signed, "\n") {
+				if !strings.Contains(line, urlsign.QueryParam+"=") && line != originalLines[i] {
+					t.Errorf("line %d = %q, want %q", i, line, originalLines[i])
+				}
+			}
+		})
+	}
+
+	t.Run("key and map", func(t *testing.T) {
+		signed := string(s.signPlaylist([]byte(testMediaPlaylist), signingTestUUID, access))
+
+		for _, line := range strings.Split(signed, "\n") {
+			if strings.HasPrefix(line, "#EXT-X-KEY:") || strings.HasPrefix(line, "#EXT-X-MAP:") {
+				if !strings.Contains(line, "?"+urlsign.QueryParam+"=") && !strings.Contains(line, "&"+urlsign.QueryParam+"=") {
+					t.Errorf("%s is not signed", line)
+				}
+			}
+		}
+	})
+}
+
+func TestSignManifest(t *testing.T) {
+	s := newSigningTestService(t, "secret")
+	access := data.PlaylistAccess{User: "5d2c1c7a-3b9e-4c44-8f0a-2e6b7f9d1a10"}
+
+	signed := string(s.signManifest([]byte(testDASHManifest), signingTestUUID, access))
+
+	original := manifestURIs(t, testDASHManifest)
+	if len(original) != 7 {
+		t.Fatalf("the test manifest has %d URIs, want 7", len(original))
+	}
+
+	checkSignedURIs(t, original, manifestURIs(t, signed), access)
+}
+
+func TestSignPlaylistToken(t *testing.T) {
+	access := data.PlaylistAccess{User: "5d2c1c7a-3b9e-4c44-8f0a-2e6b7f9d1a10", IP: "203.0.113.7"}
+
+	tokenOf := func(t *testing.T, playlist []byte) string {
+		t.Helper()
+
+		uris := playlistURIs(string(playlist))
+		parsed, err := url.Parse(uris[len(uris)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return parsed.Query().Get(urlsign.QueryParam)
+	}
+
+	t.Run("passed on", func(t *testing.T) {
+		s := newSigningTestService(t, "secret")
+
+		access := access
+		access.Token = "token-of-the-request"
+
+		if token := tokenOf(t, s.signPlaylist([]byte(testMediaPlaylist), signingTestUUID, access)); token != access.Token {
+			t.Errorf("token = %q, want the token of the request", token)
+		}
+	})
+
+	t.Run("bound to the client ip", func(t *testing.T) {
+		s := newSigningTestService(t, "secret")
+		s.cfg.VideoService.SignedURLs.BindIP = true
+
+		claims, err := urlsign.New("secret").Verify(tokenOf(t, s.signPlaylist([]byte(testMediaPlaylist), signingTestUUID, access)), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.IP != access.IP {
+			t.Errorf("token bound to %q, want %q", claims.IP, access.IP)
+		}
+	})
+
+	t.Run("expiring after the ttl", func(t *testing.T) {
+		s := newSigningTestService(t, "secret")
+
+		claims, err := urlsign.New("secret").Verify(tokenOf(t, s.signPlaylist([]byte(testMediaPlaylist), signingTestUUID, access)), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expiresAt := time.Unix(claims.Expires, 0); expiresAt.Before(time.Now().Add(59*time.Minute)) || expiresAt.After(time.Now().Add(time.Hour)) {
+			t.Errorf("token expires at %s, want in an hour", expiresAt)
+		}
+		if claims.IP != "" {
+			t.Errorf("token bound to %q while BindIP is off", claims.IP)
+		}
+	})
+}
+
+func TestSignPlaylistUnchanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		user   string
+	}{
+		{"signing disabled", "", "5d2c1c7a-3b9e-4c44-8f0a-2e6b7f9d1a10"},
+		{"no user", "secret", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSigningTestService(t, tt.secret)
+			access := data.PlaylistAccess{User: tt.user}
+
+			if signed := s.signPlaylist([]byte(testMediaPlaylist), signingTestUUID, access); string(signed) != testMediaPlaylist {
+				t.Errorf("playlist was changed:\n%s", signed)
+			}
+			if signed := s.signManifest([]byte(testDASHManifest), signingTestUUID, access); string(signed) != testDASHManifest {
+				t.Errorf("manifest was changed:\n%s", signed)
+			}
+		})
+	}
+}
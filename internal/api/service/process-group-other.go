@@ -0,0 +1,13 @@
+//go:build !unix
+
+package service
+
+import (
+	"os/exec"
+	"time"
+)
+
+// configureProcessGroup relies on the default context cancellation, which kills the process only
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = 5 * time.Second
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-fitness/internal/api/types"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// renditionsSidecar is the file next to the master playlist describing the produced renditions,
+// so the master playlist can be regenerated without probing the segments again
+const renditionsSidecar = "renditions.json"
+
+// Rendition is a single output variant of the encoding ladder
+type Rendition struct {
+	Label            string  `json:"label"`
+	Profile          string  `json:"profile"`
+	Width            int     `json:"width"`
+	Height           int     `json:"height"`
+	Bandwidth        int64   `json:"bandwidth"`
+	AverageBandwidth int64   `json:"average_bandwidth"`
+	Codecs           string  `json:"codecs"`
+	FrameRate        float64 `json:"frame_rate"`
+	// SegmentFormat is the container of the segments, MPEG-TS when empty
+	SegmentFormat string `json:"segment_format,omitempty"`
+}
+
+// ScaleFilter returns the ffmpeg video filter producing the rendition frame size with square pixels
+func (r Rendition) ScaleFilter() string {
+	return fmt.Sprintf("scale=%d:%d,setsar=1", r.Width, r.Height)
+}
+
+type ladderStep struct {
+	label     string
+	shortSide int
+}
+
+// buildRenditionLadder is a method to derive the renditions to encode from the probed source.
+// Each configured resolution is treated as a target for the short side of the displayed frame,
+// so portrait footage gets the same ladder as landscape. Renditions above the source are skipped;
+// when the source is smaller than every configured resolution it is encoded once at its own size.
+func (s *VideoService) buildRenditionLadder(info types.MediaInfo) ([]Rendition, error) {
+	width, height := info.DisplaySize()
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid source frame size %dx%d", width, height)
+	}
+
+	sourceShortSide := min(width, height)
+
+	var steps []ladderStep
+	for res, label := range s.cfg.VideoService.Resolutions {
+		_, h, found := strings.Cut(res, "x")
+		shortSide, err := strconv.Atoi(h)
+		if !found || err != nil || shortSide <= 0 {
+			return nil, fmt.Errorf("invalid resolution %q", res)
+		}
+
+		steps = append(steps, ladderStep{label: label, shortSide: shortSide})
+	}
+
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no resolutions configured")
+	}
+
+	sort.Slice(steps, func(i, j int) bool {
+		return steps[i].shortSide < steps[j].shortSide
+	})
+
+	var ladder []Rendition
+	for _, step := range steps {
+		if step.shortSide > sourceShortSide {
+			break
+		}
+
+		ladder = append(ladder, scaleRendition(step.label, width, height, step.shortSide))
+	}
+
+	if len(ladder) == 0 {
+		ladder = append(ladder, scaleRendition(steps[0].label, width, height, sourceShortSide))
+	}
+
+	for i := range ladder {
+		ladder[i].Profile, _ = s.cfg.VideoService.EncodingProfileFor(ladder[i].Label)
+	}
+
+	return ladder, nil
+}
+
+// scaleRendition fits the short side of the source to shortSide keeping the aspect ratio.
+// Both dimensions are rounded to even numbers as required by yuv420p.
+func scaleRendition(label string, width int, height int, shortSide int) Rendition {
+	if width >= height {
+		return Rendition{
+			Label:  label,
+			Width:  evenDimension(float64(shortSide) * float64(width) / float64(height)),
+			Height: evenDimension(float64(shortSide)),
+		}
+	}
+
+	return Rendition{
+		Label:  label,
+		Width:  evenDimension(float64(shortSide)),
+		Height: evenDimension(float64(shortSide) * float64(height) / float64(width)),
+	}
+}
+
+func evenDimension(value float64) int {
+	even := int(math.Round(value/2)) * 2
+	if even < 2 {
+		return 2
+	}
+
+	return even
+}
+
+// writeRenditions stores the produced renditions in the sidecar file of the upload path
+func writeRenditions(uploadPath string, renditions []Rendition) error {
+	encoded, err := json.MarshalIndent(renditions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(uploadPath, renditionsSidecar), encoded)
+}
+
+// readRenditions loads the produced renditions from the sidecar file of the upload path
+func readRenditions(uploadPath string) ([]Rendition, error) {
+	encoded, err := os.ReadFile(filepath.Join(uploadPath, renditionsSidecar))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRenditions(encoded)
+}
+
+// readStoredRenditions is a method to load the produced renditions from the stored sidecar file of the upload path,
+// which the storage path may not hold when the storage is remote
+func (s *VideoService) readStoredRenditions(ctx context.Context, uploadPath string) ([]Rendition, error) {
+	encoded, err := s.readStored(ctx, filepath.Join(uploadPath, renditionsSidecar))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRenditions(encoded)
+}
+
+func decodeRenditions(encoded []byte) ([]Rendition, error) {
+	var renditions []Rendition
+	if err := json.Unmarshal(encoded, &renditions); err != nil {
+		return nil, err
+	}
+
+	return renditions, nil
+}
@@ -0,0 +1,473 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/event"
+	"go-fitness/internal/api/repository"
+	"go-fitness/internal/api/types"
+	"sync"
+	"time"
+)
+
+// The fakes below keep their rows in memory. They embed the interface they implement,
+// so a method the pipeline is not expected to call panics instead of silently succeeding.
+
+type fakeVideoRepository struct {
+	repository.VideoRepositoryInterface
+
+	// jobRepo and mediaInfoRepo receive the rows created along with a video
+	jobRepo       *fakeTranscodeJobRepository
+	mediaInfoRepo *fakeMediaInfoRepository
+
+	mu     sync.Mutex
+	videos []types.Video
+}
+
+func (r *fakeVideoRepository) Create(_ context.Context, video types.Video) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	video.ID = int64(len(r.videos) + 1)
+	video.UUID = fmt.Sprintf("00000000-0000-0000-0000-%012d", video.ID)
+	r.videos = append(r.videos, video)
+
+	return video.ID, nil
+}
+
+// CreateWithTranscodeJob creates no video when its media info is not saved
+func (r *fakeVideoRepository) CreateWithTranscodeJob(
+	ctx context.Context,
+	video types.Video,
+	info types.VideoMediaInfo,
+	job types.TranscodeJob,
+) (int64, error) {
+	if err := r.mediaInfoRepo.Save(ctx, info); err != nil {
+		return 0, err
+	}
+
+	id, err := r.Create(ctx, video)
+	if err != nil {
+		return 0, err
+	}
+
+	job.VideoID = id
+	if _, err := r.jobRepo.Create(ctx, job); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *fakeVideoRepository) UpdateStatus(_ context.Context, id int64, status enum.VideoStatus) error {
+	return r.update(func(video *types.Video) bool { return video.ID == id }, func(video *types.Video) {
+		video.Status = status
+	})
+}
+
+func (r *fakeVideoRepository) UpdateStatusByHashName(
+	_ context.Context,
+	hashName string,
+	from enum.VideoStatus,
+	to enum.VideoStatus,
+) error {
+	_ = r.update(func(video *types.Video) bool { return video.HashName == hashName && video.Status == from }, func(video *types.Video) {
+		video.Status = to
+	})
+
+	return nil
+}
+
+func (r *fakeVideoRepository) GetByID(_ context.Context, id int64) (types.Video, error) {
+	return r.find(func(video types.Video) bool { return video.ID == id })
+}
+
+func (r *fakeVideoRepository) GetByUUID(_ context.Context, uuid string) (types.Video, error) {
+	return r.find(func(video types.Video) bool { return video.UUID == uuid && video.Status == enum.VideoStatusProcessed })
+}
+
+func (r *fakeVideoRepository) GetByUUIDAnyStatus(_ context.Context, uuid string) (types.Video, error) {
+	return r.find(func(video types.Video) bool { return video.UUID == uuid })
+}
+
+func (r *fakeVideoRepository) GetPlayableByUUID(_ context.Context, uuid string) (types.Video, error) {
+	return r.find(func(video types.Video) bool {
+		return video.UUID == uuid && video.Status == enum.VideoStatusProcessed && video.DeletedAt == nil
+	})
+}
+
+func (r *fakeVideoRepository) GetLatestByHashName(_ context.Context, hashName string) (types.Video, error) {
+	return r.find(func(video types.Video) bool {
+		return video.HashName == hashName && video.DeletedAt == nil &&
+			(video.Status == enum.VideoStatusProcessed || video.Status == enum.VideoStatusProcessing)
+	})
+}
+
+func (r *fakeVideoRepository) CountByHashName(_ context.Context, hashName string, excludeID int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var count int64
+	for _, video := range r.videos {
+		if video.HashName == hashName && video.ID != excludeID {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *fakeVideoRepository) SoftDelete(_ context.Context, id int64) error {
+	return r.update(func(video *types.Video) bool { return video.ID == id }, func(video *types.Video) {
+		deletedAt := time.Now()
+		video.DeletedAt = &deletedAt
+	})
+}
+
+func (r *fakeVideoRepository) Delete(_ context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.videos {
+		if r.videos[i].ID == id {
+			r.videos = append(r.videos[:i], r.videos[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (r *fakeVideoRepository) find(match func(types.Video) bool) (types.Video, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := len(r.videos) - 1; i >= 0; i-- {
+		if match(r.videos[i]) {
+			return r.videos[i], nil
+		}
+	}
+
+	return types.Video{}, sql.ErrNoRows
+}
+
+func (r *fakeVideoRepository) update(match func(*types.Video) bool, apply func(*types.Video)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	found := false
+	for i := range r.videos {
+		if match(&r.videos[i]) {
+			apply(&r.videos[i])
+			found = true
+		}
+	}
+
+	if !found {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+type fakeTranscodeJobRepository struct {
+	repository.TranscodeJobRepositoryInterface
+
+	mu       sync.Mutex
+	jobs     []types.TranscodeJob
+	attempts []types.TranscodeJobAttempt
+	// claimPanics is the number of upcoming claims panicking, as a bug in a worker would
+	claimPanics int
+}
+
+func (r *fakeTranscodeJobRepository) Create(_ context.Context, job types.TranscodeJob) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job.ID = int64(len(r.jobs) + 1)
+	job.State = enum.TranscodeJobStatePending
+	if job.Kind == enum.TranscodeJobKindUnknown {
+		job.Kind = enum.TranscodeJobKindVideo
+	}
+	r.jobs = append(r.jobs, job)
+
+	return job.ID, nil
+}
+
+func (r *fakeTranscodeJobRepository) Claim(
+	_ context.Context,
+	owner string,
+	lease time.Duration,
+	maxAttempts int,
+) (types.TranscodeJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.claimPanics > 0 {
+		r.claimPanics--
+		panic("claim panicked")
+	}
+
+	for i := range r.jobs {
+		job := &r.jobs[i]
+
+		due := job.State == enum.TranscodeJobStatePending && (job.AvailableAt == nil || !job.AvailableAt.After(time.Now()))
+		expired := job.State == enum.TranscodeJobStateRunning && job.LeaseExpiresAt != nil && job.LeaseExpiresAt.Before(time.Now())
+		if !due && !expired {
+			continue
+		}
+
+		if expired && job.Attempts >= maxAttempts {
+			job.State = enum.TranscodeJobStateFailed
+			job.LastError = repository.ErrTranscodeJobAttemptsExhausted.Error()
+			job.LeaseOwner = ""
+			job.LeaseExpiresAt = nil
+
+			return *job, nil
+		}
+
+		expiresAt := time.Now().Add(lease)
+		job.State = enum.TranscodeJobStateRunning
+		job.Attempts++
+		job.LeaseOwner = owner
+		job.LeaseExpiresAt = &expiresAt
+
+		return *job, nil
+	}
+
+	return types.TranscodeJob{}, sql.ErrNoRows
+}
+
+func (r *fakeTranscodeJobRepository) ExtendLease(_ context.Context, id int64, owner string, lease time.Duration) error {
+	return r.update(id, func(job *types.TranscodeJob) {
+		expiresAt := time.Now().Add(lease)
+		job.LeaseExpiresAt = &expiresAt
+	})
+}
+
+func (r *fakeTranscodeJobRepository) Complete(_ context.Context, id int64) error {
+	return r.update(id, func(job *types.TranscodeJob) {
+		job.State = enum.TranscodeJobStateCompleted
+	})
+}
+
+func (r *fakeTranscodeJobRepository) Fail(_ context.Context, id int64, lastError string) error {
+	return r.update(id, func(job *types.TranscodeJob) {
+		job.State = enum.TranscodeJobStateFailed
+		job.LastError = lastError
+	})
+}
+
+func (r *fakeTranscodeJobRepository) Retry(_ context.Context, id int64, lastError string, availableAt time.Time) error {
+	return r.update(id, func(job *types.TranscodeJob) {
+		job.State = enum.TranscodeJobStatePending
+		job.LastError = lastError
+		job.AvailableAt = &availableAt
+	})
+}
+
+func (r *fakeTranscodeJobRepository) Release(_ context.Context, id int64, _ string) error {
+	return r.update(id, func(job *types.TranscodeJob) {
+		job.State = enum.TranscodeJobStatePending
+	})
+}
+
+func (r *fakeTranscodeJobRepository) RecordAttempt(_ context.Context, attempt types.TranscodeJobAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.attempts = append(r.attempts, attempt)
+
+	return nil
+}
+
+func (r *fakeTranscodeJobRepository) GetByID(_ context.Context, id int64) (types.TranscodeJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, job := range r.jobs {
+		if job.ID == id {
+			return job, nil
+		}
+	}
+
+	return types.TranscodeJob{}, sql.ErrNoRows
+}
+
+func (r *fakeTranscodeJobRepository) GetLatestByVideoID(_ context.Context, videoID int64) (types.TranscodeJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := len(r.jobs) - 1; i >= 0; i-- {
+		if r.jobs[i].VideoID == videoID && r.jobs[i].Kind == enum.TranscodeJobKindVideo {
+			return r.jobs[i], nil
+		}
+	}
+
+	return types.TranscodeJob{}, sql.ErrNoRows
+}
+
+func (r *fakeTranscodeJobRepository) Cancel(_ context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.jobs {
+		job := &r.jobs[i]
+		if job.ID != id {
+			continue
+		}
+
+		if job.State != enum.TranscodeJobStatePending && job.State != enum.TranscodeJobStateRunning {
+			return repository.ErrTranscodeJobNotCancellable
+		}
+
+		job.State = enum.TranscodeJobStateCancelled
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+func (r *fakeTranscodeJobRepository) UpdateProgress(_ context.Context, id int64, progress types.TranscodeProgress) error {
+	return r.update(id, func(job *types.TranscodeJob) {
+		job.Progress = progress
+	})
+}
+
+func (r *fakeTranscodeJobRepository) RequeueExpired(context.Context, int) (int64, error) {
+	return 0, nil
+}
+
+func (r *fakeTranscodeJobRepository) update(id int64, apply func(*types.TranscodeJob)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.jobs {
+		if r.jobs[i].ID == id {
+			apply(&r.jobs[i])
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+type fakeMediaInfoRepository struct {
+	repository.MediaInfoRepositoryInterface
+
+	mu    sync.Mutex
+	infos []types.VideoMediaInfo
+	// err fails every save when set
+	err error
+}
+
+func (r *fakeMediaInfoRepository) Save(_ context.Context, info types.VideoMediaInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	r.infos = append(r.infos, info)
+
+	return nil
+}
+
+func (r *fakeMediaInfoRepository) DeleteByHashName(_ context.Context, hashName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := r.infos[:0]
+	for _, info := range r.infos {
+		if info.HashName != hashName {
+			infos = append(infos, info)
+		}
+	}
+	r.infos = infos
+
+	return nil
+}
+
+type fakeCaptionRepository struct {
+	repository.CaptionRepositoryInterface
+
+	mu       sync.Mutex
+	captions []types.VideoCaption
+}
+
+func (r *fakeCaptionRepository) Save(_ context.Context, caption types.VideoCaption) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.captions = append(r.captions, caption)
+
+	return nil
+}
+
+func (r *fakeCaptionRepository) GetByHashName(_ context.Context, hashName string) ([]types.VideoCaption, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var captions []types.VideoCaption
+	for _, caption := range r.captions {
+		if caption.HashName == hashName {
+			captions = append(captions, caption)
+		}
+	}
+
+	return captions, nil
+}
+
+func (r *fakeCaptionRepository) DeleteByHashName(_ context.Context, hashName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	captions := r.captions[:0]
+	for _, caption := range r.captions {
+		if caption.HashName != hashName {
+			captions = append(captions, caption)
+		}
+	}
+	r.captions = captions
+
+	return nil
+}
+
+type fakeAudioTrackRepository struct {
+	repository.AudioTrackRepositoryInterface
+}
+
+func (r *fakeAudioTrackRepository) GetReadyByHashName(context.Context, string) ([]types.VideoAudioTrack, error) {
+	return nil, nil
+}
+
+func (r *fakeAudioTrackRepository) DeleteByHashName(context.Context, string) error {
+	return nil
+}
+
+type fakeVideoKeyRepository struct {
+	repository.VideoKeyRepositoryInterface
+}
+
+func (r *fakeVideoKeyRepository) DeleteByHashName(context.Context, string) error {
+	return nil
+}
+
+// fakeNotificationService passes every notification on to a channel
+type fakeNotificationService struct {
+	notifications chan types.Notification
+}
+
+func (s *fakeNotificationService) ProcessNotification(_ context.Context, notification types.Notification) error {
+	s.notifications <- notification
+	return nil
+}
+
+type fakeWS struct{}
+
+func (fakeWS) TriggerEvent(event.Event) error {
+	return nil
+}
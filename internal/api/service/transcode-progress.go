@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"go-fitness/external/logger/sl"
+	"go-fitness/internal/api/event"
+	"go-fitness/internal/api/types"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// transcodeProgressInterval and transcodeProgressStep throttle how often progress is persisted and pushed
+	transcodeProgressInterval = 2 * time.Second
+	transcodeProgressStep     = 1.0
+)
+
+// transcodeProgressReporter aggregates ffmpeg progress of every rendition of a job
+// into an overall percentage, stores it on the job and pushes it to the admins
+type transcodeProgressReporter struct {
+	s         *VideoService
+	job       types.TranscodeJob
+	videoUUID string
+
+	mu          sync.Mutex
+	progress    types.TranscodeProgress
+	lastFlushed time.Time
+	lastOverall float64
+}
+
+func (s *VideoService) newTranscodeProgressReporter(
+	job types.TranscodeJob,
+	videoUUID string,
+	labels []string,
+) *transcodeProgressReporter {
+	renditions := make(map[string]float64, len(labels))
+	for _, label := range labels {
+		renditions[label] = 0
+	}
+
+	return &transcodeProgressReporter{
+		s:         s,
+		job:       job,
+		videoUUID: videoUUID,
+		progress: types.TranscodeProgress{
+			Renditions: renditions,
+		},
+	}
+}
+
+// report is a method to update the percentage of a single rendition
+func (r *transcodeProgressReporter) report(label string, percent float64) {
+	percent = math.Max(0, math.Min(100, percent))
+
+	r.mu.Lock()
+	r.progress.Renditions[label] = percent
+
+	var total float64
+	for _, p := range r.progress.Renditions {
+		total += p
+	}
+	if len(r.progress.Renditions) > 0 {
+		r.progress.Overall = total / float64(len(r.progress.Renditions))
+	}
+	r.mu.Unlock()
+
+	r.flush(false)
+}
+
+// flush is a method to persist and publish the current progress, unless throttled
+func (r *transcodeProgressReporter) flush(force bool) {
+	const op string = "VideoService.transcodeProgressReporter.flush"
+
+	r.mu.Lock()
+	if !force &&
+		time.Since(r.lastFlushed) < transcodeProgressInterval &&
+		r.progress.Overall-r.lastOverall < transcodeProgressStep {
+		r.mu.Unlock()
+		return
+	}
+
+	progress := types.TranscodeProgress{
+		Overall:    r.progress.Overall,
+		Renditions: make(map[string]float64, len(r.progress.Renditions)),
+	}
+	for label, p := range r.progress.Renditions {
+		progress.Renditions[label] = p
+	}
+
+	r.lastFlushed = time.Now()
+	r.lastOverall = progress.Overall
+	r.mu.Unlock()
+
+	log := r.s.log.With(
+		sl.String("op", op),
+		sl.Int64("job_id", r.job.ID),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := r.s.transcodeJobRepo.UpdateProgress(ctx, r.job.ID, progress); err != nil {
+		log.Error("failed to update transcode progress", sl.Err(err))
+	}
+
+	go func() {
+		if err := r.s.event.TriggerEvent(event.NewTranscodeProgressEvent(r.videoUUID, r.job.ID, progress)); err != nil {
+			log.Error("failed to trigger transcode progress event", sl.Err(err))
+		}
+	}()
+}
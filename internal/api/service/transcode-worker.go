@@ -0,0 +1,665 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-fitness/external/logger/sl"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/repository"
+	"go-fitness/internal/api/types"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	TranscodeWorkerStateIdle       = "idle"
+	TranscodeWorkerStateBusy       = "busy"
+	TranscodeWorkerStateRestarting = "restarting"
+	TranscodeWorkerStateStopped    = "stopped"
+)
+
+var (
+	ErrTranscodeJobCancelled = errors.New("transcode job cancelled")
+	ErrTranscodeJobTimeout   = errors.New("transcode job timed out")
+	ErrTranscodeJobNotFound  = errors.New("transcode job not found")
+
+	ErrTranscodeJobNotCancellable = repository.ErrTranscodeJobNotCancellable
+
+	// errTranscodeWorkerShutdown interrupts running jobs when the drain timeout of a shutdown expires
+	errTranscodeWorkerShutdown = errors.New("transcode worker shutting down")
+)
+
+type TranscodeWorkerHealth struct {
+	WorkerID      int       `json:"worker_id"`
+	Owner         string    `json:"owner"`
+	State         string    `json:"state"`
+	JobID         int64     `json:"job_id,omitempty"`
+	Restarts      int       `json:"restarts"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	Healthy       bool      `json:"healthy"`
+}
+
+// transcodeWorkerPool keeps the health of every supervised worker
+type transcodeWorkerPool struct {
+	mu     sync.RWMutex
+	health map[int]*TranscodeWorkerHealth
+}
+
+func newTranscodeWorkerPool() *transcodeWorkerPool {
+	return &transcodeWorkerPool{
+		health: make(map[int]*TranscodeWorkerHealth),
+	}
+}
+
+func (p *transcodeWorkerPool) update(workerID int, fn func(h *TranscodeWorkerHealth)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h, ok := p.health[workerID]
+	if !ok {
+		h = &TranscodeWorkerHealth{WorkerID: workerID}
+		p.health[workerID] = h
+	}
+
+	fn(h)
+	h.LastHeartbeat = time.Now()
+}
+
+func (p *transcodeWorkerPool) snapshot() []TranscodeWorkerHealth {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result := make([]TranscodeWorkerHealth, 0, len(p.health))
+	for _, h := range p.health {
+		result = append(result, *h)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].WorkerID < result[j].WorkerID
+	})
+
+	return result
+}
+
+// WaitForTranscodeVideoSignals is a method to recover abandoned jobs and start the supervised transcode worker pool
+func (s *VideoService) WaitForTranscodeVideoSignals() {
+	const op string = "VideoService.WaitForTranscodeVideoSignals"
+
+	// Quanta of work to be done by each worker
+	workerCount := s.cfg.VideoService.TranscodeVideoWorkerCount
+
+	log := s.log.With(sl.String("op", op))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	requeued, err := s.transcodeJobRepo.RequeueExpired(ctx, s.cfg.VideoService.TranscodeMaxAttempts)
+	if err != nil {
+		log.Error("failed to requeue expired transcode jobs", sl.Err(err))
+	} else if requeued > 0 {
+		log.Info("requeued expired transcode jobs", sl.Int64("count", requeued))
+	}
+
+	log.Info("Initializing worker pool")
+
+	go s.superviseTranscodeWorkers(workerCount)
+}
+
+// StopTranscodeWorkers is a method to stop claiming new jobs and drain the running ones.
+// Jobs still running after TranscodeDrainTimeout are interrupted and handed back to the queue.
+func (s *VideoService) StopTranscodeWorkers(ctx context.Context) error {
+	const op string = "VideoService.StopTranscodeWorkers"
+
+	log := s.log.With(sl.String("op", op))
+
+	s.shutdownOnce.Do(func() {
+		close(s.shutdown)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.workerGroup.Wait()
+		close(done)
+	}()
+
+	drainCtx, cancel := context.WithTimeout(ctx, s.cfg.VideoService.TranscodeDrainTimeout)
+	defer cancel()
+
+	log.Info("draining transcode workers")
+
+	select {
+	case <-done:
+		log.Info("transcode workers drained")
+		return nil
+	case <-drainCtx.Done():
+	}
+
+	s.runningJobs.Range(func(key, value any) bool {
+		log.Warn("interrupting transcode job", sl.Int64("job_id", key.(int64)))
+		value.(context.CancelCauseFunc)(errTranscodeWorkerShutdown)
+		return true
+	})
+
+	select {
+	case <-done:
+		log.Info("transcode workers stopped")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to stop transcode workers: %w", ctx.Err())
+	}
+}
+
+// isShuttingDown is a method to report whether StopTranscodeWorkers has been called
+func (s *VideoService) isShuttingDown() bool {
+	select {
+	case <-s.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// ProcessGetTranscodeWorkersHealth is a method to report the health of the local transcode workers
+func (s *VideoService) ProcessGetTranscodeWorkersHealth() []TranscodeWorkerHealth {
+	workers := s.workers.snapshot()
+
+	// A worker reports at least once per poll interval while idle and once per lease heartbeat while busy
+	staleAfter := 3 * s.cfg.VideoService.TranscodePollInterval
+	if lease := s.cfg.VideoService.TranscodeLeaseDuration; lease > staleAfter {
+		staleAfter = lease
+	}
+
+	for i := range workers {
+		workers[i].Healthy = workers[i].State != TranscodeWorkerStateRestarting &&
+			workers[i].State != TranscodeWorkerStateStopped &&
+			time.Since(workers[i].LastHeartbeat) < staleAfter
+	}
+
+	return workers
+}
+
+// superviseTranscodeWorkers is a method to keep exactly workerCount workers running,
+// restarting any worker that exits
+func (s *VideoService) superviseTranscodeWorkers(workerCount int) {
+	const op string = "VideoService.superviseTranscodeWorkers"
+
+	log := s.log.With(sl.String("op", op))
+
+	exited := make(chan int, workerCount)
+
+	start := func(workerID int) {
+		s.workerGroup.Add(1)
+
+		go func() {
+			defer s.workerGroup.Done()
+
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error("worker panicked", sl.Int("workerID", workerID), sl.Any("panic", r), sl.String("stack", string(debug.Stack())))
+					s.workers.update(workerID, func(h *TranscodeWorkerHealth) {
+						h.LastError = fmt.Sprintf("panic: %v", r)
+					})
+				}
+				exited <- workerID
+			}()
+
+			s.transcodeVideoWorker(workerID)
+		}()
+	}
+
+	for i := 0; i < workerCount; i++ {
+		start(i)
+	}
+
+	for workerID := range exited {
+		if s.isShuttingDown() {
+			s.workers.update(workerID, func(h *TranscodeWorkerHealth) {
+				h.State = TranscodeWorkerStateStopped
+				h.JobID = 0
+			})
+			continue
+		}
+
+		s.workers.update(workerID, func(h *TranscodeWorkerHealth) {
+			h.State = TranscodeWorkerStateRestarting
+			h.JobID = 0
+			h.Restarts++
+		})
+
+		log.Warn("restarting worker", sl.Int("workerID", workerID))
+
+		time.Sleep(s.cfg.VideoService.TranscodeWorkerRestartDelay)
+		if s.isShuttingDown() {
+			continue
+		}
+		start(workerID)
+	}
+}
+
+// transcodeVideoWorker is a method to claim and transcode jobs from the job store
+func (s *VideoService) transcodeVideoWorker(workerID int) {
+	const op string = "VideoService.transcodeVideoWorker"
+
+	owner := transcodeWorkerOwner(workerID)
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.Int("workerID", workerID),
+		sl.String("owner", owner),
+	)
+
+	log.Info("Worker started")
+
+	ticker := time.NewTicker(s.cfg.VideoService.TranscodePollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.isShuttingDown() {
+			log.Info("Worker stopped")
+			return
+		}
+
+		s.workers.update(workerID, func(h *TranscodeWorkerHealth) {
+			h.Owner = owner
+			h.State = TranscodeWorkerStateIdle
+			h.JobID = 0
+		})
+
+		job, err := s.transcodeJobRepo.Claim(
+			context.Background(),
+			owner,
+			s.cfg.VideoService.TranscodeLeaseDuration,
+			s.cfg.VideoService.TranscodeMaxAttempts,
+		)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Error("failed to claim transcode job", sl.Err(err))
+			}
+
+			select {
+			case <-ticker.C:
+			case <-s.transcodeWakeup:
+			case <-s.shutdown:
+			}
+			continue
+		}
+
+		if job.State == enum.TranscodeJobStateFailed {
+			log.Error("transcode job lease expired on its final attempt", sl.Int64("job_id", job.ID), sl.Int("attempts", job.Attempts))
+			s.failTranscodeJob(context.Background(), job)
+			s.notifyTranscodeResult(context.Background(), repository.ErrTranscodeJobAttemptsExhausted)
+			continue
+		}
+
+		s.workers.update(workerID, func(h *TranscodeWorkerHealth) {
+			h.State = TranscodeWorkerStateBusy
+			h.JobID = job.ID
+		})
+
+		log.Info("Processing upload", sl.Int64("job_id", job.ID), sl.Int64("video_id", job.VideoID))
+		if err := s.runTranscodeJob(job, owner, workerID); err != nil {
+			log.Error("Failed to process upload", sl.Err(err))
+			s.workers.update(workerID, func(h *TranscodeWorkerHealth) {
+				h.LastError = err.Error()
+			})
+		}
+	}
+}
+
+// runTranscodeJob is a method to transcode a claimed job while keeping its lease alive.
+// The transcode runs under a context bounded by TranscodeJobTimeout that is also cancelled
+// by an admin cancellation, a lost lease or a shutdown.
+// A failed attempt is rescheduled with backoff until TranscodeMaxAttempts is reached,
+// only then the video is marked as failed and its source removed.
+func (s *VideoService) runTranscodeJob(job types.TranscodeJob, owner string, workerID int) error {
+	const op string = "VideoService.runTranscodeJob"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.Int64("job_id", job.ID),
+		sl.Int("attempt", job.Attempts),
+	)
+
+	ctx := context.Background()
+	startedAt := time.Now()
+
+	jobCtx, cancelJob := context.WithCancelCause(ctx)
+	defer cancelJob(nil)
+
+	s.runningJobs.Store(job.ID, cancelJob)
+	defer s.runningJobs.Delete(job.ID)
+
+	timeoutCtx, cancelTimeout := context.WithTimeoutCause(jobCtx, s.cfg.VideoService.TranscodeJobTimeout, ErrTranscodeJobTimeout)
+	defer cancelTimeout()
+
+	stopHeartbeat := s.startLeaseHeartbeat(job, owner, workerID, cancelJob)
+	err := s.safeProcessTranscode(timeoutCtx, job)
+	stopHeartbeat()
+
+	if err != nil && errors.Is(context.Cause(timeoutCtx), ErrTranscodeJobTimeout) {
+		err = fmt.Errorf("%w after %s: %w", ErrTranscodeJobTimeout, s.cfg.VideoService.TranscodeJobTimeout, err)
+	}
+
+	attempt := types.TranscodeJobAttempt{
+		JobID:      job.ID,
+		Attempt:    job.Attempts,
+		Worker:     owner,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	if recordErr := s.transcodeJobRepo.RecordAttempt(ctx, attempt); recordErr != nil {
+		log.Error("failed to record transcode job attempt", sl.Err(recordErr))
+	}
+
+	if err != nil {
+		switch cause := context.Cause(jobCtx); {
+		case errors.Is(cause, errTranscodeWorkerShutdown):
+			log.Warn("transcode job interrupted by shutdown, releasing it")
+
+			if releaseErr := s.transcodeJobRepo.Release(ctx, job.ID, owner); releaseErr != nil {
+				log.Error("failed to release transcode job", sl.Err(releaseErr))
+			}
+
+			return err
+		case errors.Is(cause, ErrTranscodeJobCancelled):
+			s.finishCancelledTranscodeJob(ctx, job)
+			return err
+		case errors.Is(cause, repository.ErrTranscodeJobLeaseLost):
+			current, getErr := s.transcodeJobRepo.GetByID(ctx, job.ID)
+			if getErr == nil && current.State == enum.TranscodeJobStateCancelled {
+				s.finishCancelledTranscodeJob(ctx, job)
+			} else {
+				log.Warn("transcode job lease lost, leaving it to its new owner")
+			}
+
+			return err
+		}
+	}
+
+	if err == nil {
+		if err := s.transcodeJobRepo.Complete(ctx, job.ID); err != nil {
+			log.Error("failed to mark transcode job as completed", sl.Err(err))
+		}
+
+		s.removeTranscodedSource(ctx, job)
+
+		s.notifyTranscodeResult(ctx, nil)
+
+		return nil
+	}
+
+	if job.Attempts < s.cfg.VideoService.TranscodeMaxAttempts {
+		availableAt := time.Now().Add(s.transcodeBackoff(job.Attempts))
+
+		log.Warn("transcode attempt failed, scheduling retry", sl.Err(err), sl.Any("available_at", availableAt))
+
+		if retryErr := s.transcodeJobRepo.Retry(ctx, job.ID, err.Error(), availableAt); retryErr != nil {
+			log.Error("failed to reschedule transcode job", sl.Err(retryErr))
+		}
+
+		return err
+	}
+
+	log.Error("transcode job failed after final attempt", sl.Err(err))
+
+	if failErr := s.transcodeJobRepo.Fail(ctx, job.ID, err.Error()); failErr != nil {
+		log.Error("failed to mark transcode job as failed", sl.Err(failErr))
+	}
+
+	s.failTranscodeJob(ctx, job)
+	s.notifyTranscodeResult(ctx, err)
+
+	return err
+}
+
+// removeTranscodedSource is a method to remove the source of a successful job. The source of a video is kept
+// for picking posters when KeepSource is set, only its copy in the working directory of a remote storage goes.
+func (s *VideoService) removeTranscodedSource(ctx context.Context, job types.TranscodeJob) {
+	const op string = "VideoService.removeTranscodedSource"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.Int64("job_id", job.ID),
+	)
+
+	keep := job.Kind != enum.TranscodeJobKindAudio && s.cfg.VideoService.Images.KeepSource
+
+	if !keep || s.remoteStorage() {
+		if err := os.Remove(job.DstPath); err != nil && !os.IsNotExist(err) {
+			log.Error("failed to remove source file after transcode", sl.Err(err))
+		}
+	}
+
+	if !keep {
+		if err := s.removeStored(ctx, filepath.Dir(job.DstPath), filepath.Base(job.DstPath)); err != nil {
+			log.Error("failed to remove stored source file after transcode", sl.Err(err))
+		}
+	}
+}
+
+// finishCancelledTranscodeJob is a method to mark the video or audio track of a cancelled job as failed and remove its source
+func (s *VideoService) finishCancelledTranscodeJob(ctx context.Context, job types.TranscodeJob) {
+	const op string = "VideoService.finishCancelledTranscodeJob"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.Int64("job_id", job.ID),
+	)
+
+	log.Info("transcode job cancelled")
+
+	s.failTranscodeJob(ctx, job)
+	s.notifyTranscodeResult(ctx, ErrTranscodeJobCancelled)
+}
+
+// failTranscodeJob is a method to give up on what a job was transcoding, depending on its kind
+func (s *VideoService) failTranscodeJob(ctx context.Context, job types.TranscodeJob) {
+	if job.Kind == enum.TranscodeJobKindAudio {
+		s.failAudioTrack(ctx, job)
+		return
+	}
+
+	s.failTranscodedVideo(ctx, job)
+}
+
+// failTranscodedVideo is a method to mark the video of a job, and the videos linked to it, as failed.
+// The hash directory is removed unless another video still uses its renditions,
+// in which case only the source file is removed.
+func (s *VideoService) failTranscodedVideo(ctx context.Context, job types.TranscodeJob) {
+	const op string = "VideoService.failTranscodedVideo"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.Int64("job_id", job.ID),
+	)
+
+	if err := s.videoRepo.UpdateStatus(ctx, job.VideoID, enum.VideoStatusFailed); err != nil {
+		log.Error("failed to update video status to failed", sl.Err(err))
+	}
+
+	if err := s.videoRepo.UpdateStatusByHashName(ctx, job.ChunkHash, enum.VideoStatusProcessing, enum.VideoStatusFailed); err != nil {
+		log.Error("failed to update linked videos status to failed", sl.Err(err))
+	}
+
+	s.forgetPlayableHash(job.ChunkHash)
+
+	references, err := s.videoRepo.CountByHashName(ctx, job.ChunkHash, job.VideoID)
+	if err != nil {
+		log.Error("failed to count videos using the renditions", sl.Err(err))
+		return
+	}
+
+	if references > 0 {
+		if err := os.Remove(job.DstPath); err != nil && !os.IsNotExist(err) {
+			log.Error("failed to remove source file", sl.Err(err))
+		}
+
+		if err := s.removeStored(ctx, filepath.Dir(job.DstPath), filepath.Base(job.DstPath)); err != nil {
+			log.Error("failed to remove stored source file", sl.Err(err))
+		}
+		return
+	}
+
+	if err := os.RemoveAll(job.UploadPath); err != nil {
+		log.Error("failed to remove folder", sl.Err(err))
+	}
+
+	if err := s.removeStored(ctx, job.UploadPath); err != nil {
+		log.Error("failed to remove stored folder", sl.Err(err))
+	}
+
+	if err := s.mediaInfoRepo.DeleteByHashName(ctx, job.ChunkHash); err != nil {
+		log.Error("failed to delete media info", sl.Err(err))
+	}
+
+	if err := s.captionRepo.DeleteByHashName(ctx, job.ChunkHash); err != nil {
+		log.Error("failed to delete captions", sl.Err(err))
+	}
+
+	if err := s.audioTrackRepo.DeleteByHashName(ctx, job.ChunkHash); err != nil {
+		log.Error("failed to delete audio tracks", sl.Err(err))
+	}
+
+	if err := s.videoKeyRepo.DeleteByHashName(ctx, job.ChunkHash); err != nil {
+		log.Error("failed to delete video keys", sl.Err(err))
+	}
+}
+
+// safeProcessTranscode is a method to run processTranscode or processAudioTranscode depending on the kind of the job,
+// turning a panic into a regular attempt error
+func (s *VideoService) safeProcessTranscode(ctx context.Context, job types.TranscodeJob) (err error) {
+	const op string = "VideoService.safeProcessTranscode"
+
+	defer func() {
+		if r := recover(); r != nil {
+			s.log.Error("transcode panicked",
+				sl.String("op", op),
+				sl.Int64("job_id", job.ID),
+				sl.Any("panic", r),
+				sl.String("stack", string(debug.Stack())),
+			)
+			err = fmt.Errorf("transcode panicked: %v", r)
+		}
+	}()
+
+	if job.Kind == enum.TranscodeJobKindAudio {
+		return s.processAudioTranscode(ctx, job)
+	}
+
+	return s.processTranscode(ctx, job)
+}
+
+// notifyTranscodeResult is a method to notify admins about the final outcome of a transcode job
+func (s *VideoService) notifyTranscodeResult(ctx context.Context, transcodeErr error) {
+	const op string = "VideoService.notifyTranscodeResult"
+
+	log := s.log.With(
+		sl.String("op", op),
+	)
+
+	//TODO translate to romanian
+	notification := types.Notification{
+		Name:   "Upload Status",
+		Body:   "The upload was successful.",
+		Status: enum.NotificationStatusSuccess,
+		IsRead: false,
+	}
+
+	if transcodeErr != nil {
+		notification.Body = "The upload has failed: " + transcodeErr.Error()
+		notification.Status = enum.NotificationStatusError
+	}
+
+	if err := s.notificationService.ProcessNotification(ctx, notification); err != nil {
+		log.Error("failed to send notification", sl.Err(err))
+	}
+}
+
+// transcodeBackoff is a method to compute the delay before the next attempt:
+// base * 2^(attempt-1), capped at TranscodeBackoffMax, spread by +/- TranscodeBackoffJitter
+func (s *VideoService) transcodeBackoff(attempt int) time.Duration {
+	cfg := s.cfg.VideoService
+
+	backoff := float64(cfg.TranscodeBackoffBase) * math.Pow(2, float64(attempt-1))
+	if max := float64(cfg.TranscodeBackoffMax); max > 0 && backoff > max {
+		backoff = max
+	}
+
+	backoff *= 1 + cfg.TranscodeBackoffJitter*(2*rand.Float64()-1)
+
+	return time.Duration(backoff)
+}
+
+// startLeaseHeartbeat is a method to periodically extend the lease of a running job.
+// The job is cancelled as soon as its lease is lost, either because it was cancelled
+// or because another worker reclaimed it.
+func (s *VideoService) startLeaseHeartbeat(
+	job types.TranscodeJob,
+	owner string,
+	workerID int,
+	cancelJob context.CancelCauseFunc,
+) func() {
+	const op string = "VideoService.startLeaseHeartbeat"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.Int64("job_id", job.ID),
+	)
+
+	lease := s.cfg.VideoService.TranscodeLeaseDuration
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				err := s.transcodeJobRepo.ExtendLease(ctx, job.ID, owner, lease)
+				cancel()
+
+				if errors.Is(err, repository.ErrTranscodeJobLeaseLost) {
+					log.Warn("transcode job lease lost, stopping the job")
+					cancelJob(repository.ErrTranscodeJobLeaseLost)
+					return
+				}
+				if err != nil {
+					log.Error("failed to extend transcode job lease", sl.Err(err))
+				}
+
+				s.workers.update(workerID, func(h *TranscodeWorkerHealth) {})
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// transcodeWorkerOwner builds a lease owner identifier that is unique across API nodes
+func transcodeWorkerOwner(workerID int) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), workerID)
+}
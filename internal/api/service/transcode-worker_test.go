@@ -0,0 +1,485 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ilyakaznacheev/cleanenv"
+	"go-fitness/external/config"
+	"go-fitness/external/storage"
+	"go-fitness/internal/api/data"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/types"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestConfig returns the default config with a storage path of its own and a worker polling often
+func newTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	var cfg config.Config
+	if err := cleanenv.ReadEnv(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.HTTPServer.StoragePath = t.TempDir()
+	cfg.Storage.Driver = config.StorageDriverLocal
+	cfg.VideoService.TranscodeVideoWorkerCount = 1
+	cfg.VideoService.TranscodePollInterval = 10 * time.Millisecond
+	cfg.VideoService.DASH = false
+	cfg.VideoService.Encryption.Enabled = false
+	cfg.VideoService.SignedURLs.Secret = ""
+
+	return &cfg
+}
+
+// fakeSource is an upload sniffed as MP4, the fake prober describes it as a 30s 1080p video
+func fakeSource() []byte {
+	source := []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isomiso2")
+	return append(source, bytes.Repeat([]byte{0}, 1024)...)
+}
+
+// testVideoService is a video service backed by the fakes, with its repositories at hand
+type testVideoService struct {
+	*VideoService
+
+	videos        *fakeVideoRepository
+	jobs          *fakeTranscodeJobRepository
+	captions      *fakeCaptionRepository
+	notifications *fakeNotificationService
+}
+
+// newTestVideoService returns a video service using the transcoder, the workers are not started
+func newTestVideoService(cfg *config.Config, transcoder TranscoderInterface) *testVideoService {
+	jobs := &fakeTranscodeJobRepository{}
+	videos := &fakeVideoRepository{jobRepo: jobs, mediaInfoRepo: &fakeMediaInfoRepository{}}
+	captions := &fakeCaptionRepository{}
+	notifications := &fakeNotificationService{notifications: make(chan types.Notification, 8)}
+
+	return &testVideoService{
+		VideoService: NewVideoService(
+			slog.New(slog.NewTextHandler(io.Discard, nil)),
+			cfg,
+			notifications,
+			fakeWS{},
+			videos,
+			jobs,
+			videos.mediaInfoRepo,
+			captions,
+			&fakeAudioTrackRepository{},
+			&fakeVideoKeyRepository{},
+			storage.NewLocal(cfg.HTTPServer.StoragePath),
+			transcoder,
+			NewFakeProber(),
+		),
+		videos:        videos,
+		jobs:          jobs,
+		captions:      captions,
+		notifications: notifications,
+	}
+}
+
+// startWorkers starts the transcode workers until the end of the test
+func (s *testVideoService) startWorkers(t *testing.T) {
+	t.Helper()
+
+	s.WaitForTranscodeVideoSignals()
+	t.Cleanup(func() {
+		if err := s.StopTranscodeWorkers(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// upload processes the fake source as an upload
+func (s *testVideoService) upload(t *testing.T) {
+	t.Helper()
+
+	err := s.ProcessUpload(context.Background(), data.VideoUploadData{
+		File:     bytes.NewReader(fakeSource()),
+		Filename: "clip.mp4",
+		Name:     "Clip",
+	})
+	if err != nil {
+		t.Fatalf("ProcessUpload: %v", err)
+	}
+}
+
+// waitForNotification returns the notification sent once the transcode job is done
+func (s *testVideoService) waitForNotification(t *testing.T) types.Notification {
+	t.Helper()
+
+	select {
+	case notification := <-s.notifications.notifications:
+		return notification
+	case <-time.After(10 * time.Second):
+		t.Fatal("the worker did not finish the transcode job")
+		return types.Notification{}
+	}
+}
+
+func TestProcessUploadIsTranscodedByTheWorker(t *testing.T) {
+	cfg := newTestConfig(t)
+	transcoder := NewFakeTranscoder()
+
+	s := newTestVideoService(cfg, transcoder)
+	s.startWorkers(t)
+	s.upload(t)
+
+	if notification := s.waitForNotification(t); notification.Status != enum.NotificationStatusSuccess {
+		t.Fatalf("transcode failed: %s", notification.Body)
+	}
+
+	ctx := context.Background()
+
+	video, err := s.videos.GetByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Status != enum.VideoStatusProcessed {
+		t.Fatalf("video status = %s, want processed", video.Status)
+	}
+
+	job, err := s.jobs.GetByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != enum.TranscodeJobStateCompleted || job.Attempts != 1 {
+		t.Errorf("job state = %s after %d attempts, want completed after 1", job.State, job.Attempts)
+	}
+
+	hashPath := filepath.Join(cfg.HTTPServer.StoragePath, cfg.VideoService.VideoPath, video.HashName)
+
+	renditions, err := readRenditions(hashPath)
+	if err != nil {
+		t.Fatalf("renditions.json: %v", err)
+	}
+
+	var labels []string
+	for _, rendition := range renditions {
+		labels = append(labels, rendition.Label)
+		if rendition.Bandwidth <= 0 || rendition.Width <= 0 || rendition.Height <= 0 {
+			t.Errorf("rendition %s was not measured: %+v", rendition.Label, rendition)
+		}
+	}
+	if want := "[360 480 720 1080]"; fmt.Sprint(labels) != want {
+		t.Fatalf("renditions = %v, want %s", labels, want)
+	}
+
+	segments := int(transcoder.Duration) / cfg.VideoService.HLSTime
+
+	for _, rendition := range renditions {
+		playlist, err := readMediaPlaylist(filepath.Join(hashPath, rendition.Label+".m3u8"))
+		if err != nil {
+			t.Fatalf("playlist of %s: %v", rendition.Label, err)
+		}
+		if len(playlist.Segments) != segments {
+			t.Errorf("playlist of %s has %d segments, want %d", rendition.Label, len(playlist.Segments), segments)
+		}
+		for _, segment := range playlist.Segments {
+			if _, err := os.Stat(filepath.Join(hashPath, segment.URI)); err != nil {
+				t.Errorf("segment of %s: %v", rendition.Label, err)
+			}
+		}
+	}
+
+	master, err := s.ProcessGetVideoPlayListByUUID(ctx, video.UUID, data.PlaylistAccess{})
+	if err != nil {
+		t.Fatalf("master playlist: %v", err)
+	}
+	content, err := io.ReadAll(master.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(content), "#EXT-X-STREAM-INF:"); got != len(renditions) {
+		t.Errorf("master playlist lists %d variants, want %d", got, len(renditions))
+	}
+	for _, label := range labels {
+		if !strings.Contains(string(content), "\n"+video.UUID+"/"+label+".m3u8\n") {
+			t.Errorf("master playlist lacks the variant %s:\n%s", label, content)
+		}
+	}
+
+	for _, name := range []string{posterFilename, "thumb-000.jpg", "storyboard-000.jpg", storyboardVTT} {
+		if _, err := os.Stat(filepath.Join(hashPath, imagesDir, name)); err != nil {
+			t.Errorf("image %s: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(job.DstPath); err != nil {
+		t.Errorf("the source is not kept for posters: %v", err)
+	}
+}
+
+func TestProcessUploadRemovesTheSourceWhenTheVideoIsNotCreated(t *testing.T) {
+	cfg := newTestConfig(t)
+
+	s := newTestVideoService(cfg, NewFakeTranscoder())
+	s.videos.mediaInfoRepo.err = errors.New("connection lost")
+
+	err := s.ProcessUpload(context.Background(), data.VideoUploadData{
+		File:     bytes.NewReader(fakeSource()),
+		Filename: "clip.mp4",
+		Name:     "Clip",
+	})
+	if err == nil {
+		t.Fatal("ProcessUpload succeeded, want an error")
+	}
+	if len(s.videos.videos) > 0 || len(s.jobs.jobs) > 0 {
+		t.Errorf("%d videos and %d jobs were created", len(s.videos.videos), len(s.jobs.jobs))
+	}
+
+	entries, err := os.ReadDir(filepath.Join(cfg.HTTPServer.StoragePath, cfg.VideoService.VideoPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != ".incoming" {
+			t.Errorf("%s was left in the video path", entry.Name())
+			continue
+		}
+
+		incoming, err := os.ReadDir(filepath.Join(cfg.HTTPServer.StoragePath, cfg.VideoService.VideoPath, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(incoming) > 0 {
+			t.Errorf("%d uploads were left in .incoming", len(incoming))
+		}
+	}
+}
+
+func TestFailingTranscodeIsRetriedUntilMaxAttempts(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.VideoService.TranscodeMaxAttempts = 3
+	cfg.VideoService.TranscodeBackoffBase = 50 * time.Millisecond
+	cfg.VideoService.TranscodeBackoffJitter = 0
+
+	transcoder := NewFakeTranscoder()
+	transcoder.Err = errors.New("ffmpeg killed")
+
+	s := newTestVideoService(cfg, transcoder)
+	s.startWorkers(t)
+	s.upload(t)
+
+	notification := s.waitForNotification(t)
+	if notification.Status != enum.NotificationStatusError || !strings.Contains(notification.Body, transcoder.Err.Error()) {
+		t.Errorf("notification = %s %q, want an error reporting %q", notification.Status, notification.Body, transcoder.Err)
+	}
+
+	ctx := context.Background()
+
+	job, err := s.jobs.GetByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != enum.TranscodeJobStateFailed || job.Attempts != cfg.VideoService.TranscodeMaxAttempts {
+		t.Errorf("job state = %s after %d attempts, want failed after %d", job.State, job.Attempts, cfg.VideoService.TranscodeMaxAttempts)
+	}
+	if !strings.Contains(job.LastError, transcoder.Err.Error()) {
+		t.Errorf("job last error = %q, want %q", job.LastError, transcoder.Err)
+	}
+
+	s.jobs.mu.Lock()
+	attempts := append([]types.TranscodeJobAttempt(nil), s.jobs.attempts...)
+	s.jobs.mu.Unlock()
+
+	if len(attempts) != cfg.VideoService.TranscodeMaxAttempts {
+		t.Fatalf("%d attempts were recorded, want %d", len(attempts), cfg.VideoService.TranscodeMaxAttempts)
+	}
+	for i, attempt := range attempts {
+		// The source is probed on every attempt, so it was kept until the final one
+		if attempt.JobID != job.ID || attempt.Attempt != i+1 || !strings.Contains(attempt.Error, transcoder.Err.Error()) {
+			t.Errorf("attempt %d = %+v, want attempt %d of job %d failing with %q", i, attempt, i+1, job.ID, transcoder.Err)
+		}
+
+		if i == 0 {
+			continue
+		}
+		// base * 2^(attempt-1) without jitter
+		backoff := cfg.VideoService.TranscodeBackoffBase << (i - 1)
+		if waited := attempt.StartedAt.Sub(attempts[i-1].FinishedAt); waited < backoff {
+			t.Errorf("attempt %d started %s after the previous one, want at least %s", i+1, waited, backoff)
+		}
+	}
+
+	video, err := s.videos.GetByID(ctx, job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Status != enum.VideoStatusFailed {
+		t.Errorf("video status = %s, want failed", video.Status)
+	}
+
+	if _, err := os.Stat(job.UploadPath); !os.IsNotExist(err) {
+		t.Errorf("the hash directory was kept after the final attempt: %v", err)
+	}
+}
+
+func TestClaimOfAnExpiredLease(t *testing.T) {
+	tests := []struct {
+		name         string
+		attempts     int
+		wantStatus   enum.NotificationStatus
+		wantJob      enum.TranscodeJobState
+		wantVideo    enum.VideoStatus
+		wantAttempts int
+	}{
+		{
+			name:         "before the final attempt",
+			attempts:     1,
+			wantStatus:   enum.NotificationStatusSuccess,
+			wantJob:      enum.TranscodeJobStateCompleted,
+			wantVideo:    enum.VideoStatusProcessed,
+			wantAttempts: 1,
+		},
+		{
+			name:         "on the final attempt",
+			attempts:     3,
+			wantStatus:   enum.NotificationStatusError,
+			wantJob:      enum.TranscodeJobStateFailed,
+			wantVideo:    enum.VideoStatusFailed,
+			wantAttempts: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.VideoService.TranscodeMaxAttempts = 3
+
+			s := newTestVideoService(cfg, NewFakeTranscoder())
+			s.upload(t)
+
+			// The worker running the job was killed and its lease expired
+			err := s.jobs.update(1, func(job *types.TranscodeJob) {
+				expiredAt := time.Now().Add(-time.Minute)
+				job.State = enum.TranscodeJobStateRunning
+				job.Attempts = tt.attempts
+				job.LeaseOwner = "killed:0"
+				job.LeaseExpiresAt = &expiredAt
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s.startWorkers(t)
+
+			notification := s.waitForNotification(t)
+			if notification.Status != tt.wantStatus {
+				t.Errorf("notification = %s %q, want %s", notification.Status, notification.Body, tt.wantStatus)
+			}
+
+			ctx := context.Background()
+
+			job, err := s.jobs.GetByID(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.State != tt.wantJob {
+				t.Errorf("job state = %s, want %s", job.State, tt.wantJob)
+			}
+
+			s.jobs.mu.Lock()
+			attempts := len(s.jobs.attempts)
+			s.jobs.mu.Unlock()
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("%d attempts were recorded, want %d", attempts, tt.wantAttempts)
+			}
+
+			video, err := s.videos.GetByID(ctx, job.VideoID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if video.Status != tt.wantVideo {
+				t.Errorf("video status = %s, want %s", video.Status, tt.wantVideo)
+			}
+
+			if _, err := os.Stat(job.UploadPath); (tt.wantVideo == enum.VideoStatusFailed) != os.IsNotExist(err) {
+				t.Errorf("hash directory of a %s video: %v", tt.wantVideo, err)
+			}
+		})
+	}
+}
+
+// waitForWorker returns the health of the only worker once it satisfies ready
+func (s *testVideoService) waitForWorker(t *testing.T, ready func(health TranscodeWorkerHealth) bool) TranscodeWorkerHealth {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		workers := s.ProcessGetTranscodeWorkersHealth()
+		if len(workers) == 1 && ready(workers[0]) {
+			return workers[0]
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("the worker did not reach the expected state: %+v", workers)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPanickingTranscodeFailsTheAttempt(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.VideoService.TranscodeMaxAttempts = 1
+
+	transcoder := NewFakeTranscoder()
+	transcoder.Panic = "nil map"
+
+	s := newTestVideoService(cfg, transcoder)
+	s.startWorkers(t)
+	s.upload(t)
+
+	notification := s.waitForNotification(t)
+	if notification.Status != enum.NotificationStatusError || !strings.Contains(notification.Body, "transcode panicked: nil map") {
+		t.Errorf("notification = %s %q, want an error reporting the panic", notification.Status, notification.Body)
+	}
+
+	job, err := s.jobs.GetByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != enum.TranscodeJobStateFailed {
+		t.Errorf("job state = %s, want failed", job.State)
+	}
+
+	// The panic is recovered as an attempt error, the worker goes on without being restarted
+	health := s.waitForWorker(t, func(health TranscodeWorkerHealth) bool {
+		return health.LastError != "" && health.State == TranscodeWorkerStateIdle
+	})
+	if health.Restarts != 0 || !health.Healthy || !strings.Contains(health.LastError, "transcode panicked: nil map") {
+		t.Errorf("worker health = %+v, want a healthy worker reporting the panic without restarts", health)
+	}
+}
+
+func TestPanickingWorkerIsRestarted(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.VideoService.TranscodeWorkerRestartDelay = 10 * time.Millisecond
+
+	s := newTestVideoService(cfg, NewFakeTranscoder())
+	s.jobs.claimPanics = 1
+	s.startWorkers(t)
+
+	health := s.waitForWorker(t, func(health TranscodeWorkerHealth) bool {
+		return health.Restarts > 0 && health.State == TranscodeWorkerStateIdle
+	})
+	if health.Restarts != 1 || !health.Healthy || health.LastError != "panic: claim panicked" {
+		t.Errorf("worker health = %+v, want a healthy worker restarted once after the panic", health)
+	}
+
+	// The restarted worker transcodes as usual
+	s.upload(t)
+
+	if notification := s.waitForNotification(t); notification.Status != enum.NotificationStatusSuccess {
+		t.Fatalf("transcode failed: %s", notification.Body)
+	}
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go-fitness/internal/api/types"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// FakeTranscoder is an in-process TranscoderInterface writing deterministic playlists and segments.
+// It lets the upload-to-processed pipeline run without ffmpeg installed.
+type FakeTranscoder struct {
+	// Duration is the length of the produced renditions in seconds
+	Duration float64
+	// SegmentSize is the size of every produced segment in bytes
+	SegmentSize int
+	// Err, when set, is returned instead of producing any output
+	Err error
+	// Panic, when set, is raised by TranscodeHLS instead of producing any output
+	Panic any
+}
+
+func NewFakeTranscoder() *FakeTranscoder {
+	return &FakeTranscoder{
+		Duration:    30,
+		SegmentSize: 4096,
+	}
+}
+
+// TranscodeHLS writes ceil(Duration / HLSTime) segments filled with the rendition label
+// and the media playlist referencing them, preceded by an initialization segment for fMP4
+func (t *FakeTranscoder) TranscodeHLS(ctx context.Context, req TranscodeRequest, onProgress func(outTime float64)) error {
+	if t.Panic != nil {
+		panic(t.Panic)
+	}
+	if t.Err != nil {
+		return t.Err
+	}
+
+	hlsTime := float64(req.HLSTime)
+	if hlsTime <= 0 {
+		hlsTime = 10
+	}
+
+	count := int(math.Ceil(t.Duration / hlsTime))
+	content := bytes.Repeat([]byte(req.Rendition.Label), t.SegmentSize/max(len(req.Rendition.Label), 1)+1)[:t.SegmentSize]
+
+	version := 3
+	if req.SegmentFormat == SegmentFormatFMP4 {
+		version = 7
+	}
+
+	var playlist bytes.Buffer
+	playlist.WriteString("#EXTM3U\n")
+	playlist.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", version))
+	playlist.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(hlsTime)))
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+
+	if req.SegmentFormat == SegmentFormatFMP4 {
+		initPath := filepath.Join(filepath.Dir(req.PlaylistPath), req.InitFilename)
+		if err := os.WriteFile(initPath, content[:min(len(content), 64)], 0644); err != nil {
+			return err
+		}
+
+		playlist.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", req.InitFilename))
+	}
+
+	var outTime float64
+	for i := 0; i < count; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		duration := math.Min(hlsTime, t.Duration-outTime)
+		segmentPath := fmt.Sprintf(req.SegmentPattern, i)
+
+		if err := os.WriteFile(segmentPath, content, 0644); err != nil {
+			return err
+		}
+
+		playlist.WriteString(fmt.Sprintf("#EXTINF:%f,\n", duration))
+		playlist.WriteString(filepath.Base(segmentPath) + "\n")
+
+		outTime += duration
+		if onProgress != nil {
+			onProgress(outTime)
+		}
+	}
+
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	return os.WriteFile(req.PlaylistPath, playlist.Bytes(), 0644)
+}
+
+// TranscodeAudioHLS writes the same segments and playlist as TranscodeHLS, labelled "audio"
+func (t *FakeTranscoder) TranscodeAudioHLS(ctx context.Context, req TranscodeRequest, onProgress func(outTime float64)) error {
+	req.Rendition = Rendition{Label: "audio"}
+	return t.TranscodeHLS(ctx, req, onProgress)
+}
+
+// ExtractPoster writes a blank image of the requested size
+func (t *FakeTranscoder) ExtractPoster(ctx context.Context, req FrameRequest) error {
+	if t.Err != nil {
+		return t.Err
+	}
+
+	return writeBlankJPEG(req.OutputPath, req.Width, req.Height)
+}
+
+// ExtractFrames writes ceil((Duration - Offset) / Interval) frames as blank images,
+// or as blank sprite sheets of Columns x Rows frames each when tiled
+func (t *FakeTranscoder) ExtractFrames(ctx context.Context, req FrameRequest) error {
+	if t.Err != nil {
+		return t.Err
+	}
+
+	if req.Interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %f", req.Interval)
+	}
+
+	count, width, height := int(math.Ceil((t.Duration-req.Offset)/req.Interval)), req.Width, req.Height
+	if req.Columns > 0 && req.Rows > 0 {
+		perSheet := req.Columns * req.Rows
+		count = (count + perSheet - 1) / perSheet
+		width, height = width*req.Columns, height*req.Rows
+	}
+
+	for i := 0; i < count; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := writeBlankJPEG(fmt.Sprintf(req.OutputPath, i), width, height); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeBlankJPEG(path string, width int, height int) error {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// FakeProber is an in-process ProberInterface returning the same media info for every file
+type FakeProber struct {
+	Info types.MediaInfo
+	Err  error
+}
+
+func NewFakeProber() *FakeProber {
+	return &FakeProber{
+		Info: types.MediaInfo{
+			Container:     "mov,mp4,m4a,3gp,3g2,mj2",
+			Duration:      30,
+			VideoCodec:    "h264",
+			VideoProfile:  "High",
+			VideoLevel:    40,
+			Width:         1920,
+			Height:        1080,
+			FrameRate:     30,
+			AudioCodec:    "aac",
+			AudioProfile:  "LC",
+			AudioChannels: 2,
+		},
+	}
+}
+
+func (p *FakeProber) Probe(ctx context.Context, filePath string) (types.MediaInfo, error) {
+	if p.Err != nil {
+		return types.MediaInfo{}, p.Err
+	}
+
+	if _, err := os.Stat(filePath); err != nil {
+		return types.MediaInfo{}, err
+	}
+
+	return p.Info, nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go-fitness/external/config"
+	"go-fitness/internal/api/types"
+)
+
+const (
+	// SegmentFormatTS writes MPEG-TS segments
+	SegmentFormatTS = "ts"
+	// SegmentFormatFMP4 writes an fMP4 initialization segment and CMAF media segments, playable over HLS and DASH
+	SegmentFormatFMP4 = "fmp4"
+)
+
+// TranscodeRequest describes the encode of a single HLS rendition
+type TranscodeRequest struct {
+	InputPath string
+	// PlaylistPath is the media playlist to write, SegmentPattern the printf pattern of its segments
+	PlaylistPath   string
+	SegmentPattern string
+	// SegmentFormat defaults to SegmentFormatTS, InitFilename names the initialization segment of SegmentFormatFMP4
+	SegmentFormat string
+	InitFilename  string
+	// NoAudio leaves the audio out of a video rendition, when it is packaged as its own track
+	NoAudio   bool
+	Rendition Rendition
+	Profile   config.EncodingProfile
+	HLSTime   int
+}
+
+// FrameRequest describes still images extracted from a source, scaled to Width x Height
+type FrameRequest struct {
+	InputPath string
+	// OutputPath is the image to write, or the printf pattern of the images when more than one is written
+	OutputPath string
+	// Offset is the position in seconds of the first frame considered
+	Offset float64
+	// BestFrame picks the most representative frame after Offset instead of the frame at Offset
+	BestFrame bool
+	// Interval is the distance in seconds between extracted frames
+	Interval float64
+	Width    int
+	Height   int
+	// Columns and Rows tile the extracted frames into sprite sheets when set
+	Columns int
+	Rows    int
+}
+
+// TranscoderInterface encodes a source into HLS renditions and extracts still images from it.
+// onProgress receives the encoded position in seconds.
+type TranscoderInterface interface {
+	TranscodeHLS(ctx context.Context, req TranscodeRequest, onProgress func(outTime float64)) error
+	// TranscodeAudioHLS encodes the first audio stream of req.InputPath into an audio only rendition,
+	// only the audio settings of req.Profile are used
+	TranscodeAudioHLS(ctx context.Context, req TranscodeRequest, onProgress func(outTime float64)) error
+	// ExtractPoster writes a single frame to req.OutputPath
+	ExtractPoster(ctx context.Context, req FrameRequest) error
+	// ExtractFrames writes a frame every req.Interval seconds, tiled when req.Columns and req.Rows are set
+	ExtractFrames(ctx context.Context, req FrameRequest) error
+}
+
+// ErrUnreadableMedia is returned by Probe when the prober ran but could not read the file as media
+var ErrUnreadableMedia = errors.New("unreadable media file")
+
+// ProberInterface inspects media files
+type ProberInterface interface {
+	Probe(ctx context.Context, filePath string) (types.MediaInfo, error)
+}
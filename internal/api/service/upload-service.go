@@ -0,0 +1,433 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-fitness/external/config"
+	"go-fitness/external/logger/sl"
+	"go-fitness/internal/api/data"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/repository"
+	"go-fitness/internal/api/types"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadTooLarge       = errors.New("upload exceeds maximum size")
+	ErrUploadLocked         = errors.New("upload is locked by another request")
+	ErrUploadClosed         = errors.New("upload is already closed")
+	ErrUploadMisdirected    = errors.New("upload is held by another node")
+)
+
+type UploadService struct {
+	log          *slog.Logger
+	cfg          *config.Config
+	uploadRepo   repository.UploadRepositoryInterface
+	videoService VideoServiceInterface
+
+	// node is the node recorded on the uploads created here, whose partial files only this storage path holds
+	node string
+
+	// locks holds the uuid of every upload a request or a finish is working on, guarding it against concurrent requests
+	locks sync.Map
+	// finishing tracks the uploads being handed over in the background
+	finishing sync.WaitGroup
+}
+
+type UploadServiceInterface interface {
+	ProcessCreateUpload(context.Context, data.UploadCreateData) (types.Upload, error)
+	ProcessGetUpload(context.Context, string) (types.Upload, error)
+	ProcessAppendUpload(context.Context, string, int64, io.Reader) (types.Upload, error)
+	ProcessTerminateUpload(context.Context, string) error
+	WaitForFinishingUploads(context.Context) error
+}
+
+func NewUploadService(
+	log *slog.Logger,
+	cfg *config.Config,
+	uploadRepo repository.UploadRepositoryInterface,
+	videoService VideoServiceInterface,
+) *UploadService {
+	return &UploadService{
+		log:          log,
+		cfg:          cfg,
+		uploadRepo:   uploadRepo,
+		videoService: videoService,
+		node:         uploadNode(cfg),
+	}
+}
+
+// ProcessCreateUpload is a method to register a new resumable upload and reserve its partial file
+func (s *UploadService) ProcessCreateUpload(ctx context.Context, data data.UploadCreateData) (types.Upload, error) {
+	const op string = "UploadService.ProcessCreateUpload"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("filename", data.Filename),
+		sl.Int64("size", data.Size),
+	)
+
+	if data.Size > s.cfg.UploadService.MaxSize {
+		log.Warn("upload exceeds maximum size")
+		return types.Upload{}, ErrUploadTooLarge
+	}
+
+	if err := os.MkdirAll(s.uploadDir(), 0755); err != nil {
+		log.Error("failed to create upload directory", sl.Err(err))
+		return types.Upload{}, errors.New("failed to create upload directory")
+	}
+
+	upload, err := s.uploadRepo.Create(ctx, types.Upload{
+		Filename:    filepath.Base(data.Filename),
+		Name:        data.Name,
+		Description: data.Description,
+		Size:        data.Size,
+		Status:      enum.UploadStatusInProgress,
+		Node:        s.node,
+	})
+	if err != nil {
+		log.Error("failed to create upload", sl.Err(err))
+		return types.Upload{}, errors.New("failed to create upload")
+	}
+
+	file, err := os.Create(s.partialPath(upload.UUID))
+	if err != nil {
+		log.Error("failed to create partial file", sl.Err(err))
+		return types.Upload{}, errors.New("failed to create partial file")
+	}
+	if err := file.Close(); err != nil {
+		log.Error("failed to close partial file", sl.Err(err))
+	}
+
+	return upload, nil
+}
+
+// ProcessGetUpload is a method to get the current state of a resumable upload
+func (s *UploadService) ProcessGetUpload(ctx context.Context, uuid string) (types.Upload, error) {
+	const op string = "UploadService.ProcessGetUpload"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+	)
+
+	upload, err := s.uploadRepo.GetByUUID(ctx, uuid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return types.Upload{}, ErrUploadNotFound
+		}
+		log.Error("failed to get upload by uuid", sl.Err(err))
+		return types.Upload{}, errors.New("failed to get upload by uuid")
+	}
+
+	return upload, nil
+}
+
+// ProcessAppendUpload is a method to append a chunk at the given offset to a resumable upload.
+// Once the last byte is persisted the upload is marked as finishing and handed over to VideoService.ProcessUpload
+// in the background, which hashes, probes and stores the whole file, so the request does not wait for it.
+// The finish holds the lock of the upload until it is done, requests meanwhile get ErrUploadLocked.
+// A rejected file fails the upload, any other failure puts it back in progress at its final offset,
+// so an empty PATCH at that offset retries the hand over. So does one for an upload left finishing
+// by a restart, as no finish holds its lock anymore.
+// An upload created on another node is ErrUploadMisdirected, its partial file is not here.
+func (s *UploadService) ProcessAppendUpload(
+	ctx context.Context,
+	uuid string,
+	offset int64,
+	chunk io.Reader,
+) (types.Upload, error) {
+	const op string = "UploadService.ProcessAppendUpload"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+		sl.Int64("offset", offset),
+	)
+
+	unlock, ok := s.lock(uuid)
+	if !ok {
+		return types.Upload{}, ErrUploadLocked
+	}
+
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			unlock()
+		}
+	}()
+
+	upload, err := s.ProcessGetUpload(ctx, uuid)
+	if err != nil {
+		return types.Upload{}, err
+	}
+
+	if !s.holds(upload) {
+		log.Warn("upload is held by another node", sl.String("node", upload.Node))
+		return upload, ErrUploadMisdirected
+	}
+
+	if upload.Status != enum.UploadStatusInProgress && upload.Status != enum.UploadStatusFinishing {
+		return upload, ErrUploadClosed
+	}
+
+	if upload.Offset != offset {
+		return upload, ErrUploadOffsetMismatch
+	}
+
+	written, copyErr := s.writeChunk(upload, chunk)
+
+	if written > 0 {
+		upload.Offset += written
+		if err := s.uploadRepo.UpdateOffset(ctx, upload.ID, upload.Offset); err != nil {
+			log.Error("failed to update upload offset", sl.Err(err))
+			return upload, errors.New("failed to update upload offset")
+		}
+	}
+
+	if copyErr != nil {
+		if errors.Is(copyErr, ErrUploadTooLarge) {
+			return upload, ErrUploadTooLarge
+		}
+		log.Error("failed to write chunk", sl.Err(copyErr), sl.Int64("written", written))
+		return upload, errors.New("failed to write chunk")
+	}
+
+	if upload.Offset < upload.Size {
+		return upload, nil
+	}
+
+	if upload.Status != enum.UploadStatusFinishing {
+		upload.Status = enum.UploadStatusFinishing
+		if err := s.uploadRepo.UpdateStatus(ctx, upload.ID, upload.Status); err != nil {
+			log.Error("failed to update upload status to finishing", sl.Err(err))
+			return upload, errors.New("failed to update upload status to finishing")
+		}
+	}
+
+	handedOver = true
+	s.finishing.Add(1)
+
+	go func() {
+		defer s.finishing.Done()
+		defer unlock()
+
+		s.finishInBackground(upload)
+	}()
+
+	return upload, nil
+}
+
+// WaitForFinishingUploads is a method to wait for the uploads being handed over in the background.
+// Those still running when ctx is done are left finishing, to be retried with an empty PATCH.
+func (s *UploadService) WaitForFinishingUploads(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.finishing.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to wait for finishing uploads: %w", ctx.Err())
+	}
+}
+
+// finishInBackground is a method to hand a fully received upload over and record the outcome on the upload
+func (s *UploadService) finishInBackground(upload types.Upload) {
+	const op string = "UploadService.finishInBackground"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", upload.UUID),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.UploadService.FinishTimeout)
+	defer cancel()
+
+	err := s.finishUpload(ctx, upload)
+
+	var mediaErr *MediaValidationError
+	switch {
+	case err == nil:
+		upload.Status = enum.UploadStatusCompleted
+	case errors.As(err, &mediaErr):
+		upload.Status = enum.UploadStatusFailed
+
+		if rmErr := os.Remove(s.partialPath(upload.UUID)); rmErr != nil && !os.IsNotExist(rmErr) {
+			log.Error("failed to remove partial file", sl.Err(rmErr))
+		}
+	default:
+		log.Error("failed to finish upload, leaving it to be retried", sl.Err(err))
+		upload.Status = enum.UploadStatusInProgress
+	}
+
+	// The hand over may have used up the timeout
+	if err := s.uploadRepo.UpdateStatus(context.Background(), upload.ID, upload.Status); err != nil {
+		log.Error("failed to update upload status", sl.Err(err), sl.String("status", upload.Status.String()))
+	}
+}
+
+// ProcessTerminateUpload is a method to terminate a resumable upload and remove its partial file,
+// on the node holding it
+func (s *UploadService) ProcessTerminateUpload(ctx context.Context, uuid string) error {
+	const op string = "UploadService.ProcessTerminateUpload"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+	)
+
+	unlock, ok := s.lock(uuid)
+	if !ok {
+		return ErrUploadLocked
+	}
+	defer unlock()
+
+	upload, err := s.ProcessGetUpload(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	if !s.holds(upload) {
+		log.Warn("upload is held by another node", sl.String("node", upload.Node))
+		return ErrUploadMisdirected
+	}
+
+	if err := os.Remove(s.partialPath(upload.UUID)); err != nil && !os.IsNotExist(err) {
+		log.Error("failed to remove partial file", sl.Err(err))
+		return errors.New("failed to remove partial file")
+	}
+
+	if err := s.uploadRepo.Delete(ctx, upload.ID); err != nil {
+		log.Error("failed to delete upload", sl.Err(err))
+		return errors.New("failed to delete upload")
+	}
+
+	return nil
+}
+
+// writeChunk is a method to write a chunk to the end of the partial file.
+// The partial file is truncated to the stored offset first, so bytes written
+// after the last persisted offset (e.g. before a crash) are discarded.
+func (s *UploadService) writeChunk(upload types.Upload, chunk io.Reader) (int64, error) {
+	file, err := os.OpenFile(s.partialPath(upload.UUID), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if err := file.Truncate(upload.Offset); err != nil {
+		return 0, err
+	}
+
+	if _, err := file.Seek(upload.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	remaining := upload.Size - upload.Offset
+
+	written, err := io.Copy(file, io.LimitReader(chunk, remaining))
+	if err != nil {
+		return written, err
+	}
+
+	if written == remaining {
+		var probe [1]byte
+		if n, _ := chunk.Read(probe[:]); n > 0 {
+			return written, ErrUploadTooLarge
+		}
+	}
+
+	return written, nil
+}
+
+// finishUpload is a method to hand a fully received upload over to the video upload flow
+func (s *UploadService) finishUpload(ctx context.Context, upload types.Upload) error {
+	const op string = "UploadService.finishUpload"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", upload.UUID),
+	)
+
+	partialPath := s.partialPath(upload.UUID)
+
+	file, err := os.Open(partialPath)
+	if err != nil {
+		log.Error("failed to open partial file", sl.Err(err))
+		return errors.New("failed to open partial file")
+	}
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			log.Error("failed to close partial file", sl.Err(err))
+		}
+	}(file)
+
+	uploadData := data.VideoUploadData{
+		File:        file,
+		Filename:    upload.Filename,
+		Name:        upload.Name,
+		Description: upload.Description,
+	}
+
+	if err := s.videoService.ProcessUpload(ctx, uploadData); err != nil {
+		log.Error("failed to process upload", sl.Err(err))
+		return err
+	}
+
+	if err := os.Remove(partialPath); err != nil {
+		log.Error("failed to remove partial file", sl.Err(err))
+	}
+
+	return nil
+}
+
+// lock is a method to take the per-upload lock without blocking.
+// The entry only lives while the lock is held, so finished uploads leave nothing behind.
+func (s *UploadService) lock(uuid string) (func(), bool) {
+	if _, locked := s.locks.LoadOrStore(uuid, struct{}{}); locked {
+		return nil, false
+	}
+
+	return func() {
+		s.locks.Delete(uuid)
+	}, true
+}
+
+// holds is a method to report whether the partial file of an upload is under this storage path
+func (s *UploadService) holds(upload types.Upload) bool {
+	return upload.Node == "" || upload.Node == s.node
+}
+
+// uploadNode returns the configured node, the hostname when none is
+func uploadNode(cfg *config.Config) string {
+	if cfg.UploadService.Node != "" {
+		return cfg.UploadService.Node
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+
+	return hostname
+}
+
+func (s *UploadService) uploadDir() string {
+	return fmt.Sprintf("%s/%s", s.cfg.HTTPServer.StoragePath, s.cfg.UploadService.UploadPath)
+}
+
+func (s *UploadService) partialPath(uuid string) string {
+	return filepath.Join(s.uploadDir(), uuid)
+}
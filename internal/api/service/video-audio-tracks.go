@@ -0,0 +1,577 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-fitness/external/logger/sl"
+	"go-fitness/internal/api/data"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/types"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// audioGroupID is the master playlist group every alternate audio track belongs to
+	audioGroupID = "aud"
+	// originalAudioName names the audio of the source in the audio group
+	originalAudioName = "Original"
+	// audioProgressLabel is the only entry of the progress of an audio track job
+	audioProgressLabel = "audio"
+)
+
+var (
+	ErrInvalidAudioTrack  = errors.New("invalid audio track")
+	ErrAudioTrackNotFound = errors.New("audio track not found")
+	ErrAudioTrackExists   = errors.New("audio track already exists")
+)
+
+type AudioTrackResponse struct {
+	ID       int64  `json:"id"`
+	Language string `json:"language"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Default  bool   `json:"default"`
+	State    string `json:"state"`
+	// URL is the audio media playlist relative to the videos route, set once the track is ready
+	URL string `json:"url,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// audioTrackName returns the base name of the playlist and segments of an audio track
+func audioTrackName(id int64) string {
+	return fmt.Sprintf("audio_%d", id)
+}
+
+// audioPlaylistFilename returns the media playlist of an audio track
+func audioPlaylistFilename(id int64) string {
+	return audioTrackName(id) + ".m3u8"
+}
+
+// audioSourcePrefix returns the name of the uploaded file of an audio track, without its extension
+func audioSourcePrefix(id int64) string {
+	return fmt.Sprintf("audio_%d_source", id)
+}
+
+// ProcessUploadAudioTrack is a method to attach an alternate audio file to a video.
+// The file is stored next to the source and queued as an audio transcode job,
+// the track joins the master playlist once it is transcoded.
+func (s *VideoService) ProcessUploadAudioTrack(ctx context.Context, audio data.VideoAudioTrackData) (AudioTrackResponse, error) {
+	const op string = "VideoService.ProcessUploadAudioTrack"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", audio.UUID),
+		sl.String("language", audio.Language),
+		sl.String("kind", audio.Kind),
+	)
+
+	if !languageTagRe.MatchString(audio.Language) {
+		log.Error("invalid audio track language")
+		return AudioTrackResponse{}, fmt.Errorf("%w: language must be a BCP 47 tag such as en or pt-BR", ErrInvalidAudioTrack)
+	}
+
+	kind := enum.ParseAudioTrackKind(audio.Kind)
+	if kind == enum.AudioTrackKindUnknown {
+		log.Error("invalid audio track kind")
+		return AudioTrackResponse{}, fmt.Errorf("%w: kind must be one of dub, commentary, music", ErrInvalidAudioTrack)
+	}
+
+	video, err := s.getLiveVideo(ctx, audio.UUID)
+	if err != nil {
+		log.Error("failed to get video", sl.Err(err))
+		return AudioTrackResponse{}, err
+	}
+
+	tracks, err := s.audioTrackRepo.GetByHashName(ctx, video.HashName)
+	if err != nil {
+		log.Error("failed to get audio tracks", sl.Err(err))
+		return AudioTrackResponse{}, errors.New("failed to get audio tracks")
+	}
+
+	if slices.ContainsFunc(tracks, func(track types.VideoAudioTrack) bool {
+		return track.Language == audio.Language && track.Kind == kind
+	}) {
+		log.Error("audio track already exists")
+		return AudioTrackResponse{}, ErrAudioTrackExists
+	}
+
+	uploadPath := filepath.Join(s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, video.HashName)
+
+	tmpPath, err := s.storeAudioUpload(uploadPath, audio.File)
+	if err != nil {
+		log.Error("failed to store audio file", sl.Err(err))
+		return AudioTrackResponse{}, err
+	}
+
+	info, err := s.prober.Probe(ctx, tmpPath)
+	if err != nil && !errors.Is(err, ErrUnreadableMedia) {
+		log.Error("failed to probe audio file", sl.Err(err))
+		_ = os.Remove(tmpPath)
+		return AudioTrackResponse{}, errors.New("failed to probe audio file")
+	}
+	if err != nil {
+		err = unreadableMediaError()
+	} else {
+		err = s.validateAudioMedia(info)
+	}
+	if err != nil {
+		log.Warn("rejected audio file", sl.Err(err))
+		_ = os.Remove(tmpPath)
+		return AudioTrackResponse{}, err
+	}
+
+	track := types.VideoAudioTrack{
+		HashName:  video.HashName,
+		Language:  audio.Language,
+		Name:      captionNameReplacer.Replace(audio.Name),
+		Kind:      kind,
+		IsDefault: audio.Default,
+		State:     enum.AudioTrackStatePending,
+	}
+
+	track.ID, err = s.audioTrackRepo.Create(ctx, track)
+	if err != nil {
+		log.Error("failed to create audio track", sl.Err(err))
+		_ = os.Remove(tmpPath)
+		return AudioTrackResponse{}, errors.New("failed to create audio track")
+	}
+
+	dstPath := filepath.Join(uploadPath, audioSourcePrefix(track.ID)+filepath.Ext(filepath.Base(audio.Filename)))
+	if err := os.Rename(tmpPath, dstPath); err != nil {
+		log.Error("failed to move audio file", sl.Err(err))
+		_ = os.Remove(tmpPath)
+		s.failAudioTrack(ctx, types.TranscodeJob{AudioTrackID: track.ID, UploadPath: uploadPath})
+		return AudioTrackResponse{}, errors.New("failed to move audio file")
+	}
+
+	if err := s.storeSource(ctx, dstPath); err != nil {
+		log.Error("failed to store audio file", sl.Err(err))
+		s.failAudioTrack(ctx, types.TranscodeJob{AudioTrackID: track.ID, UploadPath: uploadPath})
+		return AudioTrackResponse{}, errors.New("failed to store audio file")
+	}
+
+	transcodeJob := types.TranscodeJob{
+		VideoID:      video.ID,
+		Kind:         enum.TranscodeJobKindAudio,
+		AudioTrackID: track.ID,
+		UploadPath:   uploadPath,
+		DstPath:      dstPath,
+		ChunkHash:    video.HashName,
+	}
+
+	if _, err := s.transcodeJobRepo.Create(ctx, transcodeJob); err != nil {
+		log.Error("failed to enqueue audio transcode job", sl.Err(err))
+		s.failAudioTrack(ctx, transcodeJob)
+		return AudioTrackResponse{}, errors.New("failed to enqueue audio transcode job")
+	}
+
+	select {
+	case s.transcodeWakeup <- struct{}{}:
+	default:
+	}
+
+	now := time.Now()
+	track.CreatedAt, track.UpdatedAt = now, now
+
+	return audioTrackResponse(track), nil
+}
+
+// storeAudioUpload is a method to stream an uploaded audio file to a temporary file of the hash directory
+func (s *VideoService) storeAudioUpload(uploadPath string, audio io.Reader) (string, error) {
+	file, err := os.CreateTemp(uploadPath, ".audio-upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create audio file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, newMaxSizeReader(audio, s.cfg.UploadService.MaxSize)); err != nil {
+		_ = os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write audio file: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+// validateAudioMedia is a method to check a probed audio file against the audio rules of the media policy
+func (s *VideoService) validateAudioMedia(info types.MediaInfo) error {
+	policy := s.cfg.VideoService.MediaPolicy
+
+	validationErr := &MediaValidationError{}
+
+	if info.AudioCodec == "" {
+		validationErr.add("audio", "file has no audio stream")
+	} else if !slices.Contains(policy.AllowedAudioCodecs, info.AudioCodec) {
+		validationErr.add("audio_codec", "unsupported audio codec %q", info.AudioCodec)
+	}
+
+	duration := time.Duration(info.Duration * float64(time.Second))
+
+	switch {
+	case duration <= 0:
+		validationErr.add("duration", "media has no duration")
+	case policy.MaxDuration > 0 && duration > policy.MaxDuration:
+		validationErr.add("duration", "duration %s exceeds the maximum of %s",
+			duration.Round(time.Second), policy.MaxDuration)
+	}
+
+	if len(validationErr.Violations) > 0 {
+		return validationErr
+	}
+
+	return nil
+}
+
+// processAudioTranscode is a method to transcode the file of an audio track into an HLS audio rendition
+// with the audio settings of the default encoding profile and add it to the master playlist.
+// Like processTranscode it leaves cleanup of the uploaded file to the caller.
+func (s *VideoService) processAudioTranscode(ctx context.Context, job types.TranscodeJob) error {
+	const op string = "VideoService.processAudioTranscode"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.Int64("job_id", job.ID),
+		sl.Int64("audio_track_id", job.AudioTrackID),
+		sl.Int("attempt", job.Attempts),
+	)
+
+	if _, err := s.audioTrackRepo.GetByID(ctx, job.AudioTrackID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("audio track was deleted, skipping")
+			return nil
+		}
+		log.Error("failed to get audio track", sl.Err(err))
+		return fmt.Errorf("failed to get audio track: %w", err)
+	}
+
+	// The progress is pushed for the video the track was attached to, which may have been deleted since
+	var videoUUID string
+	if video, err := s.videoRepo.GetByID(ctx, job.VideoID); err == nil {
+		videoUUID = video.UUID
+	}
+
+	// The master playlist is regenerated from the renditions and tracks published by other instances
+	if err := s.fetchSource(ctx, job.DstPath); err != nil {
+		log.Error("failed to fetch audio file", sl.Err(err))
+		return fmt.Errorf("failed to fetch audio file: %w", err)
+	}
+
+	if err := s.restoreHash(ctx, job.UploadPath); err != nil {
+		log.Error("failed to restore video folder", sl.Err(err))
+		return fmt.Errorf("failed to restore video folder: %w", err)
+	}
+
+	info, err := s.prober.Probe(ctx, job.DstPath)
+	if err != nil {
+		log.Error("failed to probe audio file", sl.Err(err))
+		return fmt.Errorf("failed to probe audio file: %w", err)
+	}
+
+	progress := s.newTranscodeProgressReporter(job, videoUUID, []string{audioProgressLabel})
+	defer progress.flush(true)
+
+	stats, codecs, err := s.transcodeAudioTrack(ctx, job.UploadPath, job.ChunkHash, job.DstPath, job.AudioTrackID, info.Duration, progress)
+	if err != nil {
+		log.Error("failed to transcode audio track", sl.Err(err))
+		return fmt.Errorf("failed to transcode audio track: %w", err)
+	}
+
+	// The track may have been deleted while it was transcoding
+	if _, err := s.audioTrackRepo.GetByID(ctx, job.AudioTrackID); errors.Is(err, sql.ErrNoRows) {
+		log.Warn("audio track was deleted, removing its files")
+		s.removeAudioTrack(ctx, job.UploadPath, job.AudioTrackID)
+		return nil
+	}
+
+	if err := s.audioTrackRepo.MarkReady(ctx, job.AudioTrackID, stats.PeakBitrate, stats.AverageBitrate, codecs); err != nil {
+		log.Error("failed to mark audio track as ready", sl.Err(err))
+		return fmt.Errorf("failed to mark audio track as ready: %w", err)
+	}
+
+	if err := s.regenerateMasterPlaylist(ctx, job.UploadPath, job.ChunkHash); err != nil {
+		log.Error("failed to regenerate master playlist", sl.Err(err))
+		return fmt.Errorf("failed to regenerate master playlist: %w", err)
+	}
+
+	if err := s.publishHash(ctx, job.UploadPath); err != nil {
+		log.Error("failed to publish audio track", sl.Err(err))
+		return fmt.Errorf("failed to publish audio track: %w", err)
+	}
+
+	return nil
+}
+
+// transcodeAudioTrack is a method to encode the first audio stream of inputPath into the HLS rendition
+// of an audio track with the audio settings of the default encoding profile, in the segment format
+// of the video renditions, encrypted when encryption is enabled.
+// It returns the measured playlist and the codecs of the rendition.
+func (s *VideoService) transcodeAudioTrack(
+	ctx context.Context,
+	uploadPath string,
+	hashName string,
+	inputPath string,
+	id int64,
+	duration float64,
+	progress *transcodeProgressReporter,
+) (hlsPlaylistStats, string, error) {
+	_, profile := s.cfg.VideoService.EncodingProfileFor("")
+	name := audioTrackName(id)
+	playlistPath := filepath.Join(uploadPath, audioPlaylistFilename(id))
+	format := s.segmentFormatOf(uploadPath)
+
+	req := TranscodeRequest{
+		InputPath:      inputPath,
+		PlaylistPath:   playlistPath,
+		SegmentPattern: filepath.Join(uploadPath, segmentPattern(name, format)),
+		SegmentFormat:  format,
+		InitFilename:   initSegmentFilename(name),
+		Profile:        profile,
+		HLSTime:        s.cfg.VideoService.HLSTime,
+	}
+
+	err := s.transcoder.TranscodeAudioHLS(ctx, req, func(outTime float64) {
+		if duration > 0 {
+			progress.report(audioProgressLabel, outTime/duration*100)
+		}
+	})
+	if err != nil {
+		return hlsPlaylistStats{}, "", err
+	}
+
+	progress.report(audioProgressLabel, 100)
+
+	stats, err := measureMediaPlaylist(playlistPath)
+	if err != nil {
+		return stats, "", fmt.Errorf("failed to measure bandwidth: %w", err)
+	}
+
+	info, err := s.probeFirstSegment(ctx, playlistPath)
+	if err != nil {
+		return stats, "", fmt.Errorf("failed to probe segment: %w", err)
+	}
+
+	if err := s.encryptPlaylists(ctx, uploadPath, hashName, []string{name}); err != nil {
+		return stats, "", fmt.Errorf("failed to encrypt: %w", err)
+	}
+
+	return stats, audioCodecTag(info), nil
+}
+
+// transcodeMainAudio is a method to package the audio of a source as the main audio track of its hash,
+// reusing the track of a previous attempt
+func (s *VideoService) transcodeMainAudio(
+	ctx context.Context,
+	job types.TranscodeJob,
+	duration float64,
+	progress *transcodeProgressReporter,
+) error {
+	tracks, err := s.audioTrackRepo.GetByHashName(ctx, job.ChunkHash)
+	if err != nil {
+		return fmt.Errorf("failed to get audio tracks: %w", err)
+	}
+
+	index := slices.IndexFunc(tracks, func(track types.VideoAudioTrack) bool {
+		return track.Kind == enum.AudioTrackKindMain
+	})
+
+	var id int64
+	if index >= 0 {
+		id = tracks[index].ID
+	} else {
+		id, err = s.audioTrackRepo.Create(ctx, types.VideoAudioTrack{
+			HashName: job.ChunkHash,
+			Name:     originalAudioName,
+			Kind:     enum.AudioTrackKindMain,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create main audio track: %w", err)
+		}
+	}
+
+	stats, codecs, err := s.transcodeAudioTrack(ctx, job.UploadPath, job.ChunkHash, job.DstPath, id, duration, progress)
+	if err != nil {
+		return err
+	}
+
+	if err := s.audioTrackRepo.MarkReady(ctx, id, stats.PeakBitrate, stats.AverageBitrate, codecs); err != nil {
+		return fmt.Errorf("failed to mark main audio track as ready: %w", err)
+	}
+
+	return nil
+}
+
+// failAudioTrack is a method to mark the audio track of a job as failed and remove its files.
+// The video itself is left untouched.
+func (s *VideoService) failAudioTrack(ctx context.Context, job types.TranscodeJob) {
+	const op string = "VideoService.failAudioTrack"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.Int64("job_id", job.ID),
+		sl.Int64("audio_track_id", job.AudioTrackID),
+	)
+
+	if err := s.audioTrackRepo.UpdateState(ctx, job.AudioTrackID, enum.AudioTrackStateFailed); err != nil {
+		log.Error("failed to update audio track state to failed", sl.Err(err))
+	}
+
+	s.removeAudioTrack(ctx, job.UploadPath, job.AudioTrackID)
+}
+
+// ProcessGetAudioTracks is a method to process getting the alternate audio tracks of a video
+func (s *VideoService) ProcessGetAudioTracks(ctx context.Context, uuid string) ([]AudioTrackResponse, error) {
+	const op string = "VideoService.ProcessGetAudioTracks"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+	)
+
+	video, err := s.getLiveVideo(ctx, uuid)
+	if err != nil {
+		log.Error("failed to get video", sl.Err(err))
+		return nil, err
+	}
+
+	tracks, err := s.audioTrackRepo.GetByHashName(ctx, video.HashName)
+	if err != nil {
+		log.Error("failed to get audio tracks", sl.Err(err))
+		return nil, errors.New("failed to get audio tracks")
+	}
+
+	response := make([]AudioTrackResponse, 0, len(tracks))
+	for _, track := range tracks {
+		response = append(response, audioTrackResponse(track))
+	}
+
+	return response, nil
+}
+
+// ProcessDeleteAudioTrack is a method to remove an alternate audio track of a video
+// and drop it from the master playlist
+func (s *VideoService) ProcessDeleteAudioTrack(ctx context.Context, uuid string, id int64) error {
+	const op string = "VideoService.ProcessDeleteAudioTrack"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+		sl.Int64("audio_track_id", id),
+	)
+
+	video, err := s.getLiveVideo(ctx, uuid)
+	if err != nil {
+		log.Error("failed to get video", sl.Err(err))
+		return err
+	}
+
+	track, err := s.audioTrackRepo.GetByID(ctx, id)
+	if err != nil {
+		log.Error("failed to get audio track", sl.Err(err))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAudioTrackNotFound
+		}
+		return errors.New("failed to get audio track")
+	}
+
+	if track.HashName != video.HashName {
+		log.Error("audio track belongs to another video")
+		return ErrAudioTrackNotFound
+	}
+
+	if track.Kind == enum.AudioTrackKindMain {
+		log.Error("main audio track cannot be deleted")
+		return fmt.Errorf("%w: the audio of the source cannot be deleted", ErrInvalidAudioTrack)
+	}
+
+	if err := s.audioTrackRepo.Delete(ctx, id); err != nil {
+		log.Error("failed to delete audio track", sl.Err(err))
+		return errors.New("failed to delete audio track")
+	}
+
+	uploadPath := filepath.Join(s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, video.HashName)
+
+	if err := s.restoreHash(ctx, uploadPath); err != nil {
+		log.Error("failed to restore video folder", sl.Err(err))
+		return errors.New("failed to restore video folder")
+	}
+
+	if err := s.regenerateMasterPlaylist(ctx, uploadPath, video.HashName); err != nil {
+		log.Error("failed to regenerate master playlist", sl.Err(err))
+		return errors.New("failed to regenerate master playlist")
+	}
+
+	if err := s.publishHash(ctx, uploadPath); err != nil {
+		log.Error("failed to publish master playlist", sl.Err(err))
+		return errors.New("failed to publish master playlist")
+	}
+
+	// The rendition is removed once the master no longer references it,
+	// a pending upload is left to its job which notices the track is gone
+	if track.State != enum.AudioTrackStatePending {
+		s.removeAudioTrack(ctx, uploadPath, id)
+	}
+
+	return nil
+}
+
+// removeAudioTrackFiles removes the uploaded file, segments and playlist of an audio track
+func removeAudioTrackFiles(dir string, id int64) {
+	files, _ := filepath.Glob(filepath.Join(dir, fmt.Sprintf("audio_%d_*", id)))
+	for _, path := range append(files, filepath.Join(dir, audioPlaylistFilename(id))) {
+		_ = os.Remove(path)
+	}
+}
+
+// removeAudioTrack is a method to remove the files of an audio track from the storage path and the storage
+func (s *VideoService) removeAudioTrack(ctx context.Context, dir string, id int64) {
+	const op string = "VideoService.removeAudioTrack"
+
+	removeAudioTrackFiles(dir, id)
+
+	if err := s.removeStored(ctx, dir, audioTrackName(id)+"_", audioPlaylistFilename(id)); err != nil {
+		s.log.Error("failed to remove stored audio track", sl.String("op", op), sl.Int64("audio_track_id", id), sl.Err(err))
+	}
+}
+
+// mergeCodecs appends the codecs of an alternate audio track missing from the codecs of a rendition
+func mergeCodecs(codecs string, extra ...string) string {
+	list := strings.Split(codecs, ",")
+	if codecs == "" {
+		list = nil
+	}
+
+	for _, codec := range extra {
+		if codec != "" && !slices.Contains(list, codec) {
+			list = append(list, codec)
+		}
+	}
+
+	return strings.Join(list, ",")
+}
+
+func audioTrackResponse(track types.VideoAudioTrack) AudioTrackResponse {
+	response := AudioTrackResponse{
+		ID:        track.ID,
+		Language:  track.Language,
+		Name:      track.Name,
+		Kind:      track.Kind.String(),
+		Default:   track.IsDefault,
+		State:     track.State.String(),
+		CreatedAt: track.CreatedAt,
+		UpdatedAt: track.UpdatedAt,
+	}
+
+	if track.State == enum.AudioTrackStateReady {
+		response.URL = track.HashName + "/" + audioPlaylistFilename(track.ID)
+	}
+
+	return response
+}
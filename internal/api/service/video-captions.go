@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-fitness/external/logger/sl"
+	"go-fitness/internal/api/data"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/types"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxCaptionSize bounds the size of an uploaded caption file
+const MaxCaptionSize = 5 << 20
+
+var (
+	ErrInvalidCaption   = errors.New("invalid caption")
+	ErrCaptionTooLarge  = errors.New("caption file too large")
+	ErrCaptionNotFound  = errors.New("caption not found")
+	languageTagRe       = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+	captionNameReplacer = strings.NewReplacer(`"`, "", "\r", "", "\n", "")
+)
+
+type CaptionResponse struct {
+	Language string `json:"language"`
+	Name     string `json:"name"`
+	Default  bool   `json:"default"`
+	// URL is the whole WebVTT track, relative to the videos route the response was served from
+	URL string `json:"url"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProcessUploadCaption is a method to add or replace the caption track of a video in one language.
+// The file is converted to WebVTT, segmented alongside the renditions and added to the master playlist.
+func (s *VideoService) ProcessUploadCaption(ctx context.Context, caption data.VideoCaptionData) (CaptionResponse, error) {
+	const op string = "VideoService.ProcessUploadCaption"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", caption.UUID),
+		sl.String("language", caption.Language),
+	)
+
+	if !languageTagRe.MatchString(caption.Language) {
+		log.Error("invalid caption language")
+		return CaptionResponse{}, fmt.Errorf("%w: language must be a BCP 47 tag such as en or pt-BR", ErrInvalidCaption)
+	}
+
+	video, err := s.getLiveVideo(ctx, caption.UUID)
+	if err != nil {
+		log.Error("failed to get video", sl.Err(err))
+		return CaptionResponse{}, err
+	}
+
+	content, err := io.ReadAll(io.LimitReader(caption.File, MaxCaptionSize+1))
+	if err != nil {
+		log.Error("failed to read caption file", sl.Err(err))
+		return CaptionResponse{}, fmt.Errorf("failed to read caption file: %w", err)
+	}
+
+	if len(content) > MaxCaptionSize {
+		log.Error("caption file too large")
+		return CaptionResponse{}, ErrCaptionTooLarge
+	}
+
+	cues, err := parseCaptions(content)
+	if err != nil {
+		log.Error("failed to parse caption file", sl.Err(err))
+		return CaptionResponse{}, fmt.Errorf("%w: %v", ErrInvalidCaption, err)
+	}
+
+	uploadPath := filepath.Join(s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, video.HashName)
+
+	if err := s.restoreHash(ctx, uploadPath); err != nil {
+		log.Error("failed to restore video folder", sl.Err(err))
+		return CaptionResponse{}, errors.New("failed to restore video folder")
+	}
+
+	if err := writeCaptionTrack(
+		uploadPath,
+		caption.Language,
+		cues,
+		video.Duration,
+		s.cfg.VideoService.HLSTime,
+		s.captionTimestampMap(uploadPath),
+	); err != nil {
+		log.Error("failed to write caption track", sl.Err(err))
+		return CaptionResponse{}, errors.New("failed to write caption track")
+	}
+
+	videoCaption := types.VideoCaption{
+		HashName:  video.HashName,
+		Language:  caption.Language,
+		Name:      captionNameReplacer.Replace(caption.Name),
+		IsDefault: caption.Default,
+	}
+
+	if err := s.captionRepo.Save(ctx, videoCaption); err != nil {
+		log.Error("failed to save caption", sl.Err(err))
+		removeCaptionTrack(uploadPath, caption.Language)
+		return CaptionResponse{}, errors.New("failed to save caption")
+	}
+
+	if err := s.regenerateMasterPlaylist(ctx, uploadPath, video.HashName); err != nil {
+		log.Error("failed to regenerate master playlist", sl.Err(err))
+		return CaptionResponse{}, errors.New("failed to regenerate master playlist")
+	}
+
+	if err := s.publishHash(ctx, uploadPath); err != nil {
+		log.Error("failed to publish caption track", sl.Err(err))
+		return CaptionResponse{}, errors.New("failed to publish caption track")
+	}
+
+	now := time.Now()
+	videoCaption.CreatedAt, videoCaption.UpdatedAt = now, now
+
+	return captionResponse(videoCaption), nil
+}
+
+// ProcessGetCaptions is a method to process getting the caption tracks of a video
+func (s *VideoService) ProcessGetCaptions(ctx context.Context, uuid string) ([]CaptionResponse, error) {
+	const op string = "VideoService.ProcessGetCaptions"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+	)
+
+	video, err := s.getLiveVideo(ctx, uuid)
+	if err != nil {
+		log.Error("failed to get video", sl.Err(err))
+		return nil, err
+	}
+
+	captions, err := s.captionRepo.GetByHashName(ctx, video.HashName)
+	if err != nil {
+		log.Error("failed to get captions", sl.Err(err))
+		return nil, errors.New("failed to get captions")
+	}
+
+	response := make([]CaptionResponse, 0, len(captions))
+	for _, caption := range captions {
+		response = append(response, captionResponse(caption))
+	}
+
+	return response, nil
+}
+
+// ProcessDeleteCaption is a method to remove the caption track of a video in one language
+// and drop it from the master playlist
+func (s *VideoService) ProcessDeleteCaption(ctx context.Context, uuid string, language string) error {
+	const op string = "VideoService.ProcessDeleteCaption"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+		sl.String("language", language),
+	)
+
+	video, err := s.getLiveVideo(ctx, uuid)
+	if err != nil {
+		log.Error("failed to get video", sl.Err(err))
+		return err
+	}
+
+	if err := s.captionRepo.Delete(ctx, video.HashName, language); err != nil {
+		log.Error("failed to delete caption", sl.Err(err))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrCaptionNotFound
+		}
+		return errors.New("failed to delete caption")
+	}
+
+	uploadPath := filepath.Join(s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, video.HashName)
+
+	if err := s.restoreHash(ctx, uploadPath); err != nil {
+		log.Error("failed to restore video folder", sl.Err(err))
+		return errors.New("failed to restore video folder")
+	}
+
+	if err := s.regenerateMasterPlaylist(ctx, uploadPath, video.HashName); err != nil {
+		log.Error("failed to regenerate master playlist", sl.Err(err))
+		return errors.New("failed to regenerate master playlist")
+	}
+
+	if err := s.publishHash(ctx, uploadPath); err != nil {
+		log.Error("failed to publish master playlist", sl.Err(err))
+		return errors.New("failed to publish master playlist")
+	}
+
+	// The track is removed once the master no longer references it
+	if languageTagRe.MatchString(language) {
+		removeCaptionTrack(uploadPath, language)
+
+		if err := s.removeStored(ctx, uploadPath, captionFilePrefixes(language)...); err != nil {
+			log.Error("failed to remove stored caption track", sl.Err(err))
+		}
+	}
+
+	return nil
+}
+
+// captionTimestampMap is a method to get the X-TIMESTAMP-MAP matching the segments of the renditions of an upload path
+func (s *VideoService) captionTimestampMap(uploadPath string) string {
+	if s.segmentFormatOf(uploadPath) == SegmentFormatFMP4 {
+		return subtitleTimestampMapFMP4
+	}
+
+	return subtitleTimestampMap
+}
+
+// getLiveVideo is a method to get a video that is neither failed nor deleted, whose renditions are or will be available
+func (s *VideoService) getLiveVideo(ctx context.Context, uuid string) (types.Video, error) {
+	video, err := s.videoRepo.GetByUUIDAnyStatus(ctx, uuid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return video, ErrVideoNotFound
+		}
+		return video, errors.New("failed to get video by uuid")
+	}
+
+	if video.Status == enum.VideoStatusFailed || video.DeletedAt != nil {
+		return video, ErrVideoNotFound
+	}
+
+	return video, nil
+}
+
+func captionResponse(caption types.VideoCaption) CaptionResponse {
+	return CaptionResponse{
+		Language:  caption.Language,
+		Name:      caption.Name,
+		Default:   caption.IsDefault,
+		URL:       caption.HashName + "/" + captionFilename(caption.Language),
+		CreatedAt: caption.CreatedAt,
+		UpdatedAt: caption.UpdatedAt,
+	}
+}
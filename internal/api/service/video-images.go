@@ -0,0 +1,219 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-fitness/external/logger/sl"
+	"go-fitness/external/storage"
+	"go-fitness/internal/api/types"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	// imagesDir is the folder next to the HLS output holding the extracted images
+	imagesDir         = "images"
+	posterFilename    = "poster.jpg"
+	thumbnailPattern  = "thumb-%03d.jpg"
+	storyboardPattern = "storyboard-%03d.jpg"
+	storyboardVTT     = "storyboard.vtt"
+
+	// posterSkip is the share of the video skipped before looking for the best poster frame,
+	// so intros fading in from black are not picked
+	posterSkip = 0.1
+)
+
+var (
+	ErrVideoImageNotFound = errors.New("video image not found")
+
+	videoImageRe = regexp.MustCompile(`^(poster\.jpg|thumb-[0-9]{3}\.jpg|storyboard-[0-9]{3}\.jpg|storyboard\.vtt|` +
+		`custom-[0-9a-f]{16}-(small|medium|large)\.(jpg|webp))$`)
+)
+
+// videoImageContentType returns the content type of a file served from imagesDir
+func videoImageContentType(filename string) string {
+	switch filepath.Ext(filename) {
+	case ".vtt":
+		return "text/vtt; charset=utf-8"
+	case ".webp":
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// generateImages is a method to extract the poster, the thumbnails and the storyboard
+// sprite sheets of a source into the images folder of its upload path
+func (s *VideoService) generateImages(ctx context.Context, uploadPath string, videoPath string, info types.MediaInfo) error {
+	const op string = "VideoService.generateImages"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("upload_path", uploadPath),
+	)
+
+	cfg := s.cfg.VideoService.Images
+	dir := filepath.Join(uploadPath, imagesDir)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Error("failed to create images folder", sl.Err(err))
+		return fmt.Errorf("failed to create images folder: %w", err)
+	}
+
+	// Images from a previous attempt may be stale, the storyboard layout depends on the duration.
+	// Custom posters live in the same folder and are kept.
+	for _, pattern := range []string{"thumb-*.jpg", "storyboard-*.jpg"} {
+		stale, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, path := range stale {
+			if err := os.Remove(path); err != nil {
+				log.Error("failed to remove stale image", sl.String("path", path), sl.Err(err))
+				return fmt.Errorf("failed to remove stale image: %w", err)
+			}
+		}
+	}
+
+	width, height := info.DisplaySize()
+	if width <= 0 || height <= 0 {
+		log.Error("invalid display size", sl.Int("width", width), sl.Int("height", height))
+		return fmt.Errorf("invalid display size %dx%d", width, height)
+	}
+
+	poster := FrameRequest{
+		InputPath:  videoPath,
+		OutputPath: filepath.Join(dir, posterFilename),
+		Offset:     cfg.PosterOffset.Seconds(),
+	}
+	poster.Width, poster.Height = imageSize(width, height, cfg.PosterWidth)
+
+	if poster.Offset == 0 || poster.Offset >= info.Duration {
+		poster.Offset = info.Duration * posterSkip
+		poster.BestFrame = true
+	}
+
+	if err := s.transcoder.ExtractPoster(ctx, poster); err != nil {
+		log.Error("failed to extract poster", sl.Err(err))
+		return fmt.Errorf("failed to extract poster: %w", err)
+	}
+
+	thumbnails := FrameRequest{
+		InputPath:  videoPath,
+		OutputPath: filepath.Join(dir, thumbnailPattern),
+		Interval:   cfg.ThumbnailInterval.Seconds(),
+	}
+	thumbnails.Width, thumbnails.Height = imageSize(width, height, cfg.ThumbnailWidth)
+
+	if err := s.transcoder.ExtractFrames(ctx, thumbnails); err != nil {
+		log.Error("failed to extract thumbnails", sl.Err(err))
+		return fmt.Errorf("failed to extract thumbnails: %w", err)
+	}
+
+	storyboard := FrameRequest{
+		InputPath:  videoPath,
+		OutputPath: filepath.Join(dir, storyboardPattern),
+		Interval:   cfg.StoryboardInterval.Seconds(),
+		Columns:    cfg.StoryboardColumns,
+		Rows:       cfg.StoryboardRows,
+	}
+	storyboard.Width, storyboard.Height = imageSize(width, height, cfg.StoryboardTileWidth)
+
+	if err := s.transcoder.ExtractFrames(ctx, storyboard); err != nil {
+		log.Error("failed to extract storyboard", sl.Err(err))
+		return fmt.Errorf("failed to extract storyboard: %w", err)
+	}
+
+	if err := writeStoryboardVTT(dir, storyboard, info.Duration); err != nil {
+		log.Error("failed to write storyboard vtt", sl.Err(err))
+		return fmt.Errorf("failed to write storyboard vtt: %w", err)
+	}
+
+	return nil
+}
+
+// imageSize scales a display size to the given width, never upscaling
+func imageSize(width int, height int, targetWidth int) (int, int) {
+	if targetWidth >= width {
+		return evenDimension(float64(width)), evenDimension(float64(height))
+	}
+
+	return evenDimension(float64(targetWidth)), evenDimension(float64(height) * float64(targetWidth) / float64(width))
+}
+
+// writeStoryboardVTT writes the WebVTT thumbnails track mapping every storyboard interval
+// to its tile in the sprite sheets, using media fragments relative to the track
+func writeStoryboardVTT(dir string, storyboard FrameRequest, duration float64) error {
+	perSheet := storyboard.Columns * storyboard.Rows
+	count := int(math.Ceil(duration / storyboard.Interval))
+
+	var vtt bytes.Buffer
+	vtt.WriteString("WEBVTT\n")
+
+	for i := 0; i < count; i++ {
+		start := float64(i) * storyboard.Interval
+		end := math.Min(start+storyboard.Interval, duration)
+		tile := i % perSheet
+
+		vtt.WriteString(fmt.Sprintf("\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start),
+			vttTimestamp(end),
+			fmt.Sprintf(storyboardPattern, i/perSheet),
+			tile%storyboard.Columns*storyboard.Width,
+			tile/storyboard.Columns*storyboard.Height,
+			storyboard.Width,
+			storyboard.Height,
+		))
+	}
+
+	return os.WriteFile(filepath.Join(dir, storyboardVTT), vtt.Bytes(), 0644)
+}
+
+// vttTimestamp formats seconds as a WebVTT hh:mm:ss.ttt timestamp
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// ProcessGetVideoImage is a method to process getting the poster, a thumbnail,
+// a storyboard sprite sheet or the storyboard track of a video
+func (s *VideoService) ProcessGetVideoImage(ctx context.Context, uuid string, filename string) ([]byte, string, error) {
+	const op string = "VideoService.ProcessGetVideoImage"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+		sl.String("filename", filename),
+	)
+
+	if !videoImageRe.MatchString(filename) {
+		log.Error("unknown image filename")
+		return nil, "", ErrVideoImageNotFound
+	}
+
+	video, err := s.videoRepo.GetByUUID(ctx, uuid)
+	if err != nil {
+		log.Error("failed to get video by uuid", sl.Err(err))
+		return nil, "", ErrVideoImageNotFound
+	}
+
+	imagePath := filepath.Join(
+		s.cfg.HTTPServer.StoragePath,
+		s.cfg.VideoService.VideoPath,
+		video.HashName,
+		imagesDir,
+		filename,
+	)
+
+	image, err := s.readStored(ctx, imagePath)
+	if err != nil {
+		log.Error("failed to read image", sl.Err(err))
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, "", ErrVideoImageNotFound
+		}
+		return nil, "", errors.New("failed to read image")
+	}
+
+	return image, videoImageContentType(filename), nil
+}
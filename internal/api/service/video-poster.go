@@ -0,0 +1,317 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-fitness/external/logger/sl"
+	"go-fitness/internal/api/data"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/types"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// posterSizes are the widths every custom poster is resized to, each written as JPEG and WebP
+var posterSizes = []struct {
+	Name  string
+	Width int
+}{
+	{Name: "small", Width: 320},
+	{Name: "medium", Width: 640},
+	{Name: "large", Width: 1280},
+}
+
+var (
+	posterFormats    = []string{"jpg", "webp"}
+	posterMediaTypes = []string{"image/jpeg", "image/png", "image/webp"}
+)
+
+const (
+	customPosterPrefix = "custom-"
+	// posterMaxSide bounds both sides of an uploaded poster image
+	posterMaxSide = 8192
+	// posterMinWidth is the narrowest poster image accepted
+	posterMinWidth = 320
+)
+
+var (
+	ErrVideoNotFound          = errors.New("video not found")
+	ErrInvalidPosterImage     = errors.New("invalid poster image")
+	ErrInvalidPosterTimestamp = errors.New("invalid poster timestamp")
+	ErrPosterTooLarge         = errors.New("poster image too large")
+	// ErrPosterSourceUnavailable is returned for a timestamp when the source is not kept once transcoded
+	ErrPosterSourceUnavailable = errors.New("the source of the video is not available")
+)
+
+// customPosterFilename returns the file of a custom poster at the given size and format
+func customPosterFilename(poster string, size string, format string) string {
+	return fmt.Sprintf("%s-%s.%s", poster, size, format)
+}
+
+// posterURL returns the URL of the poster of a video relative to its videos route,
+// the custom poster when one was selected and the extracted one otherwise
+func posterURL(video types.Video) string {
+	switch {
+	case video.Poster != "":
+		return fmt.Sprintf("%s/%s/%s", video.UUID, imagesDir, customPosterFilename(video.Poster, "large", "jpg"))
+	case video.Status == enum.VideoStatusProcessed:
+		return fmt.Sprintf("%s/%s/%s", video.UUID, imagesDir, posterFilename)
+	default:
+		return ""
+	}
+}
+
+// ProcessSetVideoPoster is a method to replace the poster of a video with an uploaded image
+// or with the frame of its source at the given timestamp, resized to every poster size
+func (s *VideoService) ProcessSetVideoPoster(ctx context.Context, poster data.VideoPosterData) (VideoResponse, error) {
+	const op string = "VideoService.ProcessSetVideoPoster"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", poster.UUID),
+	)
+
+	video, err := s.videoRepo.GetByUUIDAnyStatus(ctx, poster.UUID)
+	if err != nil {
+		log.Error("failed to get video by uuid", sl.Err(err))
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoResponse{}, ErrVideoNotFound
+		}
+		return VideoResponse{}, errors.New("failed to get video by uuid")
+	}
+
+	videoPath := filepath.Join(s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, video.HashName)
+	dir := filepath.Join(videoPath, imagesDir)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Error("failed to create images folder", sl.Err(err))
+		return VideoResponse{}, errors.New("failed to create images folder")
+	}
+
+	frame := FrameRequest{}
+
+	if poster.Image != nil {
+		inputPath, err := s.storePosterImage(dir, poster.Image)
+		if err != nil {
+			log.Error("failed to store poster image", sl.Err(err))
+			return VideoResponse{}, err
+		}
+		defer os.Remove(inputPath)
+
+		frame.InputPath = inputPath
+	} else {
+		source, err := s.posterSource(ctx, videoPath)
+		if err != nil {
+			log.Error("failed to get source", sl.Err(err))
+			return VideoResponse{}, err
+		}
+
+		// A fetched source only stays in the storage
+		if s.remoteStorage() {
+			defer os.Remove(source)
+		}
+
+		frame.InputPath = source
+		frame.Offset = *poster.Timestamp
+	}
+
+	info, err := s.prober.Probe(ctx, frame.InputPath)
+	if err != nil {
+		log.Error("failed to probe poster input", sl.Err(err))
+		if poster.Image != nil && errors.Is(err, ErrUnreadableMedia) {
+			return VideoResponse{}, fmt.Errorf("%w: %v", ErrInvalidPosterImage, err)
+		}
+		return VideoResponse{}, fmt.Errorf("failed to probe poster input: %w", err)
+	}
+
+	if err := validatePosterInput(info, poster); err != nil {
+		log.Error("invalid poster input", sl.Err(err))
+		return VideoResponse{}, err
+	}
+
+	name, err := newPosterName()
+	if err != nil {
+		log.Error("failed to generate poster name", sl.Err(err))
+		return VideoResponse{}, errors.New("failed to generate poster name")
+	}
+
+	width, height := info.DisplaySize()
+
+	for _, size := range posterSizes {
+		frame.Width, frame.Height = imageSize(width, height, size.Width)
+
+		for _, format := range posterFormats {
+			frame.OutputPath = filepath.Join(dir, customPosterFilename(name, size.Name, format))
+
+			if err := s.transcoder.ExtractPoster(ctx, frame); err != nil {
+				log.Error("failed to write poster", sl.String("size", size.Name), sl.String("format", format), sl.Err(err))
+				removePosterFiles(dir, name)
+				return VideoResponse{}, fmt.Errorf("failed to write poster: %w", err)
+			}
+		}
+	}
+
+	if err := s.publishHash(ctx, videoPath); err != nil {
+		log.Error("failed to publish poster", sl.Err(err))
+		removePosterFiles(dir, name)
+		return VideoResponse{}, errors.New("failed to publish poster")
+	}
+
+	if err := s.videoRepo.UpdatePoster(ctx, video.ID, name); err != nil {
+		log.Error("failed to update video poster", sl.Err(err))
+		s.removePoster(ctx, dir, name)
+		return VideoResponse{}, errors.New("failed to update video poster")
+	}
+
+	if video.Poster != "" {
+		s.removePoster(ctx, dir, video.Poster)
+	}
+	video.Poster = name
+
+	return VideoResponse{
+		UUID:        video.UUID,
+		Name:        video.Name,
+		Description: video.Description,
+		Status:      video.Status.String(),
+		Duration:    video.Duration,
+		PosterURL:   posterURL(video),
+		CreatedAt:   video.CreatedAt,
+		UpdatedAt:   video.UpdatedAt,
+	}, nil
+}
+
+// posterSource is a method to get the source of a hash directory to extract a poster frame from,
+// fetching it from a remote storage when the working directory lacks it
+func (s *VideoService) posterSource(ctx context.Context, videoPath string) (string, error) {
+	sources, err := filepath.Glob(filepath.Join(videoPath, "source.*"))
+	if err != nil {
+		return "", err
+	}
+	if len(sources) > 0 {
+		return sources[0], nil
+	}
+
+	if s.remoteStorage() {
+		stored, err := s.listStored(ctx, videoPath)
+		if err != nil {
+			return "", err
+		}
+
+		for key, object := range stored {
+			if path := s.storageFilePath(key); filepath.Dir(path) == filepath.Clean(videoPath) &&
+				strings.HasPrefix(filepath.Base(path), "source.") {
+				if err := s.getStored(ctx, object); err != nil {
+					return "", fmt.Errorf("failed to fetch source: %w", err)
+				}
+				return path, nil
+			}
+		}
+	}
+
+	return "", ErrPosterSourceUnavailable
+}
+
+// storePosterImage is a method to write an uploaded poster image to a temporary file
+// once its content is sniffed as a supported image type
+func (s *VideoService) storePosterImage(dir string, image io.Reader) (string, error) {
+	maxSize := s.cfg.VideoService.Images.PosterMaxSize
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(image, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		if errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("%w: the image is empty", ErrInvalidPosterImage)
+		}
+		return "", fmt.Errorf("failed to read poster image: %w", err)
+	}
+	head = head[:n]
+
+	mediaType := http.DetectContentType(head)
+	if !slices.Contains(posterMediaTypes, mediaType) {
+		return "", fmt.Errorf("%w: unsupported type %s, expected one of %s",
+			ErrInvalidPosterImage, mediaType, strings.Join(posterMediaTypes, ", "))
+	}
+
+	file, err := os.CreateTemp(dir, ".poster-upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create poster file: %w", err)
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, io.MultiReader(bytes.NewReader(head), io.LimitReader(image, maxSize-int64(n)+1)))
+	if err == nil && written > maxSize {
+		err = ErrPosterTooLarge
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write poster file: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+// validatePosterInput checks the dimensions of an uploaded image, or that the timestamp lies within the source
+func validatePosterInput(info types.MediaInfo, poster data.VideoPosterData) error {
+	width, height := info.DisplaySize()
+
+	if poster.Image != nil {
+		switch {
+		case width < posterMinWidth:
+			return fmt.Errorf("%w: the image must be at least %d pixels wide, got %d", ErrInvalidPosterImage, posterMinWidth, width)
+		case width > posterMaxSide || height > posterMaxSide:
+			return fmt.Errorf("%w: the image must not exceed %dx%d, got %dx%d",
+				ErrInvalidPosterImage, posterMaxSide, posterMaxSide, width, height)
+		}
+		return nil
+	}
+
+	if timestamp := *poster.Timestamp; timestamp < 0 || timestamp >= info.Duration {
+		return fmt.Errorf("%w: %.3f is outside of the video duration %.3f", ErrInvalidPosterTimestamp, timestamp, info.Duration)
+	}
+
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("%w: the source has no video stream", ErrInvalidPosterTimestamp)
+	}
+
+	return nil
+}
+
+// newPosterName returns a random base name for custom poster files, so a replaced poster
+// never collides with the cached images of the previous one
+func newPosterName() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return customPosterPrefix + hex.EncodeToString(b), nil
+}
+
+// removePosterFiles removes every size and format of a custom poster
+func removePosterFiles(dir string, poster string) {
+	for _, size := range posterSizes {
+		for _, format := range posterFormats {
+			_ = os.Remove(filepath.Join(dir, customPosterFilename(poster, size.Name, format)))
+		}
+	}
+}
+
+// removePoster is a method to remove every size and format of a custom poster from the storage path and the storage
+func (s *VideoService) removePoster(ctx context.Context, dir string, poster string) {
+	const op string = "VideoService.removePoster"
+
+	removePosterFiles(dir, poster)
+
+	if err := s.removeStored(ctx, dir, poster+"-"); err != nil {
+		s.log.Error("failed to remove stored poster", sl.String("op", op), sl.String("poster", poster), sl.Err(err))
+	}
+}
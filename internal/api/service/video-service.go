@@ -1,25 +1,31 @@
 package service
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/patrickmn/go-cache"
 	"go-fitness/external/config"
 	"go-fitness/external/logger/sl"
+	"go-fitness/external/storage"
 	"go-fitness/internal/api/data"
 	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/event"
 	"go-fitness/internal/api/repository"
 	"go-fitness/internal/api/types"
 	"io"
 	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"sort"
-	"strconv"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -27,8 +33,29 @@ type VideoService struct {
 	log                 *slog.Logger
 	cfg                 *config.Config
 	notificationService NotificationServiceInterface
+	event               event.WSInterface
 	videoRepo           repository.VideoRepositoryInterface
-	transcodeQueue      VideoTranscodeTaskChan
+	transcodeJobRepo    repository.TranscodeJobRepositoryInterface
+	mediaInfoRepo       repository.MediaInfoRepositoryInterface
+	captionRepo         repository.CaptionRepositoryInterface
+	audioTrackRepo      repository.AudioTrackRepositoryInterface
+	videoKeyRepo        repository.VideoKeyRepositoryInterface
+	storage             storage.Storage
+	transcoder          TranscoderInterface
+	prober              ProberInterface
+
+	// mediaCache holds the playableHash of the videos whose media playlists were served lately
+	mediaCache *cache.Cache
+
+	// transcodeWakeup nudges idle workers when a new job is enqueued
+	transcodeWakeup chan struct{}
+	workers         *transcodeWorkerPool
+
+	// runningJobs maps the id of every job transcoded by this process to the cancel func of its context
+	runningJobs  sync.Map
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+	workerGroup  sync.WaitGroup
 }
 
 type UploadResult struct {
@@ -40,13 +67,15 @@ type UploadResult struct {
 
 type UploadAndTranscodeQueueInterface interface {
 	WaitForTranscodeVideoSignals()
+	StopTranscodeWorkers(context.Context) error
 }
 
 type VideoServiceInterface interface {
 	ProcessUpload(context.Context, data.VideoUploadData) error
-	ProcessGetVideoPlayListByUUID(context.Context, string) ([]byte, error)
-	ProcessGetVideoTS(context.Context, string) ([]byte, error)
-	ProcessGetVideoM3U8(string) ([]byte, error)
+	ProcessGetVideoPlayListByUUID(context.Context, string, data.PlaylistAccess) (MediaFile, error)
+	ProcessGetVideoManifestByUUID(context.Context, string, data.PlaylistAccess) (MediaFile, error)
+	ProcessGetVideoSegment(context.Context, string) (MediaFile, error)
+	ProcessGetVideoM3U8(context.Context, string, data.PlaylistAccess) (MediaFile, error)
 	ProcessDeleteVideo(context.Context, string) error
 	ProcessUpdateVideoInfo(context.Context, types.Video) error
 	ProcessGetVideoPosition(context.Context, int64, string) (float64, error)
@@ -55,21 +84,54 @@ type VideoServiceInterface interface {
 
 	ProcessGetVideoList(context.Context) ([]VideoResponse, error)
 	ProcessGetVideoListWithPosition(context.Context, int64, map[string]interface{}) ([]VideoResponse, error)
+	ProcessGetTranscodeWorkersHealth() []TranscodeWorkerHealth
+	ProcessGetVideoJob(context.Context, string) (TranscodeJobResponse, error)
+	ProcessCancelVideoJob(context.Context, string) error
+	ProcessGetVideoDetails(context.Context, string) (VideoDetailsResponse, error)
+	ProcessGetVideoImage(context.Context, string, string) ([]byte, string, error)
+	ProcessSetVideoPoster(context.Context, data.VideoPosterData) (VideoResponse, error)
+	ProcessUploadCaption(context.Context, data.VideoCaptionData) (CaptionResponse, error)
+	ProcessGetCaptions(context.Context, string) ([]CaptionResponse, error)
+	ProcessDeleteCaption(context.Context, string, string) error
+	ProcessUploadAudioTrack(context.Context, data.VideoAudioTrackData) (AudioTrackResponse, error)
+	ProcessGetAudioTracks(context.Context, string) ([]AudioTrackResponse, error)
+	ProcessDeleteAudioTrack(context.Context, string, int64) error
+	ProcessGetVideoKey(context.Context, string, int) ([]byte, error)
 }
 
 func NewVideoService(
 	log *slog.Logger,
 	cfg *config.Config,
 	notificationService NotificationServiceInterface,
+	event event.WSInterface,
 	videoRepo repository.VideoRepositoryInterface,
-	transcodeQueue VideoTranscodeTaskChan,
+	transcodeJobRepo repository.TranscodeJobRepositoryInterface,
+	mediaInfoRepo repository.MediaInfoRepositoryInterface,
+	captionRepo repository.CaptionRepositoryInterface,
+	audioTrackRepo repository.AudioTrackRepositoryInterface,
+	videoKeyRepo repository.VideoKeyRepositoryInterface,
+	storage storage.Storage,
+	transcoder TranscoderInterface,
+	prober ProberInterface,
 ) *VideoService {
 	return &VideoService{
 		log:                 log,
 		cfg:                 cfg,
 		notificationService: notificationService,
+		event:               event,
 		videoRepo:           videoRepo,
-		transcodeQueue:      transcodeQueue,
+		transcodeJobRepo:    transcodeJobRepo,
+		mediaInfoRepo:       mediaInfoRepo,
+		captionRepo:         captionRepo,
+		audioTrackRepo:      audioTrackRepo,
+		videoKeyRepo:        videoKeyRepo,
+		storage:             storage,
+		transcoder:          transcoder,
+		prober:              prober,
+		mediaCache:          cache.New(cfg.VideoService.MediaCacheTTL, time.Minute),
+		transcodeWakeup:     make(chan struct{}, 1),
+		workers:             newTranscodeWorkerPool(),
+		shutdown:            make(chan struct{}),
 	}
 }
 
@@ -79,6 +141,8 @@ type VideoResponse struct {
 	Description string  `json:"description"`
 	Status      string  `json:"status,omitempty"`
 	Duration    float64 `json:"duration"`
+	// PosterURL is relative to the videos route the response was served from
+	PosterURL string `json:"poster_url,omitempty"`
 
 	Position *float64 `json:"position,omitempty"`
 
@@ -86,54 +150,53 @@ type VideoResponse struct {
 	UpdatedAt time.Time `json:"updated_at,omitempty"`
 }
 
-type VideoTranscodeTask struct {
-	UploadPath string
-	VideoID    int64
-	DstPath    string
-	ChunkHash  string
-}
-
-type VideoTranscodeTaskChan chan VideoTranscodeTask
+type TranscodeJobResponse struct {
+	ID        int64                   `json:"id"`
+	VideoUUID string                  `json:"video_uuid"`
+	State     string                  `json:"state"`
+	Attempts  int                     `json:"attempts"`
+	LastError string                  `json:"last_error,omitempty"`
+	Progress  types.TranscodeProgress `json:"progress"`
+	History   []TranscodeAttemptItem  `json:"history"`
 
-func NewVideoTranscodeTask() VideoTranscodeTaskChan {
-	return make(VideoTranscodeTaskChan, 50)
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
-func (s *VideoService) WaitForTranscodeVideoSignals() {
-	const op string = "VideoService.WaitForTranscodeVideoSignals"
-
-	// Quanta of work to be done by each worker
-	workerCount := s.cfg.VideoService.TranscodeVideoWorkerCount
+type VideoDetailsResponse struct {
+	VideoResponse
 
-	log := s.log.With(sl.String("op", op))
-
-	log.Info("Initializing worker pool")
-
-	for i := 0; i < workerCount; i++ {
-		go s.transcodeVideoWorker(i)
-	}
+	Source     *MediaInfoResponse  `json:"source"`
+	Renditions []MediaInfoResponse `json:"renditions"`
 }
 
-// transcodeVideoWorker is a method to transcode video worker
-func (s *VideoService) transcodeVideoWorker(workerID int) {
-	const op string = "VideoService.uploadVideoWorker"
-
-	log := s.log.With(
-		sl.String("op", op),
-		sl.Int("workerID", workerID),
-	)
-
-	log.Info("Worker started")
-
-	for task := range s.transcodeQueue {
-		log.Info("Processing upload", sl.Any("task", task))
-		if err := s.processTranscode(context.Background(), task.UploadPath, task.VideoID, task.DstPath, task.ChunkHash); err != nil {
-			log.Error("Failed to process upload", sl.Err(err))
-			return
-		}
-	}
+type MediaInfoResponse struct {
+	Label             string  `json:"label,omitempty"`
+	Container         string  `json:"container"`
+	Duration          float64 `json:"duration"`
+	Bitrate           int64   `json:"bitrate"`
+	PeakBitrate       int64   `json:"peak_bitrate,omitempty"`
+	Size              int64   `json:"size"`
+	SegmentCount      int     `json:"segment_count,omitempty"`
+	VideoCodec        string  `json:"video_codec"`
+	VideoProfile      string  `json:"video_profile,omitempty"`
+	VideoLevel        int     `json:"video_level,omitempty"`
+	Width             int     `json:"width"`
+	Height            int     `json:"height"`
+	Rotation          int     `json:"rotation,omitempty"`
+	SampleAspectRatio string  `json:"sample_aspect_ratio,omitempty"`
+	FrameRate         float64 `json:"frame_rate"`
+	AudioCodec        string  `json:"audio_codec,omitempty"`
+	AudioProfile      string  `json:"audio_profile,omitempty"`
+	AudioChannels     int     `json:"audio_channels,omitempty"`
+}
 
-	log.Info("Worker stopped")
+type TranscodeAttemptItem struct {
+	Attempt    int       `json:"attempt"`
+	Worker     string    `json:"worker"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
 }
 
 // ProcessSaveOrUpdateVideoPosition is a method to process save or update video position
@@ -191,8 +254,13 @@ func (s *VideoService) readFile(path string) ([]byte, error) {
 	return slurp, nil
 }
 
-// ProcessGetVideoPlayListByUUID is a method to process video playlist by UUID and return the video file
-func (s *VideoService) ProcessGetVideoPlayListByUUID(ctx context.Context, uuid string) ([]byte, error) {
+// ProcessGetVideoPlayListByUUID is a method to process video playlist by UUID and return the video file,
+// its URIs signed for access
+func (s *VideoService) ProcessGetVideoPlayListByUUID(
+	ctx context.Context,
+	uuid string,
+	access data.PlaylistAccess,
+) (MediaFile, error) {
 	const op string = "VideoService.ProcessGetVideoM3U8ByUUID"
 
 	log := s.log.With(
@@ -200,92 +268,139 @@ func (s *VideoService) ProcessGetVideoPlayListByUUID(ctx context.Context, uuid s
 		sl.String("uuid", uuid),
 	)
 
-	video, err := s.videoRepo.GetByUUID(ctx, uuid)
+	video, err := s.getPlayableVideo(ctx, uuid)
 	if err != nil {
 		log.Error("failed to get video by uuid", sl.Err(err))
-		return nil, errors.New("failed to get video by uuid")
+		return MediaFile{}, err
 	}
 
-	videoPath := fmt.Sprintf("%s/%s/%s/playlist.m3u8",
-		s.cfg.HTTPServer.StoragePath,
-		s.cfg.VideoService.VideoPath,
-		video.HashName,
-	)
+	playlist, err := s.readVideoPlaylist(ctx, video, "playlist.m3u8")
+	if err != nil {
+		log.Error("failed to read playlist", sl.Err(err))
+		return MediaFile{}, err
+	}
 
-	return s.readFile(videoPath)
+	return newPlaylistFile(s.signPlaylist(playlist, video.UUID, access), hlsPlaylistContentType), nil
 }
 
-// ProcessGetVideoM3U8 is a method to process video M3U8 and return the video file
-func (s *VideoService) ProcessGetVideoM3U8(url string) ([]byte, error) {
-	const op string = "VideoService.ProcessGetVideoM3U8"
+// ProcessGetVideoManifestByUUID is a method to process the DASH manifest of a video by UUID.
+// Only videos transcoded while DASH is enabled have one. Its URLs are signed for access like those of the master playlist.
+func (s *VideoService) ProcessGetVideoManifestByUUID(
+	ctx context.Context,
+	uuid string,
+	access data.PlaylistAccess,
+) (MediaFile, error) {
+	const op string = "VideoService.ProcessGetVideoManifestByUUID"
 
 	log := s.log.With(
 		sl.String("op", op),
-		sl.String("url", url),
+		sl.String("uuid", uuid),
 	)
 
-	var lastError error
+	if !s.cfg.VideoService.DASH {
+		log.Error("dash is disabled")
+		return MediaFile{}, errors.New("dash is disabled")
+	}
 
-	hashName, resolution, err := s.parseURL(url)
+	video, err := s.getPlayableVideo(ctx, uuid)
 	if err != nil {
-		log.Error("failed to parse hash", sl.Err(err))
-		return nil, errors.New("failed to parse hash")
+		log.Error("failed to get video by uuid", sl.Err(err))
+		return MediaFile{}, err
 	}
 
-	videoPath := fmt.Sprintf("%s/%s/%s/%s",
-		s.cfg.HTTPServer.StoragePath,
-		s.cfg.VideoService.VideoPath,
-		hashName,
-		resolution,
-	)
-
-	video, err := s.readFile(videoPath)
+	manifest, err := s.readVideoPlaylist(ctx, video, dashManifestFilename)
 	if err != nil {
-		log.Error("failed to read file", sl.Err(err))
+		log.Error("failed to read manifest", sl.Err(err))
+		return MediaFile{}, err
+	}
 
-		var resolutions = s.cfg.VideoService.Resolutions
+	manifest = s.signManifest(stripDASHBaseURL(manifest, video.UUID), video.UUID, access)
 
-		for _, res := range resolutions {
-			if res == resolution {
-				continue
-			}
+	return newPlaylistFile(manifest, dashManifestContentType), nil
+}
 
-			res = fmt.Sprintf("%s.m3u8", res)
-			videoPath = fmt.Sprintf("%s/%s/%s/%s", s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, hashName, res)
-			video, err = s.readFile(videoPath)
-			if err == nil {
-				return video, nil
-			}
-			lastError = err
-		}
+// ProcessGetVideoM3U8 is a method to process video M3U8 and return the video file, its URIs signed for access
+func (s *VideoService) ProcessGetVideoM3U8(ctx context.Context, url string, access data.PlaylistAccess) (MediaFile, error) {
+	const op string = "VideoService.ProcessGetVideoM3U8"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("url", url),
+	)
+
+	video, videoPath, err := s.resolveMedia(ctx, url, false)
+	if err != nil {
+		log.Error("failed to resolve playlist", sl.Err(err))
+		return MediaFile{}, err
+	}
 
-		log.Error("failed to find any suitable video file", sl.Err(lastError))
-		return nil, lastError
+	playlist, err := s.readStored(ctx, videoPath)
+	if err != nil {
+		log.Error("failed to read file", sl.Err(err))
+		if errors.Is(err, storage.ErrNotFound) {
+			return MediaFile{}, ErrVideoMediaNotFound
+		}
+		return MediaFile{}, errors.New("failed to read file")
 	}
 
-	return video, nil
+	return newPlaylistFile(s.signPlaylist(playlist, video.UUID, access), hlsPlaylistContentType), nil
 }
 
-// ProcessGetVideoTS is a method to process video TS and return the video file
-func (s *VideoService) ProcessGetVideoTS(ctx context.Context, url string) ([]byte, error) {
-	const op string = "VideoService.ProcessGetVideoTS"
+// ProcessGetVideoSegment is a method to process a file served next to the media playlists,
+// an MPEG-TS or fMP4 segment, an fMP4 initialization segment or a WebVTT file, and open it for streaming
+func (s *VideoService) ProcessGetVideoSegment(ctx context.Context, url string) (MediaFile, error) {
+	const op string = "VideoService.ProcessGetVideoSegment"
 
 	log := s.log.With(
 		sl.String("op", op),
 		sl.String("url", url),
 	)
 
-	hashName, resolution, err := s.parseURL(url)
+	_, videoPath, err := s.resolveMedia(ctx, url, true)
 	if err != nil {
-		log.Error("failed to parse hash", sl.Err(err))
-		return nil, errors.New("failed to parse hash")
+		log.Error("failed to resolve segment", sl.Err(err))
+		return MediaFile{}, err
 	}
 
-	videoPath := fmt.Sprintf("%s/%s/%s/%s", s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, hashName, resolution)
-	return s.readFile(videoPath)
+	contentType, ok := segmentContentTypes[filepath.Ext(videoPath)]
+	if !ok {
+		log.Error("unknown segment extension")
+		return MediaFile{}, ErrVideoMediaNotFound
+	}
+
+	segment, err := s.openStoredMedia(ctx, videoPath, contentType)
+	if err != nil {
+		log.Error("failed to open segment", sl.Err(err))
+		if errors.Is(err, storage.ErrNotFound) {
+			return MediaFile{}, ErrVideoMediaNotFound
+		}
+		return MediaFile{}, errors.New("failed to open segment")
+	}
+
+	return segment, nil
 }
 
-// parseURL is a method to parse the URL and return the hash and resolution
+// readVideoPlaylist is a method to read the master playlist or the DASH manifest of a video.
+// They are shared by the videos of a hash and reference its files under the hash,
+// which is swapped for the UUID of the video so every media request resolves through the video.
+func (s *VideoService) readVideoPlaylist(ctx context.Context, video types.Video, filename string) ([]byte, error) {
+	content, err := s.readStored(ctx, filepath.Join(
+		s.cfg.HTTPServer.StoragePath,
+		s.cfg.VideoService.VideoPath,
+		video.HashName,
+		filename,
+	))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrVideoMediaNotFound
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+
+	return bytes.ReplaceAll(content, []byte(video.HashName+"/"), []byte(video.UUID+"/")), nil
+}
+
+// parseURL is a method to parse the URL and return the video UUID and the filename
 func (s *VideoService) parseURL(pathstr string) (string, string, error) {
 	paths := strings.SplitN(strings.TrimLeft(pathstr, "/"), "/", -1)
 	if len(paths) < 2 {
@@ -294,7 +409,10 @@ func (s *VideoService) parseURL(pathstr string) (string, string, error) {
 	return paths[len(paths)-2], paths[len(paths)-1], nil
 }
 
-// ProcessUpload is a method to process video upload and store it in the storage path
+// ProcessUpload is a method to process video upload and store it in the storage path.
+// The source is stored under the SHA-256 of its content; when an identical source is already
+// processed or processing and DeduplicateUploads is enabled, the new video is linked to it
+// instead of being transcoded again.
 func (s *VideoService) ProcessUpload(
 	ctx context.Context,
 	data data.VideoUploadData,
@@ -316,13 +434,54 @@ func (s *VideoService) ProcessUpload(
 	uploadResult := s.uploadFile(data)
 	if uploadResult.Err != nil {
 		log.Error("failed to upload file", sl.Err(uploadResult.Err))
-		return errors.New("failed to upload file")
+		return fmt.Errorf("failed to upload file: %w", uploadResult.Err)
+	}
+
+	log = log.With(sl.String("hash", uploadResult.ChunkHash))
+
+	if s.cfg.VideoService.DeduplicateUploads {
+		existing, err := s.videoRepo.GetLatestByHashName(ctx, uploadResult.ChunkHash)
+		switch {
+		case err == nil:
+			return s.linkUpload(ctx, data, existing, uploadResult)
+		case !errors.Is(err, sql.ErrNoRows):
+			log.Error("failed to look up identical video", sl.Err(err))
+		}
 	}
 
-	duration, err := s.getVideoDuration(uploadResult.DestinationPath)
+	info, err := s.prober.Probe(ctx, uploadResult.DestinationPath)
+	if err != nil && !errors.Is(err, ErrUnreadableMedia) {
+		log.Error("failed to probe upload", sl.Err(err))
+		if rmErr := os.Remove(uploadResult.DestinationPath); rmErr != nil {
+			log.Error("failed to remove upload", sl.Err(rmErr))
+		}
+		return errors.New("failed to probe upload")
+	}
 	if err != nil {
-		log.Error("failed to get video duration", sl.Err(err))
-		return errors.New("failed to get video duration")
+		err = unreadableMediaError()
+	} else {
+		err = s.validateMedia(info)
+	}
+	if err != nil {
+		log.Warn("rejected upload", sl.Err(err))
+		if rmErr := os.Remove(uploadResult.DestinationPath); rmErr != nil {
+			log.Error("failed to remove rejected upload", sl.Err(rmErr))
+		}
+		return err
+	}
+
+	if err := s.storeUpload(ctx, &uploadResult, data.Filename); err != nil {
+		log.Error("failed to store upload", sl.Err(err))
+		if rmErr := os.Remove(uploadResult.DestinationPath); rmErr != nil {
+			log.Error("failed to remove upload", sl.Err(rmErr))
+		}
+		return errors.New("failed to store upload")
+	}
+
+	if err := s.storeSource(ctx, uploadResult.DestinationPath); err != nil {
+		log.Error("failed to store source", sl.Err(err))
+		s.discardUpload(ctx, uploadResult.UploadPath)
+		return errors.New("failed to store source")
 	}
 
 	video := types.Video{
@@ -330,61 +489,94 @@ func (s *VideoService) ProcessUpload(
 		Description: data.Description,
 		HashName:    uploadResult.ChunkHash,
 		Status:      enum.VideoStatusProcessing,
-		Duration:    duration,
+		Duration:    info.Duration,
 	}
 
-	videoID, err := s.videoRepo.Create(ctx, video)
-	if err != nil {
-		log.Error("failed to create video", sl.Err(err))
-		return errors.New("failed to create video")
+	sourceInfo := types.VideoMediaInfo{
+		HashName:  uploadResult.ChunkHash,
+		Kind:      enum.MediaInfoKindSource,
+		MediaInfo: info,
 	}
 
-	videoTranscodeTask := VideoTranscodeTask{
+	transcodeJob := types.TranscodeJob{
 		UploadPath: uploadResult.UploadPath,
-		VideoID:    videoID,
 		DstPath:    uploadResult.DestinationPath,
 		ChunkHash:  uploadResult.ChunkHash,
 	}
 
-	s.transcodeQueue <- videoTranscodeTask
+	if _, err := s.videoRepo.CreateWithTranscodeJob(ctx, video, sourceInfo, transcodeJob); err != nil {
+		log.Error("failed to create video", sl.Err(err))
+		s.discardUpload(ctx, uploadResult.UploadPath)
+		return errors.New("failed to create video")
+	}
+
+	select {
+	case s.transcodeWakeup <- struct{}{}:
+	default:
+	}
 
 	return nil
 }
 
-// getVideoDuration is a method to get the video duration using ffprobe
-func (s *VideoService) getVideoDuration(filePath string) (float64, error) {
-	const op string = "VideoService.getVideoDuration"
+// discardUpload is a method to remove the directory of an upload that did not make it to a video,
+// from the storage path and from the storage
+func (s *VideoService) discardUpload(ctx context.Context, uploadPath string) {
+	const op string = "VideoService.discardUpload"
 
 	log := s.log.With(
 		sl.String("op", op),
-		sl.String("file_path", filePath),
+		sl.String("upload_path", uploadPath),
 	)
 
-	cmd := exec.Command(
-		"ffprobe",
-		"-v",
-		"error",
-		"-show_entries",
-		"format=duration",
-		"-of",
-		"default=noprint_wrappers=1:nokey=1",
-		filePath,
+	if err := os.RemoveAll(uploadPath); err != nil {
+		log.Error("failed to remove upload folder", sl.Err(err))
+	}
+
+	if err := s.removeStored(ctx, uploadPath); err != nil {
+		log.Error("failed to remove stored upload", sl.Err(err))
+	}
+}
+
+// linkUpload is a method to create a video sharing the renditions of an identical existing video.
+// A video linked to one that is still processing follows the outcome of its transcode job.
+func (s *VideoService) linkUpload(
+	ctx context.Context,
+	data data.VideoUploadData,
+	existing types.Video,
+	uploadResult UploadResult,
+) error {
+	const op string = "VideoService.linkUpload"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("hash", uploadResult.ChunkHash),
+		sl.String("existing_uuid", existing.UUID),
 	)
-	out, err := cmd.Output()
-	if err != nil {
-		log.Error("error running ffprobe", sl.Err(err))
-		return 0, errors.New("error running ffprobe")
+
+	if err := os.Remove(uploadResult.DestinationPath); err != nil {
+		log.Error("failed to remove duplicate upload", sl.Err(err))
 	}
-	durationStr := strings.TrimSpace(string(out))
-	duration, err := strconv.ParseFloat(durationStr, 64)
-	if err != nil {
-		log.Error("error converting duration", sl.Err(err))
-		return 0, errors.New("error converting duration")
+
+	video := types.Video{
+		Name:        data.Name,
+		Description: data.Description,
+		HashName:    existing.HashName,
+		Status:      existing.Status,
+		Duration:    existing.Duration,
 	}
-	return duration, nil
+
+	if _, err := s.videoRepo.Create(ctx, video); err != nil {
+		log.Error("failed to create video", sl.Err(err))
+		return errors.New("failed to create video")
+	}
+
+	log.Info("linked upload to identical video")
+
+	return nil
 }
 
-// uploadFile is a method to upload file to the storage path
+// uploadFile is a method to stream the upload into a temporary file of the storage path,
+// sniffing its container, enforcing the maximum upload size and hashing its content on the way
 func (s *VideoService) uploadFile(data data.VideoUploadData) UploadResult {
 	const op string = "VideoService.uploadFile"
 
@@ -392,117 +584,260 @@ func (s *VideoService) uploadFile(data data.VideoUploadData) UploadResult {
 		sl.String("op", op),
 	)
 
-	chunkHash := _hash(fmt.Sprintf("%s-%d", data.Header.Filename, data.Header.Size))
+	source := bufio.NewReaderSize(newMaxSizeReader(data.File, s.cfg.UploadService.MaxSize), sniffLength)
 
-	uploadPath := fmt.Sprintf("%s/%s/%s", s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, chunkHash)
+	head, err := source.Peek(sniffLength)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Error("failed to read file", sl.Err(err))
+		return UploadResult{Err: fmt.Errorf("failed to read file: %w", err)}
+	}
 
-	if err := os.MkdirAll(uploadPath, 0755); err != nil {
-		log.Error("failed to create upload directory", sl.Err(err))
-		return UploadResult{Err: errors.New("failed to create upload directory")}
+	if contentType, ok := sniffVideo(head); !ok {
+		log.Error("unsupported media type", sl.String("content_type", contentType))
+		return UploadResult{Err: fmt.Errorf("%w: detected %s", ErrUnsupportedMediaType, contentType)}
 	}
 
-	if _, err := os.Stat(uploadPath); os.IsNotExist(err) {
-		err := os.Mkdir(uploadPath, os.ModePerm)
-		if err != nil {
-			log.Error("failed to create upload directory", sl.Err(err))
-			return UploadResult{Err: errors.New("failed to create upload directory")}
-		}
+	incomingPath := filepath.Join(s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, ".incoming")
+
+	if err := os.MkdirAll(incomingPath, 0755); err != nil {
+		log.Error("failed to create upload directory", sl.Err(err))
+		return UploadResult{Err: errors.New("failed to create upload directory")}
 	}
 
-	dstPath := filepath.Join(uploadPath, data.Header.Filename)
-	dst, err := os.Create(dstPath)
+	dst, err := os.CreateTemp(incomingPath, "upload-*")
 	if err != nil {
 		log.Error("failed to create file", sl.Err(err))
 		return UploadResult{Err: errors.New("failed to create file")}
 	}
 	defer func(dst *os.File) {
-		if err := dst.Close(); err != nil {
+		if err := dst.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
 			log.Error("failed to close file", sl.Err(err))
 		}
 	}(dst)
 
-	if _, err = io.Copy(dst, data.File); err != nil {
+	hash := sha256.New()
+
+	if _, err = io.Copy(io.MultiWriter(dst, hash), source); err != nil {
 		log.Error("failed to copy file", sl.Err(err))
-		return UploadResult{Err: errors.New("failed to copy file")}
+		if rmErr := os.Remove(dst.Name()); rmErr != nil {
+			log.Error("failed to remove partial file", sl.Err(rmErr))
+		}
+		return UploadResult{Err: fmt.Errorf("failed to copy file: %w", err)}
+	}
+
+	if err := dst.Close(); err != nil {
+		log.Error("failed to close file", sl.Err(err))
+		return UploadResult{Err: errors.New("failed to close file")}
 	}
 
 	return UploadResult{
-		DestinationPath: dstPath,
-		UploadPath:      uploadPath,
-		ChunkHash:       chunkHash,
+		DestinationPath: dst.Name(),
+		ChunkHash:       hex.EncodeToString(hash.Sum(nil)),
 		Err:             nil,
 	}
 }
 
-// processTranscode is a method to process video transcoding and chunking
-func (s *VideoService) processTranscode(
-	ctx context.Context,
-	uploadPath string,
-	videoID int64,
-	dstPath string,
-	chunkHash string,
-) error {
+// storeUpload is a method to move a hashed upload into the directory of its content hash.
+// A hash still used by another video, kept apart by disabled deduplication or soft deleted,
+// is swapped for a hash name of its own, so a new transcode never rewrites renditions being served.
+func (s *VideoService) storeUpload(ctx context.Context, uploadResult *UploadResult, filename string) error {
+	inUse, err := s.hashNameInUse(ctx, uploadResult.ChunkHash)
+	if err != nil {
+		return err
+	}
+
+	if inUse {
+		hashName, err := newDistinctHashName(uploadResult.ChunkHash)
+		if err != nil {
+			return fmt.Errorf("failed to generate hash name: %w", err)
+		}
+		uploadResult.ChunkHash = hashName
+	}
+
+	uploadPath := filepath.Join(s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, uploadResult.ChunkHash)
+
+	if err := os.MkdirAll(uploadPath, 0755); err != nil {
+		return fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	dstPath := filepath.Join(uploadPath, "source"+filepath.Ext(filepath.Base(filename)))
+	if err := os.Rename(uploadResult.DestinationPath, dstPath); err != nil {
+		_ = os.RemoveAll(uploadPath)
+		return fmt.Errorf("failed to move upload: %w", err)
+	}
+
+	uploadResult.UploadPath = uploadPath
+	uploadResult.DestinationPath = dstPath
+
+	return nil
+}
+
+// hashNameInUse is a method to report whether a video or a leftover directory already holds a hash name
+func (s *VideoService) hashNameInUse(ctx context.Context, hashName string) (bool, error) {
+	references, err := s.videoRepo.CountByHashName(ctx, hashName, 0)
+	if err != nil {
+		return false, fmt.Errorf("failed to count videos using the hash: %w", err)
+	}
+
+	if references > 0 {
+		return true, nil
+	}
+
+	_, err = os.Stat(filepath.Join(s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, hashName))
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	return false, nil
+}
+
+// newDistinctHashName returns a random hash name derived from a content hash, as long as a content hash
+// so it fits the hash_name columns
+func newDistinctHashName(contentHash string) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(append([]byte(contentHash), nonce...))
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// processTranscode is a method to process video transcoding and chunking.
+// It leaves cleanup of the source to the caller, so a failed attempt can be retried.
+func (s *VideoService) processTranscode(ctx context.Context, job types.TranscodeJob) error {
 	const op string = "VideoService.processTranscode"
 
 	log := s.log.With(
 		sl.String("op", op),
+		sl.Int64("job_id", job.ID),
+		sl.Int("attempt", job.Attempts),
 	)
 
-	uploadSuccessful := false
+	video, err := s.videoRepo.GetByID(ctx, job.VideoID)
+	if err != nil {
+		log.Error("failed to get video by id", sl.Err(err))
+		return fmt.Errorf("failed to get video by id: %w", err)
+	}
 
-	defer func() {
-		//TODO translate to romanian
-		notification := types.Notification{
-			Name:   "Upload Status",
-			Body:   "The upload was successful.",
-			Status: enum.NotificationStatusSuccess,
-			IsRead: false,
-		}
+	// The job may run on another instance than the one the source was uploaded to
+	if err := s.fetchSource(ctx, job.DstPath); err != nil {
+		log.Error("failed to fetch source", sl.Err(err))
+		return fmt.Errorf("failed to fetch source: %w", err)
+	}
 
-		if !uploadSuccessful {
-			notification.Body = "The upload has failed."
-			notification.Status = enum.NotificationStatusError
+	info, err := s.prober.Probe(ctx, job.DstPath)
+	if err != nil {
+		log.Error("failed to probe video", sl.Err(err))
+		return fmt.Errorf("failed to probe video: %w", err)
+	}
 
-			if updateErr := s.videoRepo.UpdateStatus(ctx, videoID, enum.VideoStatusFailed); updateErr != nil {
-				log.Error("failed to update video status to failed", sl.Err(updateErr))
-			}
+	renditions, err := s.buildRenditionLadder(info)
+	if err != nil {
+		log.Error("failed to build rendition ladder", sl.Err(err))
+		return fmt.Errorf("failed to build rendition ladder: %w", err)
+	}
 
-			if rmErr := os.RemoveAll(uploadPath); rmErr != nil {
-				log.Error("failed to remove folder", sl.Err(rmErr))
-			}
-		} else {
-			if rmErr := os.Remove(dstPath); rmErr != nil {
-				log.Error("failed to remove file after failed upload", sl.Err(rmErr))
-			}
+	// With DASH the renditions are video only, the audio of the source becomes the main audio track
+	// so the HLS audio group and the DASH audio adaptation set share its segments
+	separateAudio := s.cfg.VideoService.DASH && info.AudioCodec != ""
+
+	var labels []string
+	for i := range renditions {
+		renditions[i].SegmentFormat = s.cfg.VideoService.SegmentFormatFor(renditions[i].Label)
+		labels = append(labels, renditions[i].Label)
+	}
+	if separateAudio {
+		labels = append(labels, audioProgressLabel)
+	}
+
+	progress := s.newTranscodeProgressReporter(job, video.UUID, labels)
+	defer progress.flush(true)
+
+	if err := s.transcodeAndChunk(ctx, job.UploadPath, job.DstPath, info.Duration, renditions, separateAudio, progress); err != nil {
+		log.Error("failed to transcode and chunk video", sl.Err(err))
+		return fmt.Errorf("failed to transcode and chunk video: %w", err)
+	}
+
+	if separateAudio {
+		if err := s.transcodeMainAudio(ctx, job, info.Duration, progress); err != nil {
+			log.Error("failed to transcode main audio", sl.Err(err))
+			return fmt.Errorf("failed to transcode main audio: %w", err)
 		}
+	}
+
+	renditions, mediaInfos, err := s.measureRenditions(ctx, job.UploadPath, job.ChunkHash, renditions)
+	if err != nil {
+		log.Error("failed to measure renditions", sl.Err(err))
+		return fmt.Errorf("failed to measure renditions: %w", err)
+	}
 
-		if err := s.notificationService.ProcessNotification(ctx, notification); err != nil {
-			log.Error("failed to send notification", sl.Err(err))
+	for _, mediaInfo := range mediaInfos {
+		if err := s.mediaInfoRepo.Save(ctx, mediaInfo); err != nil {
+			log.Error("failed to save rendition media info", sl.String("resolution", mediaInfo.Label), sl.Err(err))
 		}
-	}()
+	}
 
-	if err := s.transcodeAndChunk(uploadPath, dstPath); err != nil {
-		log.Error("failed to transcode and chunk video", sl.Err(err))
-		return errors.New("failed to transcode and chunk video")
+	// Segments are encrypted once measured and probed, the padding barely changes the measured bandwidth
+	var playlists []string
+	for _, rendition := range renditions {
+		playlists = append(playlists, rendition.Label)
+	}
+
+	if err := s.encryptPlaylists(ctx, job.UploadPath, job.ChunkHash, playlists); err != nil {
+		log.Error("failed to encrypt renditions", sl.Err(err))
+		return fmt.Errorf("failed to encrypt renditions: %w", err)
+	}
+
+	if err := s.generateImages(ctx, job.UploadPath, job.DstPath, info); err != nil {
+		log.Error("failed to generate images", sl.Err(err))
+		return fmt.Errorf("failed to generate images: %w", err)
 	}
 
-	if err := s.createMasterM8U3PlayList(uploadPath, chunkHash); err != nil {
+	if err := writeRenditions(job.UploadPath, renditions); err != nil {
+		log.Error("failed to write renditions", sl.Err(err))
+		return fmt.Errorf("failed to write renditions: %w", err)
+	}
+
+	if err := s.regenerateMasterPlaylist(ctx, job.UploadPath, job.ChunkHash); err != nil {
 		log.Error("failed to create master m8u3 playlist", sl.Err(err))
-		return errors.New("failed to create master m8u3 playlist")
+		return fmt.Errorf("failed to create master m8u3 playlist: %w", err)
+	}
+
+	if err := s.publishHash(ctx, job.UploadPath); err != nil {
+		log.Error("failed to publish renditions", sl.Err(err))
+		return fmt.Errorf("failed to publish renditions: %w", err)
 	}
 
-	if err := s.videoRepo.UpdateStatus(ctx, videoID, enum.VideoStatusProcessed); err != nil {
+	if err := s.videoRepo.UpdateStatus(ctx, job.VideoID, enum.VideoStatusProcessed); err != nil {
 		log.Error("failed to update video status to processed", sl.Err(err))
-		return errors.New("failed to update video status to processed")
+		return fmt.Errorf("failed to update video status to processed: %w", err)
 	}
 
-	uploadSuccessful = true
+	if err := s.videoRepo.UpdateStatusByHashName(ctx, job.ChunkHash, enum.VideoStatusProcessing, enum.VideoStatusProcessed); err != nil {
+		log.Error("failed to update linked videos status to processed", sl.Err(err))
+	}
+
+	s.forgetPlayableHash(job.ChunkHash)
 
 	return nil
 }
 
-// transcodeAndChunk is a method to transcode and chunk video into smaller segments using ffmpeg
-func (s *VideoService) transcodeAndChunk(uploadPath, videoPath string) error {
+// transcodeAndChunk is a method to transcode and chunk video into smaller segments using ffmpeg.
+// With noAudio the renditions carry the video stream only.
+func (s *VideoService) transcodeAndChunk(
+	ctx context.Context,
+	uploadPath string,
+	videoPath string,
+	duration float64,
+	renditions []Rendition,
+	noAudio bool,
+	progress *transcodeProgressReporter,
+) error {
 	const op string = "VideoService.transcodeAndChunk"
 
 	log := s.log.With(
@@ -513,48 +848,115 @@ func (s *VideoService) transcodeAndChunk(uploadPath, videoPath string) error {
 
 	log.Info("transcoding and chunking video")
 
-	var resolutions []string
-	for res := range s.cfg.VideoService.Resolutions {
-		resolutions = append(resolutions, res)
-	}
+	for _, rendition := range renditions {
+		label := rendition.Label
+		outputPath := fmt.Sprintf("%s/%s.m3u8", uploadPath, label)
+		segmentFilename := filepath.Join(uploadPath, segmentPattern(label, rendition.SegmentFormat))
+		_, profile := s.cfg.VideoService.EncodingProfileFor(label)
+
+		req := TranscodeRequest{
+			InputPath:      videoPath,
+			PlaylistPath:   outputPath,
+			SegmentPattern: segmentFilename,
+			SegmentFormat:  rendition.SegmentFormat,
+			InitFilename:   initSegmentFilename(label),
+			NoAudio:        noAudio,
+			Rendition:      rendition,
+			Profile:        profile,
+			HLSTime:        s.cfg.VideoService.HLSTime,
+		}
 
-	sort.Slice(resolutions, func(i, j int) bool {
-		resI := strings.Split(resolutions[i], "x")
-		resJ := strings.Split(resolutions[j], "x")
-		if len(resI) < 2 || len(resJ) < 2 {
-			return false
+		err := s.transcoder.TranscodeHLS(ctx, req, func(outTime float64) {
+			if duration > 0 {
+				progress.report(label, outTime/duration*100)
+			}
+		})
+		if err != nil {
+			log.Error("failed to transcode video", sl.String("resolution", label), sl.Err(err))
+			return fmt.Errorf("failed to transcode video for resolution %s: %w", label, err)
 		}
-		heightI, _ := strconv.Atoi(resI[1])
-		heightJ, _ := strconv.Atoi(resJ[1])
-		return heightI < heightJ
-	})
 
-	for _, res := range resolutions {
-		label := s.cfg.VideoService.Resolutions[res]
-		outputPath := fmt.Sprintf("%s/%s.m3u8", uploadPath, label)
-		segmentFilename := fmt.Sprintf("%s/%s_%%03d.ts", uploadPath, label)
-		cmd := exec.Command("ffmpeg", "-i", videoPath,
-			"-profile:v", "baseline", "-level", "3.0",
-			"-s", res,
-			"-start_number", "0",
-			"-hls_time", "10",
-			"-hls_list_size", "0",
-			"-f", "hls",
-			"-hls_segment_filename",
-			segmentFilename,
-			outputPath)
-		if err := cmd.Run(); err != nil {
-			log.Error("failed to transcode video", sl.String("resolution", res), sl.Err(err))
-			return errors.New("failed to transcode video for resolution " + label)
-		}
-		log.Info("transcoded video", sl.String("resolution", label))
+		progress.report(label, 100)
+		log.Info("transcoded video",
+			sl.String("resolution", label),
+			sl.String("profile", rendition.Profile),
+			sl.Int("width", rendition.Width),
+			sl.Int("height", rendition.Height),
+		)
 	}
 
 	return nil
 }
 
-// createMasterM8U3PlayList is a method to create master m8u3 playlist
-func (s *VideoService) createMasterM8U3PlayList(uploadPath string, chunkHash string) error {
+// measureRenditions is a method to fill in the bandwidth, codecs and frame rate of the produced renditions.
+// It also returns the media info of every rendition as stored in video_media_info.
+func (s *VideoService) measureRenditions(
+	ctx context.Context,
+	uploadPath string,
+	hashName string,
+	renditions []Rendition,
+) ([]Rendition, []types.VideoMediaInfo, error) {
+	const op string = "VideoService.measureRenditions"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("upload_path", uploadPath),
+	)
+
+	measured := make([]Rendition, 0, len(renditions))
+	mediaInfos := make([]types.VideoMediaInfo, 0, len(renditions))
+
+	for _, rendition := range renditions {
+		playlistPath := filepath.Join(uploadPath, rendition.Label+".m3u8")
+
+		stats, err := measureMediaPlaylist(playlistPath)
+		if err != nil {
+			log.Error("failed to measure bandwidth", sl.String("resolution", rendition.Label), sl.Err(err))
+			return nil, nil, fmt.Errorf("failed to measure bandwidth of %s: %w", rendition.Label, err)
+		}
+
+		info, err := s.probeFirstSegment(ctx, playlistPath)
+		if err != nil {
+			log.Error("failed to probe segment", sl.String("resolution", rendition.Label), sl.Err(err))
+			return nil, nil, fmt.Errorf("failed to probe segment of %s: %w", rendition.Label, err)
+		}
+
+		rendition.Bandwidth = stats.PeakBitrate
+		rendition.AverageBandwidth = stats.AverageBitrate
+		rendition.Codecs = hlsCodecs(info)
+		rendition.FrameRate = info.FrameRate
+
+		measured = append(measured, rendition)
+
+		// The probe only saw the first segment, the totals come from the whole playlist
+		info.Container = "hls"
+		info.Duration = stats.Duration
+		info.Bitrate = stats.AverageBitrate
+		info.Size = stats.Size
+		info.Width = rendition.Width
+		info.Height = rendition.Height
+
+		mediaInfos = append(mediaInfos, types.VideoMediaInfo{
+			HashName:     hashName,
+			Kind:         enum.MediaInfoKindRendition,
+			Label:        rendition.Label,
+			MediaInfo:    info,
+			PeakBitrate:  stats.PeakBitrate,
+			SegmentCount: stats.SegmentCount,
+		})
+	}
+
+	return measured, mediaInfos, nil
+}
+
+// createMasterM8U3PlayList is a method to create master m8u3 playlist from the produced renditions
+func (s *VideoService) createMasterM8U3PlayList(
+	uploadPath string,
+	chunkHash string,
+	renditions []Rendition,
+	captions []types.VideoCaption,
+	audioTracks []types.VideoAudioTrack,
+) error {
 	const op string = "VideoService.createMasterM8U3PlayList"
 
 	log := s.log.With(
@@ -566,30 +968,101 @@ func (s *VideoService) createMasterM8U3PlayList(uploadPath string, chunkHash str
 
 	masterM8U3PlayListPath := fmt.Sprintf("%s/%s.m3u8", uploadPath, "playlist")
 
-	masterM8U3PlayList, err := os.Create(masterM8U3PlayListPath)
-	if err != nil {
-		log.Error("failed to create master m8u3 playlist", sl.Err(err))
-		return errors.New("failed to create master m8u3 playlist")
-	}
-	defer func(masterM8U3PlayList *os.File) {
-		if err := masterM8U3PlayList.Close(); err != nil {
-			log.Error("failed to close master m8u3 playlist", sl.Err(err))
-		}
-	}(masterM8U3PlayList)
-
 	var buffer bytes.Buffer
 	buffer.WriteString("#EXTM3U\n")
 	buffer.WriteString("#EXT-X-VERSION:3\n")
-	buffer.WriteString("#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\n")
-	buffer.WriteString(chunkHash + "/360.m3u8\n")
-	buffer.WriteString("#EXT-X-STREAM-INF:BANDWIDTH=1400000,RESOLUTION=854x480\n")
-	buffer.WriteString(chunkHash + "/480.m3u8\n")
-	buffer.WriteString("#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720\n")
-	buffer.WriteString(chunkHash + "/720.m3u8\n")
-	buffer.WriteString("#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080\n")
-	buffer.WriteString(chunkHash + "/1080.m3u8\n")
-
-	if _, err := masterM8U3PlayList.Write(buffer.Bytes()); err != nil {
+	buffer.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	for _, caption := range captions {
+		isDefault := "NO"
+		if caption.IsDefault {
+			isDefault = "YES"
+		}
+
+		buffer.WriteString(fmt.Sprintf(
+			"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"%s\",NAME=\"%s\",LANGUAGE=\"%s\",DEFAULT=%s,AUTOSELECT=YES,URI=\"%s\"\n",
+			subtitleGroupID,
+			caption.Name,
+			caption.Language,
+			isDefault,
+			chunkHash+"/"+captionPlaylistFilename(caption.Language),
+		))
+	}
+
+	// The audio muxed into the renditions is listed without a URI next to the alternate tracks, unless the
+	// renditions are video only and the audio of the source is the main track. A variant is announced with
+	// the bandwidth of its heaviest audio choice.
+	var audioBandwidth, audioAverageBandwidth int64
+	var audioCodecs []string
+
+	hasDefault := slices.ContainsFunc(audioTracks, func(track types.VideoAudioTrack) bool { return track.IsDefault })
+	hasMain := slices.ContainsFunc(audioTracks, func(track types.VideoAudioTrack) bool {
+		return track.Kind == enum.AudioTrackKindMain
+	})
+
+	if len(audioTracks) > 0 && !hasMain {
+		isDefault := "YES"
+		if hasDefault {
+			isDefault = "NO"
+		}
+
+		buffer.WriteString(fmt.Sprintf(
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"%s\",NAME=\"%s\",DEFAULT=%s,AUTOSELECT=YES\n",
+			audioGroupID,
+			originalAudioName,
+			isDefault,
+		))
+	}
+
+	for _, track := range audioTracks {
+		isDefault := "NO"
+		if track.IsDefault || !hasDefault && track.Kind == enum.AudioTrackKindMain {
+			isDefault = "YES"
+		}
+
+		language := ""
+		if track.Language != "" {
+			language = fmt.Sprintf(",LANGUAGE=\"%s\"", track.Language)
+		}
+
+		buffer.WriteString(fmt.Sprintf(
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"%s\",NAME=\"%s\"%s,DEFAULT=%s,AUTOSELECT=YES,URI=\"%s\"\n",
+			audioGroupID,
+			track.Name,
+			language,
+			isDefault,
+			chunkHash+"/"+audioPlaylistFilename(track.ID),
+		))
+
+		audioBandwidth = max(audioBandwidth, track.Bandwidth)
+		audioAverageBandwidth = max(audioAverageBandwidth, track.AverageBandwidth)
+		audioCodecs = append(audioCodecs, track.Codecs)
+	}
+
+	for _, rendition := range renditions {
+		buffer.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d",
+			rendition.Bandwidth+audioBandwidth,
+			rendition.AverageBandwidth+audioAverageBandwidth,
+		))
+		if codecs := mergeCodecs(rendition.Codecs, audioCodecs...); codecs != "" {
+			buffer.WriteString(fmt.Sprintf(",CODECS=\"%s\"", codecs))
+		}
+		buffer.WriteString(fmt.Sprintf(",RESOLUTION=%dx%d", rendition.Width, rendition.Height))
+		if rendition.FrameRate > 0 {
+			buffer.WriteString(fmt.Sprintf(",FRAME-RATE=%.3f", rendition.FrameRate))
+		}
+		if len(audioTracks) > 0 {
+			buffer.WriteString(fmt.Sprintf(",AUDIO=\"%s\"", audioGroupID))
+		}
+		if len(captions) > 0 {
+			buffer.WriteString(fmt.Sprintf(",SUBTITLES=\"%s\"", subtitleGroupID))
+		}
+		buffer.WriteString("\n")
+		buffer.WriteString(chunkHash + "/" + rendition.Label + ".m3u8\n")
+	}
+
+	// The master of a live video is rewritten by caption and audio track edits while it is being served
+	if err := writeFileAtomic(masterM8U3PlayListPath, buffer.Bytes()); err != nil {
 		log.Error("failed to write master m8u3 playlist", sl.Err(err))
 		return errors.New("failed to write master m8u3 playlist")
 	}
@@ -597,35 +1070,44 @@ func (s *VideoService) createMasterM8U3PlayList(uploadPath string, chunkHash str
 	return nil
 }
 
-// getBitrate is a method to get the bitrate of the video
-func (s *VideoService) getBitrate(filePath string) (int64, error) {
-	const op string = "VideoService.getBitrate"
-
-	log := s.log.With(
-		sl.String("op", op),
-	)
-	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=bit_rate", "-of", "default=noprint_wrappers=1:nokey=1", filePath)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// regenerateMasterPlaylist is a method to rewrite the master playlist of a hash, and its DASH manifest
+// when the renditions are fMP4, with its current captions and ready audio tracks. Nothing is written while the hash is still transcoding,
+// processTranscode picks them up.
+func (s *VideoService) regenerateMasterPlaylist(ctx context.Context, uploadPath string, hashName string) error {
+	renditions, err := readRenditions(uploadPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read renditions: %w", err)
+	}
 
-	err := cmd.Run()
+	captions, err := s.captionRepo.GetByHashName(ctx, hashName)
 	if err != nil {
-		log.Error("error running ffprobe", sl.Err(err), sl.String("stderr", stderr.String()))
-		return 0, errors.New("error running ffprobe")
+		return fmt.Errorf("failed to get captions: %w", err)
 	}
 
-	bitrate, err := strconv.Atoi(strings.TrimSpace(stdout.String()))
+	audioTracks, err := s.audioTrackRepo.GetReadyByHashName(ctx, hashName)
 	if err != nil {
-		log.Error("error converting bitrate", sl.Err(err))
-		return 0, errors.New("error converting bitrate")
+		return fmt.Errorf("failed to get audio tracks: %w", err)
+	}
+
+	if err := s.createMasterM8U3PlayList(uploadPath, hashName, renditions, captions, audioTracks); err != nil {
+		return err
+	}
+
+	if !s.cfg.VideoService.DASH || !dashPackaged(renditions) {
+		return nil
+	}
+
+	if err := writeDASHManifest(uploadPath, renditions, captions, audioTracks); err != nil {
+		return fmt.Errorf("failed to write dash manifest: %w", err)
 	}
 
-	return int64(bitrate), err
+	return nil
 }
 
-// ProcessDeleteVideo is a method to process video deletion
+// ProcessDeleteVideo is a method to process video deletion, whatever the status of the video
 func (s *VideoService) ProcessDeleteVideo(ctx context.Context, uuid string) error {
 	const op string = "VideoService.ProcessDeleteVideo"
 
@@ -634,21 +1116,80 @@ func (s *VideoService) ProcessDeleteVideo(ctx context.Context, uuid string) erro
 		sl.String("uuid", uuid),
 	)
 
-	video, err := s.videoRepo.GetByUUID(ctx, uuid)
+	video, err := s.videoRepo.GetByUUIDAnyStatus(ctx, uuid)
 	if err != nil {
 		log.Error("failed to get video by uuid", sl.Err(err))
 		return errors.New("failed to get video by uuid")
 	}
 
+	// The job of a video still being transcoded is cancelled first, so no worker picks it up once its files are gone.
+	// A running job stops and cleans up after itself like any cancelled job.
+	job, err := s.transcodeJobRepo.GetLatestByVideoID(ctx, video.ID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		log.Error("failed to get transcode job", sl.Err(err))
+		return errors.New("failed to get transcode job")
+	default:
+		err := s.transcodeJobRepo.Cancel(ctx, job.ID)
+		switch {
+		case err == nil:
+			if cancelJob, ok := s.runningJobs.Load(job.ID); ok {
+				cancelJob.(context.CancelCauseFunc)(ErrTranscodeJobCancelled)
+			}
+		case !errors.Is(err, ErrTranscodeJobNotCancellable):
+			log.Error("failed to cancel transcode job", sl.Err(err))
+			return errors.New("failed to cancel transcode job")
+		}
+	}
+
+	references, err := s.videoRepo.CountByHashName(ctx, video.HashName, video.ID)
+	if err != nil {
+		log.Error("failed to count videos using the renditions", sl.Err(err))
+		return errors.New("failed to count videos using the renditions")
+	}
+
+	// The row goes first, so a failure below leaves files behind rather than a video without them
+	if err := s.videoRepo.Delete(ctx, video.ID); err != nil {
+		log.Error("failed to delete video", sl.Err(err))
+		return errors.New("failed to delete video")
+	}
+
+	s.mediaCache.Delete(video.UUID)
+
+	// Renditions shared with deduplicated uploads are kept until their last video is deleted
+	if references > 0 {
+		if video.Poster != "" {
+			s.removePoster(ctx, filepath.Join(s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, video.HashName, imagesDir), video.Poster)
+		}
+		return nil
+	}
+
 	videoPath := fmt.Sprintf("%s/%s/%s", s.cfg.HTTPServer.StoragePath, s.cfg.VideoService.VideoPath, video.HashName)
 	if err := os.RemoveAll(videoPath); err != nil {
 		log.Error("failed to remove video folder", sl.Err(err))
 		return errors.New("failed to remove video folder")
 	}
 
-	if err := s.videoRepo.Delete(ctx, video.ID); err != nil {
-		log.Error("failed to delete video", sl.Err(err))
-		return errors.New("failed to delete video")
+	if err := s.removeStored(ctx, videoPath); err != nil {
+		log.Error("failed to remove stored video folder", sl.Err(err))
+		return errors.New("failed to remove stored video folder")
+	}
+
+	if err := s.mediaInfoRepo.DeleteByHashName(ctx, video.HashName); err != nil {
+		log.Error("failed to delete media info", sl.Err(err))
+	}
+
+	if err := s.captionRepo.DeleteByHashName(ctx, video.HashName); err != nil {
+		log.Error("failed to delete captions", sl.Err(err))
+	}
+
+	if err := s.audioTrackRepo.DeleteByHashName(ctx, video.HashName); err != nil {
+		log.Error("failed to delete audio tracks", sl.Err(err))
+	}
+
+	if err := s.videoKeyRepo.DeleteByHashName(ctx, video.HashName); err != nil {
+		log.Error("failed to delete video keys", sl.Err(err))
 	}
 
 	return nil
@@ -674,6 +1215,8 @@ func (s *VideoService) ProcessSoftDeleteVideo(ctx context.Context, uuid string)
 		return errors.New("failed to soft delete video")
 	}
 
+	s.mediaCache.Delete(video.UUID)
+
 	return nil
 }
 
@@ -700,6 +1243,7 @@ func (s *VideoService) ProcessGetVideoList(ctx context.Context) ([]VideoResponse
 			Description: video.Description,
 			Status:      video.Status.String(),
 			Duration:    video.Duration,
+			PosterURL:   posterURL(video),
 			CreatedAt:   video.CreatedAt,
 			UpdatedAt:   video.UpdatedAt,
 		}
@@ -739,6 +1283,7 @@ func (s *VideoService) ProcessGetVideoListWithPosition(
 			Name:        video.Name,
 			Description: video.Description,
 			Duration:    video.Duration,
+			PosterURL:   posterURL(video),
 			CreatedAt:   video.CreatedAt,
 		}
 
@@ -769,6 +1314,172 @@ func (s *VideoService) ProcessUpdateVideoInfo(ctx context.Context, video types.V
 	return nil
 }
 
+// ProcessGetVideoJob is a method to process getting the latest transcode job of a video with its progress
+func (s *VideoService) ProcessGetVideoJob(ctx context.Context, uuid string) (TranscodeJobResponse, error) {
+	const op string = "VideoService.ProcessGetVideoJob"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+	)
+
+	video, err := s.videoRepo.GetByUUIDAnyStatus(ctx, uuid)
+	if err != nil {
+		log.Error("failed to get video by uuid", sl.Err(err))
+		return TranscodeJobResponse{}, errors.New("failed to get video by uuid")
+	}
+
+	job, err := s.transcodeJobRepo.GetLatestByVideoID(ctx, video.ID)
+	if err != nil {
+		log.Error("failed to get transcode job", sl.Err(err))
+		return TranscodeJobResponse{}, errors.New("failed to get transcode job")
+	}
+
+	attempts, err := s.transcodeJobRepo.GetAttempts(ctx, job.ID)
+	if err != nil {
+		log.Error("failed to get transcode job attempts", sl.Err(err))
+		return TranscodeJobResponse{}, errors.New("failed to get transcode job attempts")
+	}
+
+	resp := TranscodeJobResponse{
+		ID:        job.ID,
+		VideoUUID: video.UUID,
+		State:     job.State.String(),
+		Attempts:  job.Attempts,
+		LastError: job.LastError,
+		Progress:  job.Progress,
+		History:   []TranscodeAttemptItem{},
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+
+	for _, attempt := range attempts {
+		resp.History = append(resp.History, TranscodeAttemptItem{
+			Attempt:    attempt.Attempt,
+			Worker:     attempt.Worker,
+			Error:      attempt.Error,
+			StartedAt:  attempt.StartedAt,
+			FinishedAt: attempt.FinishedAt,
+		})
+	}
+
+	return resp, nil
+}
+
+// ProcessGetVideoDetails is a method to process getting a video with the media info of its source and renditions
+func (s *VideoService) ProcessGetVideoDetails(ctx context.Context, uuid string) (VideoDetailsResponse, error) {
+	const op string = "VideoService.ProcessGetVideoDetails"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+	)
+
+	video, err := s.videoRepo.GetByUUIDAnyStatus(ctx, uuid)
+	if err != nil {
+		log.Error("failed to get video by uuid", sl.Err(err))
+		return VideoDetailsResponse{}, errors.New("failed to get video by uuid")
+	}
+
+	mediaInfos, err := s.mediaInfoRepo.GetByHashName(ctx, video.HashName)
+	if err != nil {
+		log.Error("failed to get media info", sl.Err(err))
+		return VideoDetailsResponse{}, errors.New("failed to get media info")
+	}
+
+	resp := VideoDetailsResponse{
+		VideoResponse: VideoResponse{
+			UUID:        video.UUID,
+			Name:        video.Name,
+			Description: video.Description,
+			Status:      video.Status.String(),
+			Duration:    video.Duration,
+			PosterURL:   posterURL(video),
+			CreatedAt:   video.CreatedAt,
+			UpdatedAt:   video.UpdatedAt,
+		},
+		Renditions: []MediaInfoResponse{},
+	}
+
+	for _, mediaInfo := range mediaInfos {
+		item := MediaInfoResponse{
+			Label:             mediaInfo.Label,
+			Container:         mediaInfo.Container,
+			Duration:          mediaInfo.Duration,
+			Bitrate:           mediaInfo.Bitrate,
+			PeakBitrate:       mediaInfo.PeakBitrate,
+			Size:              mediaInfo.Size,
+			SegmentCount:      mediaInfo.SegmentCount,
+			VideoCodec:        mediaInfo.VideoCodec,
+			VideoProfile:      mediaInfo.VideoProfile,
+			VideoLevel:        mediaInfo.VideoLevel,
+			Width:             mediaInfo.Width,
+			Height:            mediaInfo.Height,
+			Rotation:          mediaInfo.Rotation,
+			SampleAspectRatio: mediaInfo.SampleAspectRatio,
+			FrameRate:         mediaInfo.FrameRate,
+			AudioCodec:        mediaInfo.AudioCodec,
+			AudioProfile:      mediaInfo.AudioProfile,
+			AudioChannels:     mediaInfo.AudioChannels,
+		}
+
+		switch mediaInfo.Kind {
+		case enum.MediaInfoKindSource:
+			resp.Source = &item
+		case enum.MediaInfoKindRendition:
+			resp.Renditions = append(resp.Renditions, item)
+		}
+	}
+
+	return resp, nil
+}
+
+// ProcessCancelVideoJob is a method to cancel the pending or running transcode job of a video.
+// A job running in this process is stopped right away, one running on another node
+// is stopped by its worker on the next lease heartbeat.
+func (s *VideoService) ProcessCancelVideoJob(ctx context.Context, uuid string) error {
+	const op string = "VideoService.ProcessCancelVideoJob"
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("uuid", uuid),
+	)
+
+	video, err := s.videoRepo.GetByUUIDAnyStatus(ctx, uuid)
+	if err != nil {
+		log.Error("failed to get video by uuid", sl.Err(err))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTranscodeJobNotFound
+		}
+		return errors.New("failed to get video by uuid")
+	}
+
+	job, err := s.transcodeJobRepo.GetLatestByVideoID(ctx, video.ID)
+	if err != nil {
+		log.Error("failed to get transcode job", sl.Err(err))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTranscodeJobNotFound
+		}
+		return errors.New("failed to get transcode job")
+	}
+
+	if err := s.transcodeJobRepo.Cancel(ctx, job.ID); err != nil {
+		log.Error("failed to cancel transcode job", sl.Err(err))
+		return fmt.Errorf("failed to cancel transcode job: %w", err)
+	}
+
+	if job.State == enum.TranscodeJobStatePending {
+		s.finishCancelledTranscodeJob(ctx, job)
+		return nil
+	}
+
+	if cancelJob, ok := s.runningJobs.Load(job.ID); ok {
+		cancelJob.(context.CancelCauseFunc)(ErrTranscodeJobCancelled)
+	}
+
+	return nil
+}
+
 // ProcessGetVideoPosition is a method to process getting video position
 func (s *VideoService) ProcessGetVideoPosition(ctx context.Context, userID int64, videoUUID string) (float64, error) {
 	const op string = "VideoService.ProcessGetVideoPosition"
@@ -792,8 +1503,14 @@ func (s *VideoService) ProcessGetVideoPosition(ctx context.Context, userID int64
 	return position.Position, nil
 }
 
-func _hash(s string) string {
-	h := sha256.New()
-	h.Write([]byte(s))
-	return fmt.Sprintf("%x", h.Sum(nil))
+// stderrTail returns the last lines of ffmpeg/ffprobe stderr, which usually hold the actual error
+func stderrTail(stderr string) string {
+	const maxLines = 5
+
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	if len(lines) > maxLines {
+		lines = lines[len(lines)-maxLines:]
+	}
+
+	return strings.Join(lines, "\n")
 }
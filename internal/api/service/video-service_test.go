@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"go-fitness/internal/api/enum"
+	"go-fitness/internal/api/types"
+	"os"
+	"testing"
+)
+
+func TestProcessDeleteVideo(t *testing.T) {
+	t.Run("processing", func(t *testing.T) {
+		s := newTestVideoService(newTestConfig(t), NewFakeTranscoder())
+		s.upload(t)
+
+		ctx := context.Background()
+
+		video, err := s.videos.GetByID(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if video.Status != enum.VideoStatusProcessing {
+			t.Fatalf("video status = %s, want processing", video.Status)
+		}
+
+		if err := s.ProcessDeleteVideo(ctx, video.UUID); err != nil {
+			t.Fatalf("ProcessDeleteVideo: %v", err)
+		}
+
+		job, err := s.jobs.GetByID(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State != enum.TranscodeJobStateCancelled {
+			t.Errorf("job state = %s, want cancelled", job.State)
+		}
+
+		if _, err := s.videos.GetByUUIDAnyStatus(ctx, video.UUID); err == nil {
+			t.Error("the video was not deleted")
+		}
+		if _, err := os.Stat(s.hashPath(video)); !os.IsNotExist(err) {
+			t.Errorf("the hash directory was not removed: %v", err)
+		}
+	})
+
+	t.Run("processed", func(t *testing.T) {
+		s := newTestVideoService(newTestConfig(t), NewFakeTranscoder())
+		s.startWorkers(t)
+		s.upload(t)
+
+		if notification := s.waitForNotification(t); notification.Status != enum.NotificationStatusSuccess {
+			t.Fatalf("transcode failed: %s", notification.Body)
+		}
+
+		ctx := context.Background()
+
+		video, err := s.videos.GetByID(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.ProcessDeleteVideo(ctx, video.UUID); err != nil {
+			t.Fatalf("ProcessDeleteVideo: %v", err)
+		}
+
+		job, err := s.jobs.GetByID(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State != enum.TranscodeJobStateCompleted {
+			t.Errorf("job state = %s, want it left completed", job.State)
+		}
+
+		if _, err := os.Stat(s.hashPath(video)); !os.IsNotExist(err) {
+			t.Errorf("the hash directory was not removed: %v", err)
+		}
+	})
+
+	t.Run("sharing its renditions", func(t *testing.T) {
+		s := newTestVideoService(newTestConfig(t), NewFakeTranscoder())
+
+		ctx := context.Background()
+
+		var videos []types.Video
+		for range 2 {
+			id, err := s.videos.Create(ctx, types.Video{HashName: "abc", Status: enum.VideoStatusProcessed})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			video, err := s.videos.GetByID(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			videos = append(videos, video)
+		}
+
+		if err := os.MkdirAll(s.hashPath(videos[0]), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.ProcessDeleteVideo(ctx, videos[0].UUID); err != nil {
+			t.Fatalf("ProcessDeleteVideo: %v", err)
+		}
+
+		if _, err := os.Stat(s.hashPath(videos[0])); err != nil {
+			t.Errorf("the renditions of the remaining video were removed: %v", err)
+		}
+		if _, err := s.videos.GetByUUIDAnyStatus(ctx, videos[1].UUID); err != nil {
+			t.Errorf("the remaining video: %v", err)
+		}
+	})
+}
@@ -0,0 +1,370 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-fitness/external/config"
+	"go-fitness/external/logger/sl"
+	"go-fitness/external/storage"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The storage holds every file of a hash directory under the key of its path relative to the storage path.
+// With the local driver it is the storage path itself. With a remote driver the storage path is only the
+// working directory of the transcoder: sources are stored as soon as they are uploaded, the generated files
+// are published once a job or an edit of the hash is done, and the segments are then pruned locally.
+
+// storedSourceRe matches the uploaded sources of a hash directory, which are stored on their own
+var storedSourceRe = regexp.MustCompile(`^(?:source|audio_\d+_source)\.`)
+
+// remoteStorage is a method to report whether the storage lives outside of the storage path
+func (s *VideoService) remoteStorage() bool {
+	return s.cfg.Storage.Driver != config.StorageDriverLocal
+}
+
+// storageKey is a method to get the storage key of a file of the storage path
+func (s *VideoService) storageKey(path string) (string, error) {
+	rel, err := filepath.Rel(s.cfg.HTTPServer.StoragePath, path)
+	if err != nil {
+		return "", err
+	}
+
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the storage path", path)
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+// storageFilePath is a method to get the file of the storage path a storage key is mirrored to
+func (s *VideoService) storageFilePath(key string) string {
+	return filepath.Join(s.cfg.HTTPServer.StoragePath, filepath.FromSlash(key))
+}
+
+// openStoredMedia is a method to open a stored file for streaming, validated by the ETag of the storage.
+// With a presign TTL configured, the file is redirected to a presigned URL of the storage instead.
+func (s *VideoService) openStoredMedia(ctx context.Context, path string, contentType string) (MediaFile, error) {
+	key, err := s.storageKey(path)
+	if err != nil {
+		return MediaFile{}, err
+	}
+
+	if ttl := s.cfg.Storage.PresignTTL; ttl > 0 {
+		location, err := s.storage.Presign(ctx, key, ttl)
+		if err != nil {
+			return MediaFile{}, fmt.Errorf("failed to presign %s: %w", key, err)
+		}
+
+		return MediaFile{Location: location}, nil
+	}
+
+	info, err := s.storage.Stat(ctx, key)
+	if err != nil {
+		return MediaFile{}, err
+	}
+
+	// The object is read while the response is written, past the deadline of the request context
+	return MediaFile{
+		Content:     storage.NewReader(context.WithoutCancel(ctx), s.storage, info),
+		ContentType: contentType,
+		ModTime:     info.ModTime,
+		ETag:        info.ETag,
+	}, nil
+}
+
+// readStored is a method to read a whole stored file
+func (s *VideoService) readStored(ctx context.Context, path string) ([]byte, error) {
+	key, err := s.storageKey(path)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := s.storage.Get(ctx, key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+// storeSource is a method to store an uploaded source, so any transcoder can fetch it
+func (s *VideoService) storeSource(ctx context.Context, path string) error {
+	if !s.remoteStorage() {
+		return nil
+	}
+
+	return s.putStored(ctx, path)
+}
+
+// fetchSource is a method to download the source of a job unless the storage path already has it
+func (s *VideoService) fetchSource(ctx context.Context, path string) error {
+	if !s.remoteStorage() {
+		return nil
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	key, err := s.storageKey(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.storage.Stat(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to stat source: %w", err)
+	}
+
+	return s.getStored(ctx, info)
+}
+
+// publishHash is a method to upload the generated files of a hash directory that are missing from the storage
+// or were modified since they were stored, and to prune the segments from the storage path.
+// Stored files are never removed here, files of the hash that are gone go through removeStored.
+func (s *VideoService) publishHash(ctx context.Context, uploadPath string) error {
+	const op string = "VideoService.publishHash"
+
+	if !s.remoteStorage() {
+		return nil
+	}
+
+	log := s.log.With(
+		sl.String("op", op),
+		sl.String("upload_path", uploadPath),
+	)
+
+	stored, err := s.listStored(ctx, uploadPath)
+	if err != nil {
+		return err
+	}
+
+	var segments []string
+
+	err = filepath.WalkDir(uploadPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(entry.Name(), ".") && path != uploadPath {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !entry.Type().IsRegular() || storedSourceRe.MatchString(entry.Name()) {
+			return nil
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		key, err := s.storageKey(path)
+		if err != nil {
+			return err
+		}
+
+		if isSegmentFile(entry.Name()) {
+			segments = append(segments, path)
+		}
+
+		// A copy older than the stored one is stale, another instance edited the hash since
+		if object, ok := stored[key]; ok && !stat.ModTime().After(object.ModTime) {
+			return nil
+		}
+
+		return s.putStored(ctx, path)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish %s: %w", uploadPath, err)
+	}
+
+	for _, segment := range segments {
+		if err := os.Remove(segment); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Error("failed to prune published segment", sl.String("segment", segment), sl.Err(err))
+		}
+	}
+
+	return nil
+}
+
+// restoreHash is a method to download the stored files of a hash directory that the storage path lacks
+// or holds an older copy of, so its master playlist can be regenerated on any instance.
+// Segments and sources are left in the storage.
+func (s *VideoService) restoreHash(ctx context.Context, uploadPath string) error {
+	if !s.remoteStorage() {
+		return nil
+	}
+
+	stored, err := s.listStored(ctx, uploadPath)
+	if err != nil {
+		return err
+	}
+
+	for key, object := range stored {
+		name := filepath.Base(s.storageFilePath(key))
+		if isSegmentFile(name) || storedSourceRe.MatchString(name) {
+			continue
+		}
+
+		stat, err := os.Stat(s.storageFilePath(key))
+		if err == nil && stat.Size() == object.Size && !object.ModTime.After(stat.ModTime()) {
+			continue
+		}
+
+		if err := s.getStored(ctx, object); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// removeStored is a method to remove the stored files of a directory whose names start with one of prefixes,
+// or the whole directory without prefixes
+func (s *VideoService) removeStored(ctx context.Context, dir string, prefixes ...string) error {
+	if !s.remoteStorage() {
+		return nil
+	}
+
+	key, err := s.storageKey(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	for _, prefix := range prefixes {
+		if err := s.storage.DeletePrefix(ctx, key+"/"+prefix); err != nil {
+			return fmt.Errorf("failed to remove %s/%s: %w", key, prefix, err)
+		}
+	}
+
+	return nil
+}
+
+// listStored is a method to list the stored files of a directory by key
+func (s *VideoService) listStored(ctx context.Context, dir string) (map[string]storage.ObjectInfo, error) {
+	key, err := s.storageKey(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := s.storage.List(ctx, key+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", key, err)
+	}
+
+	stored := make(map[string]storage.ObjectInfo, len(objects))
+	for _, object := range objects {
+		stored[object.Key] = object
+	}
+
+	return stored, nil
+}
+
+// putStored is a method to upload a file of the storage path under its key
+func (s *VideoService) putStored(ctx context.Context, path string) error {
+	key, err := s.storageKey(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return s.storage.Put(ctx, key, file, stat.Size(), storedContentType(path))
+}
+
+// getStored is a method to download a stored file to its path, with the modification time of the storage
+// so it is not published back unless it changes
+func (s *VideoService) getStored(ctx context.Context, object storage.ObjectInfo) error {
+	path := s.storageFilePath(object.Key)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	body, err := s.storage.Get(ctx, object.Key, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(tmp.Name(), object.ModTime, object.ModTime); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// isSegmentFile reports whether a file is a media segment, which is only read by players once published
+func isSegmentFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".ts", ".m4s":
+		return true
+	}
+
+	return false
+}
+
+// storedContentType returns the content type a file is stored with
+func storedContentType(path string) string {
+	name := filepath.Base(path)
+
+	if filepath.Base(filepath.Dir(path)) == imagesDir {
+		return videoImageContentType(name)
+	}
+
+	switch ext := filepath.Ext(name); ext {
+	case ".m3u8":
+		return hlsPlaylistContentType
+	case ".mpd":
+		return dashManifestContentType
+	case ".json":
+		return "application/json"
+	default:
+		if contentType, ok := segmentContentTypes[ext]; ok {
+			return contentType
+		}
+		return "application/octet-stream"
+	}
+}
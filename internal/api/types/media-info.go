@@ -0,0 +1,39 @@
+package types
+
+import "fmt"
+
+type MediaInfo struct {
+	Container         string
+	Duration          float64
+	Bitrate           int64
+	Size              int64
+	VideoCodec        string
+	VideoProfile      string
+	VideoLevel        int
+	Width             int
+	Height            int
+	Rotation          int
+	SampleAspectRatio string
+	FrameRate         float64
+	AudioCodec        string
+	AudioProfile      string
+	AudioChannels     int
+}
+
+// DisplaySize returns the frame size as shown to the viewer,
+// with the sample aspect ratio and rotation applied
+func (m MediaInfo) DisplaySize() (int, int) {
+	width, height := m.Width, m.Height
+
+	var sarNum, sarDen int
+	if _, err := fmt.Sscanf(m.SampleAspectRatio, "%d:%d", &sarNum, &sarDen); err == nil &&
+		sarNum > 0 && sarDen > 0 && sarNum != sarDen {
+		width = width * sarNum / sarDen
+	}
+
+	if m.Rotation == 90 || m.Rotation == 270 {
+		width, height = height, width
+	}
+
+	return width, height
+}
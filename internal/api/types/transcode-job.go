@@ -0,0 +1,40 @@
+package types
+
+import (
+	"go-fitness/internal/api/enum"
+	"time"
+)
+
+type TranscodeJob struct {
+	ID             int64
+	VideoID        int64
+	Kind           enum.TranscodeJobKind
+	AudioTrackID   int64
+	UploadPath     string
+	DstPath        string
+	ChunkHash      string
+	State          enum.TranscodeJobState
+	Attempts       int
+	LeaseOwner     string
+	LeaseExpiresAt *time.Time
+	AvailableAt    *time.Time
+	LastError      string
+	Progress       TranscodeProgress
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type TranscodeProgress struct {
+	Overall    float64            `json:"overall"`
+	Renditions map[string]float64 `json:"renditions"`
+}
+
+type TranscodeJobAttempt struct {
+	ID         int64
+	JobID      int64
+	Attempt    int
+	Worker     string
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
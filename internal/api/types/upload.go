@@ -0,0 +1,21 @@
+package types
+
+import (
+	"go-fitness/internal/api/enum"
+	"time"
+)
+
+type Upload struct {
+	ID          int64
+	UUID        string
+	Filename    string
+	Name        string
+	Description string
+	Size        int64
+	Offset      int64
+	Status      enum.UploadStatus
+	// Node is the instance holding the partial file, empty for uploads created before nodes were recorded
+	Node      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,23 @@
+package types
+
+import (
+	"go-fitness/internal/api/enum"
+	"time"
+)
+
+// VideoAudioTrack is an alternate audio rendition of a source, keyed by the content hash
+// so deduplicated videos share it. Bandwidth and codecs are known once it is ready.
+type VideoAudioTrack struct {
+	ID               int64
+	HashName         string
+	Language         string
+	Name             string
+	Kind             enum.AudioTrackKind
+	IsDefault        bool
+	State            enum.AudioTrackState
+	Bandwidth        int64
+	AverageBandwidth int64
+	Codecs           string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
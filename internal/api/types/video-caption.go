@@ -0,0 +1,15 @@
+package types
+
+import "time"
+
+// VideoCaption is a subtitle track of a source in one language.
+// Like the renditions it is keyed by the content hash, so deduplicated videos share it.
+type VideoCaption struct {
+	ID        int64
+	HashName  string
+	Language  string
+	Name      string
+	IsDefault bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,14 @@
+package types
+
+import "time"
+
+// VideoKey is an AES-128 key encrypting the HLS segments of a source.
+// Index is the position of the key in the rotation, segment n of every media playlist
+// being encrypted with key n / rotation.
+type VideoKey struct {
+	ID        int64
+	HashName  string
+	Index     int
+	Key       []byte
+	CreatedAt time.Time
+}
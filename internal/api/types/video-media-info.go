@@ -0,0 +1,20 @@
+package types
+
+import (
+	"go-fitness/internal/api/enum"
+	"time"
+)
+
+// VideoMediaInfo is the technical metadata of a source or of one of its renditions.
+// It is keyed by the content hash, so deduplicated videos share it.
+type VideoMediaInfo struct {
+	ID       int64
+	HashName string
+	Kind     enum.MediaInfoKind
+	Label    string
+	MediaInfo
+	PeakBitrate  int64
+	SegmentCount int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
@@ -13,9 +13,11 @@ type Video struct {
 	Description string
 	Status      enum.VideoStatus
 	Duration    float64
-	DeletedAt   *time.Time
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	// Poster is the base name of the custom poster images, empty when the extracted poster is used
+	Poster    string
+	DeletedAt *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 type VideoPosition struct {
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS video_uploads
+(
+    id            BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    uuid          CHAR(36)     NOT NULL,
+    filename      VARCHAR(255) NOT NULL,
+    name          VARCHAR(255) NOT NULL,
+    description   TEXT         NULL,
+    size          BIGINT       NOT NULL,
+    upload_offset BIGINT       NOT NULL DEFAULT 0,
+    status        TINYINT      NOT NULL DEFAULT 0,
+    created_at    TIMESTAMP    NULL,
+    updated_at    TIMESTAMP    NULL,
+    UNIQUE KEY video_uploads_uuid_unique (uuid)
+);
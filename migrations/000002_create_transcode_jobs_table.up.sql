@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS transcode_jobs
+(
+    id               BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    video_id         BIGINT UNSIGNED NOT NULL,
+    upload_path      VARCHAR(512)    NOT NULL,
+    dst_path         VARCHAR(512)    NOT NULL,
+    chunk_hash       VARCHAR(64)     NOT NULL,
+    state            TINYINT         NOT NULL DEFAULT 0,
+    attempts         INT             NOT NULL DEFAULT 0,
+    lease_owner      VARCHAR(255)    NULL,
+    lease_expires_at TIMESTAMP       NULL,
+    last_error       TEXT            NULL,
+    created_at       TIMESTAMP       NULL,
+    updated_at       TIMESTAMP       NULL,
+    KEY transcode_jobs_state_lease_index (state, lease_expires_at),
+    KEY transcode_jobs_video_id_index (video_id)
+);
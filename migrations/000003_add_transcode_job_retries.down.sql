@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS transcode_job_attempts;
+
+ALTER TABLE transcode_jobs
+    DROP COLUMN available_at;
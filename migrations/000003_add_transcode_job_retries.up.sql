@@ -0,0 +1,14 @@
+ALTER TABLE transcode_jobs
+    ADD COLUMN available_at TIMESTAMP NULL AFTER lease_expires_at;
+
+CREATE TABLE IF NOT EXISTS transcode_job_attempts
+(
+    id          BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    job_id      BIGINT UNSIGNED NOT NULL,
+    attempt     INT             NOT NULL,
+    worker      VARCHAR(255)    NOT NULL,
+    error       TEXT            NULL,
+    started_at  TIMESTAMP       NULL,
+    finished_at TIMESTAMP       NULL,
+    KEY transcode_job_attempts_job_id_index (job_id)
+);
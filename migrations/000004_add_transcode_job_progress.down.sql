@@ -0,0 +1,2 @@
+ALTER TABLE transcode_jobs
+    DROP COLUMN progress;
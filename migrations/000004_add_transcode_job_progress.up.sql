@@ -0,0 +1,2 @@
+ALTER TABLE transcode_jobs
+    ADD COLUMN progress TEXT NULL AFTER last_error;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS video_media_info
+(
+    id                  BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    hash_name           VARCHAR(64)     NOT NULL,
+    kind                TINYINT         NOT NULL DEFAULT 0,
+    label               VARCHAR(32)     NOT NULL DEFAULT '',
+    container           VARCHAR(128)    NOT NULL DEFAULT '',
+    duration            DOUBLE          NOT NULL DEFAULT 0,
+    bitrate             BIGINT          NOT NULL DEFAULT 0,
+    peak_bitrate        BIGINT          NOT NULL DEFAULT 0,
+    size                BIGINT          NOT NULL DEFAULT 0,
+    segment_count       INT             NOT NULL DEFAULT 0,
+    video_codec         VARCHAR(32)     NOT NULL DEFAULT '',
+    video_profile       VARCHAR(64)     NOT NULL DEFAULT '',
+    video_level         INT             NOT NULL DEFAULT 0,
+    width               INT             NOT NULL DEFAULT 0,
+    height              INT             NOT NULL DEFAULT 0,
+    rotation            INT             NOT NULL DEFAULT 0,
+    sample_aspect_ratio VARCHAR(16)     NOT NULL DEFAULT '',
+    frame_rate          DOUBLE          NOT NULL DEFAULT 0,
+    audio_codec         VARCHAR(32)     NOT NULL DEFAULT '',
+    audio_profile       VARCHAR(64)     NOT NULL DEFAULT '',
+    audio_channels      INT             NOT NULL DEFAULT 0,
+    created_at          TIMESTAMP       NULL,
+    updated_at          TIMESTAMP       NULL,
+    UNIQUE KEY video_media_info_hash_kind_label_unique (hash_name, kind, label)
+);
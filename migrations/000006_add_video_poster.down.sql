@@ -0,0 +1,2 @@
+ALTER TABLE videos
+    DROP COLUMN poster;
@@ -0,0 +1,2 @@
+ALTER TABLE videos
+    ADD COLUMN poster VARCHAR(64) NOT NULL DEFAULT '' AFTER duration;
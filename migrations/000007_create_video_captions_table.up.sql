@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS video_captions
+(
+    id         BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    hash_name  VARCHAR(64)  NOT NULL,
+    language   VARCHAR(35)  NOT NULL,
+    name       VARCHAR(255) NOT NULL,
+    is_default TINYINT(1)   NOT NULL DEFAULT 0,
+    created_at TIMESTAMP    NULL,
+    updated_at TIMESTAMP    NULL,
+    UNIQUE KEY video_captions_hash_language_unique (hash_name, language)
+);
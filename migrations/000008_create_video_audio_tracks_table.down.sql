@@ -0,0 +1,6 @@
+ALTER TABLE transcode_jobs
+    DROP COLUMN audio_track_id;
+ALTER TABLE transcode_jobs
+    DROP COLUMN kind;
+
+DROP TABLE IF EXISTS video_audio_tracks;
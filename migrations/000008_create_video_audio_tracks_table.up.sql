@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS video_audio_tracks
+(
+    id                BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    hash_name         VARCHAR(64)  NOT NULL,
+    language          VARCHAR(35)  NOT NULL,
+    name              VARCHAR(255) NOT NULL,
+    kind              TINYINT      NOT NULL DEFAULT 0,
+    is_default        TINYINT(1)   NOT NULL DEFAULT 0,
+    state             TINYINT      NOT NULL DEFAULT 0,
+    bandwidth         BIGINT       NOT NULL DEFAULT 0,
+    average_bandwidth BIGINT       NOT NULL DEFAULT 0,
+    codecs            VARCHAR(64)  NOT NULL DEFAULT '',
+    created_at        TIMESTAMP    NULL,
+    updated_at        TIMESTAMP    NULL,
+    UNIQUE KEY video_audio_tracks_hash_language_kind_unique (hash_name, language, kind)
+);
+
+ALTER TABLE transcode_jobs
+    ADD COLUMN kind TINYINT NOT NULL DEFAULT 1 AFTER video_id;
+ALTER TABLE transcode_jobs
+    ADD COLUMN audio_track_id BIGINT UNSIGNED NULL AFTER kind;
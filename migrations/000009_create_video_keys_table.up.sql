@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS video_keys
+(
+    id         BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    hash_name  VARCHAR(64) NOT NULL,
+    key_index  INT         NOT NULL,
+    key_bytes  BINARY(16)  NOT NULL,
+    created_at TIMESTAMP   NULL,
+    UNIQUE KEY video_keys_hash_index_unique (hash_name, key_index)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE video_uploads
+    DROP COLUMN node;
@@ -0,0 +1,2 @@
+ALTER TABLE video_uploads
+    ADD COLUMN node VARCHAR(255) NOT NULL DEFAULT '' AFTER status;